@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"runtime"
+
+	"gioui.org/layout"
+)
+
+// Action is a command that the sidebar buttons and the keyboard shortcuts share, so that both do the same thing.
+type Action func(gtx layout.Context, state *GemPaintState)
+
+// actions are all the commands, by the name that the keymap file uses for them.
+var actions = map[string]Action{
+	"brush":            selectTool(Brush),
+	"eraser":           selectTool(Eraser),
+	"bucket":           selectTool(Bucket),
+	"eyedropper":       selectTool(Eyedropper),
+	"crop":             selectTool(Crop),
+	"rectangle-select": selectTool(RectangleSelect),
+	"ellipse-select":   selectTool(EllipseSelect),
+	"lasso":            selectTool(Lasso),
+	"polygon-lasso":    selectTool(PolygonLasso),
+	"magic-wand":       selectTool(MagicWand),
+	"transform":        selectTool(Transform),
+	"line":             selectTool(Line),
+	"rectangle-shape":  selectTool(RectangleShape),
+	"ellipse-shape":    selectTool(EllipseShape),
+	"polygon-shape":    selectTool(PolygonShape),
+	"pen":              selectTool(Pen),
+	"text":             selectTool(Text),
+
+	"increase-radius": func(gtx layout.Context, state *GemPaintState) { changeCursorRadius(state, cursorRadiusChangeStep) },
+	"decrease-radius": func(gtx layout.Context, state *GemPaintState) { changeCursorRadius(state, -cursorRadiusChangeStep) },
+
+	"color-picker": func(gtx layout.Context, state *GemPaintState) { state.colorPicker.open = !state.colorPicker.open },
+	"palettes":     func(gtx layout.Context, state *GemPaintState) { openDialog(state, newPaletteDialog(state)) },
+	"swap-colors":  func(gtx layout.Context, state *GemPaintState) { swapColors(state) },
+	"reset-colors": func(gtx layout.Context, state *GemPaintState) { resetColors(state) },
+
+	"undo": whenIdle(func(gtx layout.Context, state *GemPaintState) {
+		undone := state.history.Undo(state)
+		if debug && undone {
+			fmt.Println("Undo")
+		}
+	}),
+	"redo": whenIdle(func(gtx layout.Context, state *GemPaintState) {
+		redone := state.history.Redo(state)
+		if debug && redone {
+			fmt.Println("Redo")
+		}
+	}),
+
+	"new": whenIdle(func(gtx layout.Context, state *GemPaintState) {
+		openDialog(state, newNewDocumentDialog(state.document))
+	}),
+	"clear":       whenIdle(func(gtx layout.Context, state *GemPaintState) { clearActiveLayer(state) }),
+	"save":        func(gtx layout.Context, state *GemPaintState) { savePNG(state) },
+	"export-svg":  func(gtx layout.Context, state *GemPaintState) { exportSVG(state) },
+	"image-size":  whenIdle(func(gtx layout.Context, state *GemPaintState) { openDialog(state, newImageSizeDialog(state.document)) }),
+	"canvas-size": whenIdle(func(gtx layout.Context, state *GemPaintState) { openDialog(state, newCanvasSizeDialog(state.document)) }),
+	"flip": whenIdle(func(gtx layout.Context, state *GemPaintState) {
+		transformImageLosslessly(state, flipHorizontalTransform)
+		if debug {
+			fmt.Println("Image flipped")
+		}
+	}),
+	"rotate": whenIdle(func(gtx layout.Context, state *GemPaintState) { openDialog(state, newRotateDialog()) }),
+
+	"cut":               whenIdle(cutToClipboard),
+	"copy":              whenIdle(copyToClipboard),
+	"paste":             whenIdle(pasteFromClipboard),
+	"select":            whenIdle(func(gtx layout.Context, state *GemPaintState) { openDialog(state, newSelectDialog()) }),
+	"select-all":        whenIdle(func(gtx layout.Context, state *GemPaintState) { state.selection = selectAll(state.document.Bounds()) }),
+	"deselect":          whenIdle(func(gtx layout.Context, state *GemPaintState) { state.selection = nil }),
+	"crop-to-selection": whenIdle(func(gtx layout.Context, state *GemPaintState) { cropToSelection(state) }),
+}
+
+// runAction runs the action called name.
+func runAction(gtx layout.Context, state *GemPaintState, name string) {
+	action, ok := actions[name]
+	if !ok {
+		if debug {
+			fmt.Println("Error: Unknown action: ", name)
+		}
+		return
+	}
+	action(gtx, state)
+}
+
+// whenIdle makes action do nothing while a job is running, since it changes the canvas.
+func whenIdle(action Action) Action {
+	return func(gtx layout.Context, state *GemPaintState) {
+		if state.job == nil {
+			action(gtx, state)
+		}
+	}
+}
+
+// selectTool waits for the running job too, since switching tools applies a transform or finishes the text.
+func selectTool(tool SelectedTool) Action {
+	return whenIdle(func(gtx layout.Context, state *GemPaintState) {
+		state.selectedTool = tool
+		state.previousPaintPosition = mouseIsOutsideCanvas
+		if tool == Transform {
+			startFreeTransform(state)
+		}
+		if debug {
+			fmt.Println("Current tool: ", state.selectedTool)
+		}
+	})
+}
+
+func changeCursorRadius(state *GemPaintState, change int) {
+	state.cursorRadius = min(max(state.cursorRadius+change, minimumCursorRadius), maximumCursorRadius)
+	if debug {
+		fmt.Println("Cursor radius: ", state.cursorRadius)
+	}
+}
+
+// clearActiveLayer clears the bottom layer to the document's background, and the layers above it to transparent.
+func clearActiveLayer(state *GemPaintState) {
+	background := transparent
+	if state.activeLayerIndex == 0 {
+		background = state.document.Background
+	}
+	commitLayerChange(state, clearedLayerImage(state, background))
+	if state.selection == nil { // The vector shapes go too, unless only part of the layer is cleared
+		activeLayer(state).Shapes = nil
+	}
+	if debug {
+		fmt.Println("Canvas cleared")
+	}
+}
+
+// clearedLayerImage returns the image of the active layer with what is selected cleared to col. Without a
+// selection all of it is cleared.
+func clearedLayerImage(state *GemPaintState, col color.Color) *TiledCanvas {
+	original := activeLayer(state).Image
+	if state.selection == nil {
+		return newTiledCanvas(original.Rect, col)
+	}
+
+	rect := selectionBounds(state.selection)
+	cleared := original.Clone()
+	cleared.Draw(rect, image.NewUniform(col), image.Point{}, draw.Src)
+	maskTileChanges(cleared, original, state.selection, rect)
+	return cleared
+}
+
+func savePNG(state *GemPaintState) {
+	img := flattenLayers(state.layers, state.document.Bounds()) // Flatten on the ui thread, while no one is painting
+	dpi := state.document.DPI
+
+	go func() { // Do not block the ui thread
+
+		extension := "png"
+		fileName := "gem." + extension
+
+		// Depending on the platform, how we access the file system will differ
+		platform := runtime.GOOS
+		if debug {
+			fmt.Println("Saving on GOOS: ", platform)
+		}
+
+		buf := bytes.Buffer{}
+		if err := encodePNG(&buf, img, dpi); err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+		saveFileOnPlatform(state, buf.Bytes(), fileName)
+
+	}()
+}
+
+func exportSVG(state *GemPaintState) {
+	document, layers := state.document, exportLayers(state.layers) // Copy on the ui thread, while no one is painting
+
+	go func() {
+		buf := bytes.Buffer{}
+		if err := encodeSVG(&buf, document, layers); err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+		saveFileOnPlatform(state, buf.Bytes(), "gem.svg")
+	}()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// CanvasSizeDialog extends or shrinks the canvas without scaling the image. The anchor grid decides which
+// side(s) the canvas grows or shrinks from.
+type CanvasSizeDialog struct {
+	widthEditor  widget.Editor
+	heightEditor widget.Editor
+	colorEditor  widget.Editor
+
+	anchorButtons [3][3]widget.Clickable // [y][x]
+	anchor        image.Point
+	fill          widget.Enum
+
+	okButton     widget.Clickable
+	cancelButton widget.Clickable
+
+	errorMessage string
+}
+
+func newCanvasSizeDialog(document Document) *CanvasSizeDialog {
+	d := &CanvasSizeDialog{anchor: image.Point{X: 1, Y: 1}}
+
+	for _, editor := range []*widget.Editor{&d.widthEditor, &d.heightEditor} {
+		editor.SingleLine = true
+		editor.Filter = "0123456789"
+	}
+	d.colorEditor.SingleLine = true
+
+	d.widthEditor.SetText(strconv.Itoa(document.Width))
+	d.heightEditor.SetText(strconv.Itoa(document.Height))
+	d.colorEditor.SetText(formatHexColor(defaultCanvasColor))
+	d.fill.Value = whiteBackground
+
+	return d
+}
+
+func (d *CanvasSizeDialog) Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	for y := range d.anchorButtons {
+		for x := range d.anchorButtons[y] {
+			if d.anchorButtons[y][x].Clicked(gtx) {
+				d.anchor = image.Point{X: x, Y: y}
+			}
+		}
+	}
+
+	if d.cancelButton.Clicked(gtx) {
+		closeDialog(state)
+	}
+
+	if d.okButton.Clicked(gtx) {
+		if err := d.apply(state); err != nil {
+			d.errorMessage = err.Error()
+		} else {
+			closeDialog(state)
+		}
+	}
+
+	return layoutDialog(gtx, state, theme, "Canvas Size", func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Width (px)", &d.widthEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Height (px)", &d.heightEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(material.Body1(theme, "Anchor").Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return d.layoutAnchorGrid(gtx, theme)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(material.Body1(theme, "Fill new areas with").Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(material.RadioButton(theme, &d.fill, whiteBackground, "White").Layout),
+					layout.Rigid(material.RadioButton(theme, &d.fill, transparentBackground, "Transparent").Layout),
+					layout.Rigid(material.RadioButton(theme, &d.fill, customBackground, "Custom").Layout),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layoutLabeledEditor(gtx, theme, "Hex color", &d.colorEditor)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogError(gtx, theme, d.errorMessage)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				ok := material.Button(theme, &d.okButton, "Apply")
+				ok.Background = golangBlue
+				cancel := material.Button(theme, &d.cancelButton, "Cancel")
+				cancel.Background = darkGray
+				return layoutDialogButtons(gtx, theme, cancel, ok)
+			}),
+		)
+	})
+}
+
+func (d *CanvasSizeDialog) layoutAnchorGrid(gtx layout.Context, theme *material.Theme) layout.Dimensions {
+	rows := make([]layout.FlexChild, 0, 3)
+	for y := range d.anchorButtons {
+		y := y
+		rows = append(rows, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			columns := make([]layout.FlexChild, 0, 3)
+			for x := range d.anchorButtons[y] {
+				x := x
+				columns = append(columns, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					isSelected := d.anchor == image.Point{X: x, Y: y}
+					button := ToolButton(theme, &d.anchorButtons[y][x], AnchorIcon, isSelected, golangBlue, lightGray, "Anchor")
+					return layout.UniformInset(2).Layout(gtx, button.Layout)
+				}))
+			}
+			return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, columns...)
+		}))
+	}
+
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, rows...)
+}
+
+func (d *CanvasSizeDialog) apply(state *GemPaintState) error {
+	width, err := editorInt(&d.widthEditor)
+	if err != nil || width < 1 || width > maximumCanvasSize {
+		return fmt.Errorf("width must be between 1 and %d", maximumCanvasSize)
+	}
+
+	height, err := editorInt(&d.heightEditor)
+	if err != nil || height < 1 || height > maximumCanvasSize {
+		return fmt.Errorf("height must be between 1 and %d", maximumCanvasSize)
+	}
+
+	var fill color.NRGBA
+	switch d.fill.Value {
+	case whiteBackground:
+		fill = defaultCanvasColor
+	case transparentBackground:
+		fill = transparent
+	case customBackground:
+		fill, err = parseHexColor(d.colorEditor.Text())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the bottom layer is filled, the new areas of the layers above it stay transparent.
+	images := make([]*image.RGBA, len(state.layers))
+	for i, img := range layerImages(state.layers) {
+		layerFill := fill
+		if i > 0 {
+			layerFill = transparent
+		}
+		images[i] = resizeCanvasRGBA(img, width, height, d.anchor, layerFill)
+	}
+
+	offset := canvasOffset(state.document.Bounds().Size(), width, height, d.anchor)
+	commitImageChange(state, images, translationMatrix(float64(offset.X), float64(offset.Y)))
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image"
+
+	"gioui.org/op/paint"
+)
+
+// checkerboard is drawn under the canvas so that transparent pixels are visible. The image op is kept
+// between frames, so the checkerboard is only uploaded to the gpu again when the canvas size changes.
+type checkerboard struct {
+	size image.Point
+	op   paint.ImageOp
+}
+
+// imageOp returns the checkerboard for a canvas of the given size.
+func (c *checkerboard) imageOp(size image.Point) paint.ImageOp {
+	if c.size == size && c.op.Size() == size {
+		return c.op
+	}
+
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	light, dark := rgbaPixel(checkerboardLight), rgbaPixel(checkerboardDark)
+
+	parallelBands(img.Rect, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				pixel := light
+				if (x/checkerboardSquareSize+y/checkerboardSquareSize)%2 == 1 {
+					pixel = dark
+				}
+
+				offset := img.PixOffset(x, y)
+				copy(img.Pix[offset:offset+4], pixel[:])
+			}
+		}
+	})
+
+	c.size = size
+	c.op = paint.NewImageOp(img)
+	return c.op
+}
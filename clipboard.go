@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"gioui.org/layout"
+)
+
+// Clipboard exchanges images with the system clipboard. How that is done depends on the platform, see
+// writeClipboardImage and readClipboardImage.
+type Clipboard struct {
+	image *image.RGBA // The image last copied in GemPaint, pasted when the system clipboard has no image in it
+
+	received chan *image.RGBA // Images read from the system clipboard, which may arrive a few frames later
+	tag      bool
+}
+
+func newClipboard() Clipboard {
+	return Clipboard{received: make(chan *image.RGBA, 1)}
+}
+
+// pngDataURIPrefix starts the text used to put images on clipboards that only hold text.
+const pngDataURIPrefix = "data:image/png;base64,"
+
+// copySelectionImage returns the selected part of img, cut to the bounds of the selection, with the pixels
+// faded by how much they are selected. Without a selection all of img is copied.
+func copySelectionImage(img *image.RGBA, selection *image.Alpha) *image.RGBA {
+	rect := img.Rect
+	if selection != nil {
+		rect = selectionBounds(selection)
+	}
+
+	copied := cropRGBA(img, rect)
+	if selection == nil {
+		return copied
+	}
+
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			selected := uint32(selection.AlphaAt(rect.Min.X+x, rect.Min.Y+y).A)
+			offset := copied.PixOffset(x, y)
+			for channel := 0; channel < 4; channel++ { // The pixels are premultiplied, so every channel is faded
+				copied.Pix[offset+channel] = uint8((uint32(copied.Pix[offset+channel])*selected + 127) / 255)
+			}
+		}
+	}
+	return copied
+}
+
+func copyToClipboard(gtx layout.Context, state *GemPaintState) {
+	copied := copySelectionImage(layerPixels(activeLayer(state)), state.selection)
+	state.clipboard.image = copied
+	writeClipboardImage(gtx, state, copied)
+
+	if debug {
+		fmt.Println("Copied: ", copied.Rect.Size())
+	}
+}
+
+// cutToClipboard copies the selection, then clears it to transparent as one undo step. The shapes of a vector
+// layer and the text of a text layer can't be cleared like pixels, so they are only copied.
+func cutToClipboard(gtx layout.Context, state *GemPaintState) {
+	copyToClipboard(gtx, state)
+
+	if activeLayer(state).Kind != RasterLayer {
+		if debug {
+			fmt.Println("Error: Only raster layers can be cut from")
+		}
+		return
+	}
+
+	commitLayerChange(state, clearedLayerImage(state, transparent))
+}
+
+// pasteFromClipboard asks for the image on the system clipboard. It becomes a floating selection once it arrives.
+func pasteFromClipboard(gtx layout.Context, state *GemPaintState) {
+	readClipboardImage(gtx, state)
+}
+
+// receiveClipboardImage is called with an image read from the system clipboard, or nil if there was none.
+func receiveClipboardImage(state *GemPaintState, img *image.RGBA) {
+	if img == nil {
+		img = state.clipboard.image
+	}
+	if img == nil {
+		if debug {
+			fmt.Println("Error: There is no image to paste")
+		}
+		return
+	}
+
+	pasteFloating(state, img)
+}
+
+// handleClipboard pastes the images that arrived from the system clipboard since the last frame.
+func handleClipboard(gtx layout.Context, state *GemPaintState) {
+	handlePlatformClipboardEvents(gtx, state)
+
+	for {
+		select {
+		case img := <-state.clipboard.received:
+			receiveClipboardImage(state, img)
+		default:
+			return
+		}
+	}
+}
+
+func encodePNGDataURI(img image.Image, dpi int) (string, error) {
+	buf := bytes.Buffer{}
+	if err := encodePNG(&buf, img, dpi); err != nil {
+		return "", err
+	}
+	return pngDataURIPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodePNGDataURI returns nil when text is not a PNG data URI.
+func decodePNGDataURI(text string) (*image.RGBA, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, pngDataURIPrefix) {
+		return nil, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, pngDataURIPrefix))
+	if err != nil {
+		return nil, err
+	}
+	return decodePNGImage(data)
+}
+
+func decodePNGImage(data []byte) (*image.RGBA, error) {
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Rect, decoded, bounds.Min, draw.Src)
+	return img, nil
+}
@@ -0,0 +1,120 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"syscall/js"
+
+	"gioui.org/layout"
+)
+
+// In the browser, images are exchanged with the async Clipboard API, which can hold real PNG images. Its
+// promises resolve outside of the ui goroutine, so images that are read are sent to state.clipboard.received.
+
+func writeClipboardImage(gtx layout.Context, state *GemPaintState, img *image.RGBA) {
+	clipboard := js.Global().Get("navigator").Get("clipboard")
+	clipboardItem := js.Global().Get("ClipboardItem")
+	if clipboard.IsUndefined() || clipboardItem.IsUndefined() {
+		if debug {
+			fmt.Println("Error: The browser doesn't support copying images")
+		}
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := encodePNG(&buf, img, state.document.DPI); err != nil {
+		if debug {
+			fmt.Println("Error: ", err)
+		}
+		return
+	}
+
+	jsData := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(jsData, buf.Bytes())
+	blob := js.Global().Get("Blob").New([]interface{}{jsData}, map[string]interface{}{"type": "image/png"})
+	item := clipboardItem.New(map[string]interface{}{"image/png": blob})
+
+	var failed js.Func
+	failed = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		defer failed.Release()
+		if debug {
+			fmt.Println("Error: Could not copy the image: ", args[0].Call("toString").String())
+		}
+		return nil
+	})
+	clipboard.Call("write", []interface{}{item}).Call("catch", failed)
+}
+
+func readClipboardImage(gtx layout.Context, state *GemPaintState) {
+	received := state.clipboard.received
+	window := state.window
+	deliver := func(img *image.RGBA) {
+		select {
+		case received <- img:
+		default: // A paste is already waiting to be handled
+		}
+		window.Invalidate()
+	}
+
+	clipboard := js.Global().Get("navigator").Get("clipboard")
+	if clipboard.IsUndefined() || clipboard.Get("read").IsUndefined() {
+		deliver(nil)
+		return
+	}
+
+	var gotItems, gotBlob, gotBuffer, failed js.Func
+	release := func() {
+		gotItems.Release()
+		gotBlob.Release()
+		gotBuffer.Release()
+		failed.Release()
+	}
+
+	gotItems = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		items := args[0]
+		for i := 0; i < items.Length(); i++ {
+			item := items.Index(i)
+			if item.Get("types").Call("includes", "image/png").Bool() {
+				return item.Call("getType", "image/png").Call("then", gotBlob)
+			}
+		}
+
+		release()
+		deliver(nil) // Nothing to paste from the system clipboard
+		return nil
+	})
+	gotBlob = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return args[0].Call("arrayBuffer").Call("then", gotBuffer)
+	})
+	gotBuffer = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		defer release()
+
+		jsData := js.Global().Get("Uint8Array").New(args[0])
+		data := make([]byte, jsData.Length())
+		js.CopyBytesToGo(data, jsData)
+
+		go func() { // Do not block the browser's event loop while decoding
+			img, err := decodePNGImage(data)
+			if err != nil && debug {
+				fmt.Println("Error: ", err)
+			}
+			deliver(img)
+		}()
+		return nil
+	})
+	failed = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		defer release()
+		if debug {
+			fmt.Println("Error: Could not read the clipboard: ", args[0].Call("toString").String())
+		}
+		deliver(nil)
+		return nil
+	})
+
+	clipboard.Call("read").Call("then", gotItems).Call("catch", failed)
+}
+
+func handlePlatformClipboardEvents(gtx layout.Context, state *GemPaintState) {}
@@ -0,0 +1,66 @@
+//go:build !js && !wasm
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"gioui.org/io/clipboard"
+	"gioui.org/io/event"
+	"gioui.org/io/transfer"
+	"gioui.org/layout"
+)
+
+// Gio's desktop clipboard only holds text, so images are put on it as PNG data URIs. Images copied from
+// other programs can't be read this way, in which case the image last copied in GemPaint is pasted.
+
+func writeClipboardImage(gtx layout.Context, state *GemPaintState, img *image.RGBA) {
+	text, err := encodePNGDataURI(img, state.document.DPI)
+	if err != nil {
+		if debug {
+			fmt.Println("Error: ", err)
+		}
+		return
+	}
+
+	gtx.Execute(clipboard.WriteCmd{Type: "application/text", Data: io.NopCloser(strings.NewReader(text))})
+}
+
+func readClipboardImage(gtx layout.Context, state *GemPaintState) {
+	gtx.Execute(clipboard.ReadCmd{Tag: &state.clipboard.tag})
+}
+
+func handlePlatformClipboardEvents(gtx layout.Context, state *GemPaintState) {
+	event.Op(gtx.Ops, &state.clipboard.tag)
+
+	for {
+		ev, ok := gtx.Event(transfer.TargetFilter{Target: &state.clipboard.tag, Type: "application/text"})
+		if !ok {
+			break
+		}
+
+		dataEvent, ok := ev.(transfer.DataEvent)
+		if !ok {
+			continue
+		}
+
+		reader := dataEvent.Open()
+		text, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			continue
+		}
+
+		img, err := decodePNGDataURI(string(text))
+		if err != nil && debug {
+			fmt.Println("Error: ", err)
+		}
+		receiveClipboardImage(state, img)
+	}
+}
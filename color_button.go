@@ -4,7 +4,10 @@ import (
 	"image"
 	"image/color"
 
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
 	"gioui.org/layout"
+	"gioui.org/op"
 	"gioui.org/op/clip"
 	"gioui.org/op/paint"
 	"gioui.org/unit"
@@ -16,9 +19,11 @@ type ColorButtonStyle struct {
 	Color color.NRGBA
 	Label string
 
-	Clickable  *widget.Clickable
-	isSelected bool
-	OnClick    func()
+	Clickable        *widget.Clickable
+	isSelected       bool
+	OnClick          func()
+	OnSecondaryClick func() // Called when the button is right-clicked
+	Small            bool   // Drawn at less than half the size, eg. for the recent colors
 }
 
 func ColorButton(color color.NRGBA, label string, onClick func()) ColorButtonStyle {
@@ -31,8 +36,34 @@ func ColorButton(color color.NRGBA, label string, onClick func()) ColorButtonSty
 }
 
 func (cb *ColorButtonStyle) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
+	// Right-clicks aren't clicks to the Clickable, so the button's area listens for them itself.
+	for {
+		ev, ok := gtx.Event(pointer.Filter{Target: cb, Kinds: pointer.Press})
+		if !ok {
+			break
+		}
+		if e, ok := ev.(pointer.Event); ok && e.Buttons.Contain(pointer.ButtonSecondary) && cb.OnSecondaryClick != nil {
+			cb.OnSecondaryClick()
+		}
+	}
+
+	macro := op.Record(gtx.Ops)
+	dimensions := cb.layoutButton(gtx, th)
+	call := macro.Stop()
+
+	defer clip.Rect{Max: dimensions.Size}.Push(gtx.Ops).Pop()
+	event.Op(gtx.Ops, cb)
+	call.Add(gtx.Ops)
+	return dimensions
+}
+
+func (cb *ColorButtonStyle) layoutButton(gtx layout.Context, th *material.Theme) layout.Dimensions {
 	btn := material.IconButton(th, cb.Clickable, nil, cb.Label)
 	btn.Background = cb.Color
+	if cb.Small {
+		btn.Size = unit.Dp(8)
+		btn.Inset = layout.UniformInset(6)
+	}
 
 	if !cb.isSelected {
 		return btn.Layout(gtx)
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+)
+
+// The paint color is the primary color, which the left button paints with. The right button paints with the
+// secondary color instead.
+
+// paintsWithSecondaryColor reports whether the right button does anything with tool. The tools that don't paint
+// ignore it, so that a stray right-click doesn't make a selection or move a point.
+func paintsWithSecondaryColor(tool SelectedTool) bool {
+	switch tool {
+	case Brush, Eraser, Bucket, Eyedropper, Line, RectangleShape, EllipseShape, PolygonShape:
+		return true
+	}
+	return false
+}
+
+// ignoresSecondaryButton reports whether the press, drag or release is from the right button and the current tool
+// or pasted content ignores it.
+func ignoresSecondaryButton(state *GemPaintState) bool {
+	return state.paintingWithSecondary && (state.floating != nil || !paintsWithSecondaryColor(state.selectedTool))
+}
+
+// strokeColor returns the color to paint with, given the button the current stroke or shape was started with.
+func strokeColor(state *GemPaintState) color.NRGBA {
+	if state.paintingWithSecondary {
+		return state.secondaryColor
+	}
+	return state.paintColor
+}
+
+func swapColors(state *GemPaintState) {
+	state.paintColor, state.secondaryColor = state.secondaryColor, state.paintColor
+	if debug {
+		fmt.Println("Swapped colors")
+	}
+}
+
+func resetColors(state *GemPaintState) {
+	state.paintColor, state.secondaryColor = black, white
+	if debug {
+		fmt.Println("Reset colors")
+	}
+}
+
+// layoutColorPair draws the primary color over the secondary one. Clicking them swaps them.
+func layoutColorPair(gtx layout.Context, state *GemPaintState) layout.Dimensions {
+	if state.colorPairButton.Clicked(gtx) {
+		runAction(gtx, state, "swap-colors")
+	}
+
+	return state.colorPairButton.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		size := gtx.Dp(unit.Dp(48))
+		square := gtx.Dp(unit.Dp(32))
+
+		secondary := image.Rect(size-square, size-square, size, size)
+		paint.FillShape(gtx.Ops, state.secondaryColor, clip.Rect(secondary).Op())
+		strokeRect(gtx, secondary, darkGray)
+
+		primary := image.Rect(0, 0, square, square)
+		paint.FillShape(gtx.Ops, state.paintColor, clip.Rect(primary).Op())
+		strokeRect(gtx, primary, darkGray)
+
+		return layout.Dimensions{Size: image.Pt(size, size)}
+	})
+}
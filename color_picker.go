@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"gioui.org/f32"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// ColorPicker is a panel for picking any paint color: a hue ring around a saturation and value square, sliders
+// for the channels of a color model, and a hex field. The color is kept as HSV, so that the hue isn't lost while
+// the color is gray or black.
+type ColorPicker struct {
+	open bool
+
+	hue        float64 // In degrees
+	saturation float64
+	value      float64
+	alpha      float64
+	shown      color.NRGBA // The paint color the picker was last synced with
+
+	wheelTag  bool
+	wheelDrag colorWheelPart
+
+	model       widget.Enum     // A ColorModel
+	sliders     [3]widget.Float // The channels of the model
+	alphaSlider widget.Float
+	hexEditor   widget.Editor
+}
+
+type colorWheelPart int
+
+const (
+	wheelNone colorWheelPart = iota
+	wheelRing
+	wheelSquare
+)
+
+// ColorModel is the set of channels the sliders show.
+type ColorModel string
+
+const (
+	ModelRGB ColorModel = "RGB"
+	ModelHSL ColorModel = "HSL"
+	ModelHSV ColorModel = "HSV"
+)
+
+var colorModels = []ColorModel{ModelRGB, ModelHSL, ModelHSV}
+
+var colorWheelSize = unit.Dp(200)
+var colorWheelRingWidth = unit.Dp(18)
+var colorWheelSegments = 120
+
+func newColorPicker() ColorPicker {
+	picker := ColorPicker{}
+	picker.model.Value = string(ModelRGB)
+	picker.hexEditor.SingleLine = true
+	picker.hexEditor.Filter = "#0123456789abcdefABCDEF"
+	return picker
+}
+
+func (p *ColorPicker) color() color.NRGBA {
+	r, g, b := hsvToRGB(p.hue, p.saturation, p.value)
+	return color.NRGBA{R: toChannel(r), G: toChannel(g), B: toChannel(b), A: toChannel(p.alpha)}
+}
+
+// setColor shows c. A gray keeps the hue the picker had, and black its saturation too.
+func (p *ColorPicker) setColor(c color.NRGBA) {
+	h, s, v := rgbToHSV(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+	if s > 0 {
+		p.hue = h
+	}
+	if v > 0 {
+		p.saturation = s
+	}
+	p.value = v
+	p.alpha = float64(c.A) / 255
+}
+
+func toChannel(v float64) uint8 {
+	return uint8(math.Round(min(max(v, 0), 1) * 255))
+}
+
+// channels returns the channels of the color in the model picked for the sliders, from 0 to 1.
+func (p *ColorPicker) channels() [3]float64 {
+	switch ColorModel(p.model.Value) {
+	case ModelHSV:
+		return [3]float64{p.hue / 360, p.saturation, p.value}
+	case ModelHSL:
+		s, l := hsvToHSL(p.saturation, p.value)
+		return [3]float64{p.hue / 360, s, l}
+	}
+	r, g, b := hsvToRGB(p.hue, p.saturation, p.value)
+	return [3]float64{r, g, b}
+}
+
+// setChannels sets the color from the channels of the model picked for the sliders.
+func (p *ColorPicker) setChannels(c [3]float64) {
+	switch ColorModel(p.model.Value) {
+	case ModelHSV:
+		p.hue, p.saturation, p.value = c[0]*360, c[1], c[2]
+	case ModelHSL:
+		p.hue = c[0] * 360
+		p.saturation, p.value = hslToHSV(c[1], c[2])
+	default:
+		alpha := p.alpha
+		p.setColor(color.NRGBA{R: toChannel(c[0]), G: toChannel(c[1]), B: toChannel(c[2])})
+		p.alpha = alpha
+	}
+}
+
+// channelLabels returns the names of the channels of the model, and how they are shown.
+func channelLabels(model ColorModel) (names [3]string, scales [3]float64) {
+	switch model {
+	case ModelHSV:
+		return [3]string{"H", "S", "V"}, [3]float64{360, 100, 100}
+	case ModelHSL:
+		return [3]string{"H", "S", "L"}, [3]float64{360, 100, 100}
+	}
+	return [3]string{"R", "G", "B"}, [3]float64{255, 255, 255}
+}
+
+func layoutColorPicker(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	picker := &state.colorPicker
+	if state.paintColor != picker.shown { // It was picked elsewhere, eg. with the color buttons or the eyedropper
+		picker.setColor(state.paintColor)
+	}
+
+	handleColorWheelPointer(gtx, picker)
+
+	channels := picker.channels()
+	for i := range picker.sliders {
+		if picker.sliders[i].Update(gtx) {
+			channels[i] = float64(picker.sliders[i].Value)
+			picker.setChannels(channels)
+		}
+	}
+	if picker.alphaSlider.Update(gtx) {
+		picker.alpha = float64(picker.alphaSlider.Value)
+	}
+
+	for {
+		ev, ok := picker.hexEditor.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.ChangeEvent); !ok || !gtx.Focused(&picker.hexEditor) {
+			continue
+		}
+		if typed, err := parseHexColor(picker.hexEditor.Text()); err == nil {
+			picker.setColor(typed)
+		}
+	}
+
+	state.paintColor = picker.color()
+	picker.shown = state.paintColor
+
+	// Show the color as it is now in the sliders and the hex field, unless it is being typed.
+	channels = picker.channels()
+	for i := range picker.sliders {
+		picker.sliders[i].Value = float32(channels[i])
+	}
+	picker.alphaSlider.Value = float32(picker.alpha)
+	if hex := formatHexColor(state.paintColor); !gtx.Focused(&picker.hexEditor) && picker.hexEditor.Text() != hex {
+		picker.hexEditor.SetText(hex)
+	}
+
+	names, scales := channelLabels(ColorModel(picker.model.Value))
+	sliderRow := func(label string, slider *widget.Float, shown string) layout.FlexChild {
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(unit.Dp(20))
+					return material.Body2(theme, label).Layout(gtx)
+				}),
+				layout.Flexed(1, material.Slider(theme, slider).Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(unit.Dp(36))
+					return material.Body2(theme, shown).Layout(gtx)
+				}),
+			)
+		})
+	}
+
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layoutColorWheel(gtx, picker)
+			})
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			var models []layout.FlexChild
+			for _, model := range colorModels {
+				models = append(models, layout.Rigid(material.RadioButton(theme, &picker.model, string(model), string(model)).Layout))
+			}
+			return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, models...)
+		}),
+	}
+	for i := range picker.sliders {
+		children = append(children, sliderRow(names[i], &picker.sliders[i], fmt.Sprintf("%.0f", channels[i]*scales[i])))
+	}
+	children = append(children,
+		sliderRow("A", &picker.alphaSlider, fmt.Sprintf("%.0f", picker.alpha*100)),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(4)}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					swatch := image.Rectangle{Max: image.Pt(gtx.Dp(unit.Dp(32)), gtx.Dp(unit.Dp(24)))}
+					paint.FillShape(gtx.Ops, state.paintColor, clip.Rect(swatch).Op())
+					strokeRect(gtx, swatch, darkGray)
+					return layout.Dimensions{Size: swatch.Max}
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+				layout.Rigid(material.Body2(theme, "Hex ").Layout),
+				layout.Flexed(1, material.Editor(theme, &picker.hexEditor, "#rrggbb").Layout),
+			)
+		}),
+	)
+
+	return layoutPanel(gtx, picker, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(10).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(unit.Dp(240))
+			gtx.Constraints.Max.X = gtx.Constraints.Min.X
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+		})
+	})
+}
+
+// colorWheelGeometry returns the center of the wheel, the radii of its ring, and its square, in pixels.
+func colorWheelGeometry(gtx layout.Context) (center f32.Point, outer, inner float32, square image.Rectangle) {
+	size := gtx.Dp(colorWheelSize)
+	outer = float32(size) / 2
+	inner = outer - float32(gtx.Dp(colorWheelRingWidth))
+	center = f32.Pt(outer, outer)
+
+	half := int(inner/math.Sqrt2) - gtx.Dp(unit.Dp(4)) // Leave a gap between the square and the ring
+	c := image.Pt(size/2, size/2)
+	return center, outer, inner, image.Rectangle{Min: c, Max: c}.Inset(-half)
+}
+
+// handleColorWheelPointer picks the hue when the ring is dragged, and the saturation and value when the square
+// is. A drag keeps changing what it started on, even when the pointer leaves it.
+func handleColorWheelPointer(gtx layout.Context, picker *ColorPicker) {
+	center, outer, inner, square := colorWheelGeometry(gtx)
+
+	for {
+		ev, ok := gtx.Event(pointer.Filter{Target: &picker.wheelTag, Kinds: pointer.Press | pointer.Drag | pointer.Release})
+		if !ok {
+			break
+		}
+		p, ok := ev.(pointer.Event)
+		if !ok {
+			continue
+		}
+
+		switch p.Kind {
+		case pointer.Press:
+			d := p.Position.Sub(center)
+			radius := float32(math.Hypot(float64(d.X), float64(d.Y)))
+			switch {
+			case radius >= inner && radius <= outer:
+				picker.wheelDrag = wheelRing
+			case p.Position.X >= float32(square.Min.X) && p.Position.X <= float32(square.Max.X) && p.Position.Y >= float32(square.Min.Y) && p.Position.Y <= float32(square.Max.Y):
+				picker.wheelDrag = wheelSquare
+			default:
+				picker.wheelDrag = wheelNone
+			}
+		case pointer.Release:
+			picker.wheelDrag = wheelNone
+			continue
+		}
+
+		switch picker.wheelDrag {
+		case wheelRing: // Hues go counterclockwise from red on the right
+			d := p.Position.Sub(center)
+			picker.hue = math.Mod(math.Atan2(float64(-d.Y), float64(d.X))*180/math.Pi+360, 360)
+		case wheelSquare: // Saturation goes up to the right, value to the top
+			picker.saturation = min(max(float64(p.Position.X-float32(square.Min.X))/float64(square.Dx()), 0), 1)
+			picker.value = 1 - min(max(float64(p.Position.Y-float32(square.Min.Y))/float64(square.Dy()), 0), 1)
+		}
+	}
+}
+
+// layoutColorWheel draws the hue ring and the saturation and value square of the picker's hue, with markers on
+// the color that is picked.
+func layoutColorWheel(gtx layout.Context, picker *ColorPicker) layout.Dimensions {
+	center, outer, inner, square := colorWheelGeometry(gtx)
+	size := image.Pt(gtx.Dp(colorWheelSize), gtx.Dp(colorWheelSize))
+
+	area := clip.Rect{Max: size}.Push(gtx.Ops)
+	event.Op(gtx.Ops, &picker.wheelTag)
+	area.Pop()
+
+	onCircle := func(radius float32, degrees float64) f32.Point {
+		sin, cos := math.Sincos(degrees * math.Pi / 180)
+		return center.Add(f32.Pt(radius*float32(cos), -radius*float32(sin)))
+	}
+
+	// The ring is made of thin wedges of solid hues. They overlap a little, so no seams show between them.
+	step := 360 / float64(colorWheelSegments)
+	for i := 0; i < colorWheelSegments; i++ {
+		from, to := float64(i)*step, float64(i+1)*step+0.5
+		var wedge clip.Path
+		wedge.Begin(gtx.Ops)
+		wedge.MoveTo(onCircle(outer, from))
+		wedge.LineTo(onCircle(outer, to))
+		wedge.LineTo(onCircle(inner, to))
+		wedge.LineTo(onCircle(inner, from))
+		wedge.Close()
+
+		r, g, b := hsvToRGB((float64(i)+0.5)*step, 1, 1)
+		paint.FillShape(gtx.Ops, color.NRGBA{R: toChannel(r), G: toChannel(g), B: toChannel(b), A: 255}, clip.Outline{Path: wedge.End()}.Op())
+	}
+
+	// The square fades from white to the hue going right, and to black going down.
+	r, g, b := hsvToRGB(picker.hue, 1, 1)
+	pureHue := color.NRGBA{R: toChannel(r), G: toChannel(g), B: toChannel(b), A: 255}
+	topLeft, bottomRight := layout.FPt(square.Min), layout.FPt(square.Max)
+	stack := clip.Rect(square).Push(gtx.Ops)
+	paint.LinearGradientOp{Stop1: topLeft, Color1: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, Stop2: f32.Pt(bottomRight.X, topLeft.Y), Color2: pureHue}.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+	paint.LinearGradientOp{Stop1: topLeft, Color1: color.NRGBA{A: 0}, Stop2: f32.Pt(topLeft.X, bottomRight.Y), Color2: color.NRGBA{A: 255}}.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+	stack.Pop()
+
+	drawColorWheelMarker(gtx, onCircle((outer+inner)/2, picker.hue))
+	drawColorWheelMarker(gtx, f32.Pt(
+		topLeft.X+float32(picker.saturation)*(bottomRight.X-topLeft.X),
+		topLeft.Y+float32(1-picker.value)*(bottomRight.Y-topLeft.Y),
+	))
+
+	return layout.Dimensions{Size: size}
+}
+
+// drawColorWheelMarker draws a ring that can be seen on both light and dark colors.
+func drawColorWheelMarker(gtx layout.Context, center f32.Point) {
+	radius := float32(gtx.Dp(unit.Dp(6)))
+	bounds := image.Rect(int(center.X-radius), int(center.Y-radius), int(center.X+radius), int(center.Y+radius))
+	paint.FillShape(gtx.Ops, darkGray, clip.Stroke{Path: clip.Ellipse(bounds).Path(gtx.Ops), Width: 3}.Op())
+	paint.FillShape(gtx.Ops, defaultCanvasColor, clip.Stroke{Path: clip.Ellipse(bounds).Path(gtx.Ops), Width: 1.5}.Op())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseHexColor parses colors written as #RGB, #RRGGBB or #RRGGBBAA. The leading # is optional.
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+
+	if len(hex) == 3 { // Expand the short form, eg. f80 -> ff8800
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+
+	return color.NRGBA{R: uint8(value >> 24), G: uint8(value >> 16), B: uint8(value >> 8), A: uint8(value)}, nil
+}
+
+// formatHexColor formats a color as #RRGGBB, or #RRGGBBAA if it is not fully opaque.
+func formatHexColor(c color.NRGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// The color models below take and return channels from 0 to 1, and hues in degrees from 0 to 360.
+
+// rgbToHSV converts a color to hue, saturation and value. Grays have no hue, so it is 0 for them.
+func rgbToHSV(r, g, b float64) (h, s, v float64) {
+	maximum, minimum := max(r, g, b), min(r, g, b)
+	if maximum > 0 {
+		s = (maximum - minimum) / maximum
+	}
+	return hue(r, g, b, maximum, minimum), s, maximum
+}
+
+func hsvToRGB(h, s, v float64) (r, g, b float64) {
+	chroma := v * s
+	return hueToRGB(h, chroma, v-chroma)
+}
+
+// rgbToHSL converts a color to hue, saturation and lightness.
+func rgbToHSL(r, g, b float64) (h, s, l float64) {
+	maximum, minimum := max(r, g, b), min(r, g, b)
+	l = (maximum + minimum) / 2
+	if maximum > minimum {
+		s = (maximum - minimum) / (1 - math.Abs(2*l-1))
+	}
+	return hue(r, g, b, maximum, minimum), s, l
+}
+
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	chroma := (1 - math.Abs(2*l-1)) * s
+	return hueToRGB(h, chroma, l-chroma/2)
+}
+
+// hsvToHSL converts the saturation and value of a color to its saturation and lightness, which share its hue.
+func hsvToHSL(s, v float64) (saturation, lightness float64) {
+	lightness = v * (1 - s/2)
+	if lightness > 0 && lightness < 1 {
+		saturation = (v - lightness) / min(lightness, 1-lightness)
+	}
+	return saturation, lightness
+}
+
+func hslToHSV(s, l float64) (saturation, value float64) {
+	value = l + s*min(l, 1-l)
+	if value > 0 {
+		saturation = 2 * (1 - l/value)
+	}
+	return saturation, value
+}
+
+// hue returns where the color is on the color wheel, given its largest and smallest channels.
+func hue(r, g, b, maximum, minimum float64) float64 {
+	chroma := maximum - minimum
+	var h float64
+	switch {
+	case chroma == 0:
+		return 0
+	case maximum == r:
+		h = math.Mod((g-b)/chroma+6, 6)
+	case maximum == g:
+		h = (b-r)/chroma + 2
+	default:
+		h = (r-g)/chroma + 4
+	}
+	return h * 60
+}
+
+// hueToRGB returns the color of hue with chroma, brightened by adding m to every channel.
+func hueToRGB(h, chroma, m float64) (r, g, b float64) {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 60
+	x := chroma * (1 - math.Abs(math.Mod(h, 2)-1))
+	switch int(h) {
+	case 0:
+		r, g, b = chroma, x, 0
+	case 1:
+		r, g, b = x, chroma, 0
+	case 2:
+		r, g, b = 0, chroma, x
+	case 3:
+		r, g, b = 0, x, chroma
+	case 4:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return r + m, g + m, b + m
+}
@@ -0,0 +1,26 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"io/fs"
+	"syscall/js"
+)
+
+// In the browser settings are saved in local storage, since there is no file system to save them to.
+func configStorageKey(name string) string {
+	return "GemPaint." + name
+}
+
+func readConfigOnPlatform(name string) ([]byte, error) {
+	item := js.Global().Get("localStorage").Call("getItem", configStorageKey(name))
+	if item.IsNull() {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(item.String()), nil
+}
+
+func writeConfigOnPlatform(name string, data []byte) error {
+	js.Global().Get("localStorage").Call("setItem", configStorageKey(name), string(data))
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build !js && !wasm
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Settings, like the palettes and the keymap, are saved in the user's config directory, eg. ~/.config/GemPaint on
+// Linux.
+func configFilePath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "GemPaint", name), nil
+}
+
+func readConfigOnPlatform(name string) ([]byte, error) {
+	path, err := configFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func writeConfigOnPlatform(name string, data []byte) error {
+	path, err := configFilePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"image"
 	"image/color"
-	"time"
 
 	"gioui.org/f32"
 	"gioui.org/widget"
@@ -21,11 +20,22 @@ var blue = color.NRGBA{R: 0, G: 0, B: 255, A: 255}
 var yellow = color.NRGBA{R: 255, G: 255, B: 0, A: 255}
 var purple = color.NRGBA{R: 128, G: 0, B: 128, A: 255}
 var darkGray = color.NRGBA{R: 30, G: 30, B: 30, A: 255}
+var black = color.NRGBA{A: 255}
+var white = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+var transparent = color.NRGBA{}
+
+var checkerboardLight = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+var checkerboardDark = color.NRGBA{R: 204, G: 204, B: 204, A: 255}
+var checkerboardSquareSize = 8
 
 var defaultCanvasDimensions = image.Rect(0, 0, 1920, 1080)
 var defaultCanvasBackground = lightGray
 var defaultCanvasColor = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
 
+var defaultDPI = 72
+var maximumDPI = 10000 // Far beyond any printer, and small enough for the pixels per meter written to PNG files
+var maximumCanvasSize = 16384
+
 var mouseIsOutsideCanvas = f32.Point{X: -1, Y: -1}
 
 var defaultCursorRadius = 20
@@ -33,7 +43,7 @@ var minimumCursorRadius = 10
 var maximumCursorRadius = 100
 var cursorRadiusChangeStep = 10
 
-var fillCoolDown = time.Second * 2
+var maximumUndoSteps = 20
 
 var BrushIcon *widget.Icon = func() *widget.Icon {
 	icon, _ := widget.NewIcon(icons.ImageBrush)
@@ -45,6 +55,11 @@ var EraserIcon *widget.Icon = func() *widget.Icon {
 	return icon
 }()
 
+var NewIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionNoteAdd)
+	return icon
+}()
+
 var ClearIcon *widget.Icon = func() *widget.Icon {
 	icon, _ := widget.NewIcon(icons.ActionDelete)
 	return icon
@@ -65,7 +80,202 @@ var SaveIcon *widget.Icon = func() *widget.Icon {
 	return icon
 }()
 
+var UndoIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentUndo)
+	return icon
+}()
+
+var RedoIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentRedo)
+	return icon
+}()
+
+var EyedropperIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageColorize)
+	return icon
+}()
+
+var ColorPickerIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImagePalette)
+	return icon
+}()
+
+var PalettesIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageColorLens)
+	return icon
+}()
+
+var CropIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageCrop)
+	return icon
+}()
+
+var ImageSizeIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImagePhotoSizeSelectLarge)
+	return icon
+}()
+
+var CanvasSizeIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionAspectRatio)
+	return icon
+}()
+
+var AnchorIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImagePanoramaFishEye)
+	return icon
+}()
+
+var FlipIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageFlip)
+	return icon
+}()
+
+var RotateIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageRotateRight)
+	return icon
+}()
+
+var MoveUpIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.NavigationExpandLess)
+	return icon
+}()
+
+var MoveDownIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.NavigationExpandMore)
+	return icon
+}()
+
+var VisibleIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionVisibility)
+	return icon
+}()
+
+var HiddenIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionVisibilityOff)
+	return icon
+}()
+
+var MirrorViewIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionSwapHoriz)
+	return icon
+}()
+
+var RotateViewLeftIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageRotateLeft)
+	return icon
+}()
+
+var RotateViewRightIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageRotateRight)
+	return icon
+}()
+
+var ResetViewIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageCenterFocusStrong)
+	return icon
+}()
+
 var BucketIcon *widget.Icon = func() *widget.Icon {
 	icon, _ := widget.NewIcon(icons.ActionOpacity)
 	return icon
 }()
+
+var RectangleSelectIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageCropSquare)
+	return icon
+}()
+
+var EllipseSelectIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ToggleRadioButtonUnchecked)
+	return icon
+}()
+
+var CropToSelectionIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageCropFree)
+	return icon
+}()
+
+var LassoIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentGesture)
+	return icon
+}()
+
+var PolygonLassoIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionTimeline)
+	return icon
+}()
+
+var MagicWandIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageFlare)
+	return icon
+}()
+
+var SelectIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentSelectAll)
+	return icon
+}()
+
+var CutIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentContentCut)
+	return icon
+}()
+
+var CopyIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentContentCopy)
+	return icon
+}()
+
+var PasteIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentContentPaste)
+	return icon
+}()
+
+var TransformIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageTransform)
+	return icon
+}()
+
+var LineIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionTrendingFlat)
+	return icon
+}()
+
+var RectangleShapeIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ToggleCheckBoxOutlineBlank)
+	return icon
+}()
+
+var EllipseShapeIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionDonutLarge)
+	return icon
+}()
+
+var PolygonShapeIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ActionChangeHistory)
+	return icon
+}()
+
+var PenIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ContentCreate)
+	return icon
+}()
+
+var VectorLayerIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.EditorFormatShapes)
+	return icon
+}()
+
+var RasterizeIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.ImageGridOn)
+	return icon
+}()
+
+var TextIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.EditorTextFields)
+	return icon
+}()
+
+var ExportSVGIcon *widget.Icon = func() *widget.Icon {
+	icon, _ := widget.NewIcon(icons.EditorInsertPhoto)
+	return icon
+}()
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+type cropHandle int
+
+const (
+	cropHandleNone cropHandle = iota
+	cropHandleMove
+	cropHandleTopLeft
+	cropHandleTop
+	cropHandleTopRight
+	cropHandleRight
+	cropHandleBottomRight
+	cropHandleBottom
+	cropHandleBottomLeft
+	cropHandleLeft
+)
+
+// CropTool is the state of the interactive crop rectangle. The rectangle is drawn by dragging on the canvas,
+// then adjusted with its handles, and applied with Enter.
+type CropTool struct {
+	rect image.Rectangle // In canvas pixels. Empty when nothing is being cropped.
+
+	draggedHandle   cropHandle
+	dragStart       image.Point
+	rectAtDragStart image.Rectangle
+}
+
+var cropHandleSize = 10
+var cropOutsideColor = color.NRGBA{A: 140}
+
+func handleCropPointer(state *GemPaintState, p pointer.Event) {
+	crop := &state.crop
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		crop.draggedHandle = crop.handleAt(position)
+		crop.dragStart = position
+		crop.rectAtDragStart = crop.rect
+
+		if crop.draggedHandle == cropHandleNone { // Start a new rectangle
+			crop.rect = image.Rectangle{Min: position, Max: position}
+		}
+
+	case pointer.Drag:
+		delta := position.Sub(crop.dragStart)
+		r := crop.rectAtDragStart
+
+		switch crop.draggedHandle {
+		case cropHandleNone:
+			r = image.Rectangle{Min: crop.dragStart, Max: position}.Canon()
+		case cropHandleMove:
+			r = r.Add(delta)
+			// Keep the rectangle the same size while moving it, by pushing it back inside the canvas.
+			bounds := state.document.Bounds()
+			r = r.Add(image.Point{X: max(bounds.Min.X-r.Min.X, 0) + min(bounds.Max.X-r.Max.X, 0), Y: max(bounds.Min.Y-r.Min.Y, 0) + min(bounds.Max.Y-r.Max.Y, 0)})
+		}
+
+		switch crop.draggedHandle {
+		case cropHandleTopLeft, cropHandleLeft, cropHandleBottomLeft:
+			r.Min.X += delta.X
+		case cropHandleTopRight, cropHandleRight, cropHandleBottomRight:
+			r.Max.X += delta.X
+		}
+		switch crop.draggedHandle {
+		case cropHandleTopLeft, cropHandleTop, cropHandleTopRight:
+			r.Min.Y += delta.Y
+		case cropHandleBottomLeft, cropHandleBottom, cropHandleBottomRight:
+			r.Max.Y += delta.Y
+		}
+
+		crop.rect = r.Canon().Intersect(state.document.Bounds())
+	}
+}
+
+// handleAt returns the handle of the crop rectangle under position, if any.
+func (crop *CropTool) handleAt(position image.Point) cropHandle {
+	if crop.rect.Empty() {
+		return cropHandleNone
+	}
+
+	for _, h := range crop.handleCenters() {
+		grabArea := image.Rectangle{Min: h.center, Max: h.center}.Inset(-cropHandleSize)
+		if position.In(grabArea) {
+			return h.handle
+		}
+	}
+
+	if position.In(crop.rect) {
+		return cropHandleMove
+	}
+
+	return cropHandleNone
+}
+
+type cropHandleCenter struct {
+	handle cropHandle
+	center image.Point
+}
+
+// handleCenters returns where the handles of the crop rectangle are. On a small rectangle the handles overlap, so
+// the corners come before the edges, and the first handle under the pointer is the one grabbed.
+func (crop *CropTool) handleCenters() []cropHandleCenter {
+	r := crop.rect
+	middle := r.Min.Add(r.Max).Div(2)
+
+	return []cropHandleCenter{
+		{cropHandleTopLeft, r.Min},
+		{cropHandleTopRight, image.Point{X: r.Max.X, Y: r.Min.Y}},
+		{cropHandleBottomRight, r.Max},
+		{cropHandleBottomLeft, image.Point{X: r.Min.X, Y: r.Max.Y}},
+		{cropHandleTop, image.Point{X: middle.X, Y: r.Min.Y}},
+		{cropHandleRight, image.Point{X: r.Max.X, Y: middle.Y}},
+		{cropHandleBottom, image.Point{X: middle.X, Y: r.Max.Y}},
+		{cropHandleLeft, image.Point{X: r.Min.X, Y: middle.Y}},
+	}
+}
+
+// applyCrop crops every layer to the crop rectangle.
+func applyCrop(state *GemPaintState) {
+	if state.crop.rect.Empty() {
+		return
+	}
+
+	rect := state.crop.rect
+	transformImage(state, func(img *image.RGBA) *image.RGBA {
+		return cropRGBA(img, rect)
+	}, translationMatrix(-float64(rect.Min.X), -float64(rect.Min.Y)))
+	state.crop = CropTool{}
+}
+
+// drawCropOverlay darkens the part of the canvas that will be cut away and draws the handles of the rectangle.
+func drawCropOverlay(gtx layout.Context, state *GemPaintState) {
+	r := state.crop.rect
+	if r.Empty() {
+		return
+	}
+
+	bounds := state.document.Bounds()
+	outside := []image.Rectangle{
+		{Min: bounds.Min, Max: image.Point{X: bounds.Max.X, Y: r.Min.Y}},
+		{Min: image.Point{X: bounds.Min.X, Y: r.Max.Y}, Max: bounds.Max},
+		{Min: image.Point{X: bounds.Min.X, Y: r.Min.Y}, Max: image.Point{X: r.Min.X, Y: r.Max.Y}},
+		{Min: image.Point{X: r.Max.X, Y: r.Min.Y}, Max: image.Point{X: bounds.Max.X, Y: r.Max.Y}},
+	}
+	for _, area := range outside {
+		if area.Empty() {
+			continue
+		}
+		stack := clip.Rect(area).Push(gtx.Ops)
+		paint.Fill(gtx.Ops, cropOutsideColor)
+		stack.Pop()
+	}
+
+	strokeRect(gtx, r, defaultCanvasColor)
+
+	for _, h := range state.crop.handleCenters() {
+		handle := image.Rectangle{Min: h.center, Max: h.center}.Inset(-cropHandleSize / 2)
+		stack := clip.Rect(handle).Push(gtx.Ops)
+		paint.Fill(gtx.Ops, golangBlue)
+		stack.Pop()
+	}
+}
+
+// strokeRect draws a one pixel wide outline of r.
+func strokeRect(gtx layout.Context, r image.Rectangle, col color.NRGBA) {
+	paint.FillShape(gtx.Ops, col, clip.Stroke{Path: clip.Rect(r).Path(), Width: 1}.Op())
+}
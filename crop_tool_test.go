@@ -0,0 +1,36 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// On a rectangle smaller than the handles, every handle is under the pointer, and the same corner must be grabbed
+// every time.
+func TestCropHandleAtPrefersCorners(t *testing.T) {
+	crop := CropTool{rect: image.Rect(100, 100, 106, 106)}
+
+	tests := []struct {
+		position image.Point
+		expected cropHandle
+	}{
+		{image.Pt(103, 103), cropHandleTopLeft},
+		{image.Pt(112, 98), cropHandleTopRight},
+		{image.Pt(112, 112), cropHandleBottomRight},
+		{image.Pt(94, 112), cropHandleBottomLeft},
+		{image.Pt(200, 200), cropHandleNone},
+	}
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			if handle := crop.handleAt(test.position); handle != test.expected {
+				t.Fatalf("%v grabbed handle %d, expected %d", test.position, handle, test.expected)
+			}
+		}
+	}
+
+	// On a big rectangle the edges can be grabbed.
+	crop.rect = image.Rect(100, 100, 300, 300)
+	if handle := crop.handleAt(image.Pt(200, 101)); handle != cropHandleTop {
+		t.Errorf("the top edge grabbed handle %d, expected %d", handle, cropHandleTop)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// Dialog is a modal window shown on top of the sidebar and canvas, eg. the New Document dialog.
+// A dialog closes itself by calling closeDialog.
+type Dialog interface {
+	Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions
+}
+
+// closingDialog is a dialog with something left to do when it closes, however it is closed.
+type closingDialog interface {
+	Dialog
+	onClose(state *GemPaintState)
+}
+
+var scrimColor = color.NRGBA{A: 120}
+
+func openDialog(state *GemPaintState, dialog Dialog) {
+	state.dialog = dialog
+	state.mousePositionOnCanvas = mouseIsOutsideCanvas
+}
+
+func closeDialog(state *GemPaintState) {
+	if d, ok := state.dialog.(closingDialog); ok {
+		d.onClose(state)
+	}
+	state.dialog = nil
+}
+
+// layoutDialog dims everything under the dialog, swallows the pointer events meant for it, and draws
+// content in a centered card with a title.
+func layoutDialog(gtx layout.Context, state *GemPaintState, theme *material.Theme, title string, content layout.Widget) layout.Dimensions {
+	// Scrim
+	area := clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops)
+	event.Op(gtx.Ops, &state.dialogInputTag)
+	for {
+		_, ok := gtx.Event(pointer.Filter{Target: &state.dialogInputTag, Kinds: pointer.Press | pointer.Drag | pointer.Release | pointer.Scroll})
+		if !ok {
+			break
+		}
+	}
+	paint.Fill(gtx.Ops, scrimColor)
+	area.Pop()
+
+	return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Background{}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			rr := gtx.Dp(unit.Dp(8))
+			defer clip.UniformRRect(image.Rectangle{Max: gtx.Constraints.Min}, rr).Push(gtx.Ops).Pop()
+			paint.Fill(gtx.Ops, softBlue)
+			return layout.Dimensions{Size: gtx.Constraints.Min}
+		}, func(gtx layout.Context) layout.Dimensions {
+			return layout.UniformInset(20).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(material.H6(theme, title).Layout),
+					layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+					layout.Rigid(content),
+				)
+			})
+		})
+	})
+}
+
+// layoutLabeledEditor lays out a single line text input with a label in front of it.
+func layoutLabeledEditor(gtx layout.Context, theme *material.Theme, label string, editor *widget.Editor) layout.Dimensions {
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(unit.Dp(100))
+			return material.Body1(theme, label).Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(unit.Dp(140))
+			gtx.Constraints.Max.X = gtx.Constraints.Min.X
+			return widget.Border{Color: lightGray, Width: unit.Dp(1)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.UniformInset(6).Layout(gtx, material.Editor(theme, editor, "").Layout)
+			})
+		}),
+	)
+}
+
+// layoutDialogButtons lays out a row of text buttons, eg. Cancel and OK, at the bottom of a dialog.
+func layoutDialogButtons(gtx layout.Context, theme *material.Theme, buttons ...material.ButtonStyle) layout.Dimensions {
+	children := make([]layout.FlexChild, 0, len(buttons)*2)
+	for i := range buttons {
+		button := buttons[i]
+		if i > 0 {
+			children = append(children, layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout))
+		}
+		children = append(children, layout.Rigid(button.Layout))
+	}
+
+	return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, children...)
+}
+
+// layoutDialogError shows why the inputs of a dialog were rejected, if they were.
+func layoutDialogError(gtx layout.Context, theme *material.Theme, message string) layout.Dimensions {
+	if message == "" {
+		return layout.Dimensions{}
+	}
+
+	label := material.Body2(theme, message)
+	label.Color = red
+	return layout.Inset{Top: 8}.Layout(gtx, label.Layout)
+}
+
+// editorInt parses the content of an editor as a whole number.
+func editorInt(editor *widget.Editor) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(editor.Text()))
+}
+
+// editorFloat parses the content of an editor as a decimal number.
+func editorFloat(editor *widget.Editor) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(editor.Text()), 64)
+}
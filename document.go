@@ -0,0 +1,32 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// Document holds the settings the canvas was created with.
+type Document struct {
+	Width  int
+	Height int
+	DPI    int // Only stored as metadata, it does not change how the canvas is painted.
+
+	// The color the canvas is filled with when it is created or cleared. Fully transparent for transparent documents.
+	Background color.NRGBA
+}
+
+var defaultDocument = Document{
+	Width:      defaultCanvasDimensions.Dx(),
+	Height:     defaultCanvasDimensions.Dy(),
+	DPI:        defaultDPI,
+	Background: defaultCanvasColor,
+}
+
+func (d Document) Bounds() image.Rectangle {
+	return image.Rect(0, 0, d.Width, d.Height)
+}
+
+// NewCanvas creates a canvas with the document's dimensions, filled with its background.
+func (d Document) NewCanvas() *TiledCanvas {
+	return newTiledCanvas(d.Bounds(), d.Background)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"math"
+)
+
+const inchesPerMeter = 39.3700787
+
+// encodePNG writes img as a png, with a pHYs chunk so that other programs know the document's DPI.
+func encodePNG(w io.Writer, img image.Image, dpi int) error {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	encoded := buf.Bytes()
+	if dpi <= 0 {
+		_, err := w.Write(encoded)
+		return err
+	}
+
+	// The pHYs chunk has to come before the image data, so put it right after the IHDR chunk,
+	// which is always first: 8 bytes of signature, then 4 (length) + 4 (type) + 13 (data) + 4 (crc).
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+
+	pixelsPerMeter := uint32(math.Round(float64(dpi) * inchesPerMeter))
+	data := make([]byte, 9)
+	binary.BigEndian.PutUint32(data[0:4], pixelsPerMeter)
+	binary.BigEndian.PutUint32(data[4:8], pixelsPerMeter)
+	data[8] = 1 // The unit is the meter
+
+	if _, err := w.Write(encoded[:ihdrEnd]); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "pHYs", data); err != nil {
+		return err
+	}
+	_, err := w.Write(encoded[ihdrEnd:])
+	return err
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	chunk := make([]byte, 0, 12+len(data))
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	_, err := w.Write(chunk)
+	return err
+}
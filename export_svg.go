@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// encodeSVG writes the visible layers as an svg. Vector shapes are written as paths, so they stay sharp and
+// editable in other programs, and the pixels of a layer as an embedded png cropped to what was painted.
+func encodeSVG(w io.Writer, document Document, layers []*Layer) error {
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		document.Width, document.Height, document.Width, document.Height)
+
+	for _, layer := range layers {
+		if !layer.Visible {
+			continue
+		}
+
+		fmt.Fprintf(out, "  <g id=\"%s\">\n", xmlEscape(layer.Name))
+
+		img := layer.Image.RGBA()
+		if painted := opaqueBounds(img); !painted.Empty() {
+			uri, err := encodePNGDataURI(cropRGBA(img, painted), 0)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "    <image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" href=\"%s\"/>\n",
+				painted.Min.X, painted.Min.Y, painted.Dx(), painted.Dy(), uri)
+		}
+
+		for _, shape := range layer.Shapes {
+			if len(shape.Path.Anchors) == 0 {
+				continue
+			}
+			fmt.Fprintf(out, "    <path d=\"%s\" %s/>\n", svgPathData(shape.Path), svgPaint(shape))
+		}
+
+		fmt.Fprintf(out, "  </g>\n")
+	}
+
+	fmt.Fprintf(out, "</svg>\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// svgPathData returns the path as the d attribute of an svg path: a move to the first anchor, then a cubic
+// curve to each of the others.
+func svgPathData(path VectorPath) string {
+	data := []string{"M", svgNumber(path.Anchors[0].Point.X), svgNumber(path.Anchors[0].Point.Y)}
+	for _, s := range path.segments() {
+		data = append(data, "C",
+			svgNumber(s[1].X), svgNumber(s[1].Y),
+			svgNumber(s[2].X), svgNumber(s[2].Y),
+			svgNumber(s[3].X), svgNumber(s[3].Y),
+		)
+	}
+	if path.Closed {
+		data = append(data, "Z")
+	}
+	return strings.Join(data, " ")
+}
+
+// svgPaint returns the fill and stroke attributes of the shape. The strokes have round joins and ends, like
+// they have when the shape is rasterized.
+func svgPaint(shape VectorShape) string {
+	hex, opacity := svgColor(shape.Color)
+
+	attributes := []string{`fill="none"`}
+	if shape.Mode == ShapeFill || shape.Mode == ShapeStrokeAndFill {
+		attributes = []string{fmt.Sprintf(`fill="%s" fill-opacity="%s"`, hex, opacity)}
+	}
+	if shape.Mode == ShapeStroke || shape.Mode == ShapeStrokeAndFill {
+		attributes = append(attributes, fmt.Sprintf(`stroke="%s" stroke-opacity="%s" stroke-width="%s" stroke-linecap="round" stroke-linejoin="round"`,
+			hex, opacity, svgNumber(shape.Width)))
+	}
+	return strings.Join(attributes, " ")
+}
+
+func svgColor(c color.NRGBA) (hex, opacity string) {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B), svgNumber(float64(c.A) / 255)
+}
+
+// svgNumber formats v with at most three decimals, which is more precise than anyone can see.
+func svgNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+func xmlEscape(s string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(s)) // Can only fail when writing fails, which a bytes.Buffer doesn't
+	return escaped.String()
+}
+
+// exportLayers copies the layers so that they can be exported in the background while painting goes on.
+func exportLayers(layers []*Layer) []*Layer {
+	copies := make([]*Layer, len(layers))
+	for i, layer := range layers {
+		copied := *layer
+		copied.Image = layer.Image.Clone()
+		copies[i] = &copied
+	}
+	return copies
+}
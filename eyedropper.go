@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"gioui.org/f32"
+)
+
+// The eyedropper picks the paint color off the canvas. It can average a few pixels, so that picking from noisy
+// or antialiased areas gives the color they look like rather than that of one stray pixel.
+
+var eyedropperSizes = []struct {
+	Name string
+	Size int // Of the square that is averaged, in pixels
+}{
+	{"Point", 1},
+	{"3x3", 3},
+	{"5x5", 5},
+}
+
+// What the eyedropper picks from.
+const (
+	SampleCurrentLayer = "Current Layer"
+	SampleMerged       = "Merged"
+)
+
+var eyedropperSources = []string{SampleCurrentLayer, SampleMerged}
+
+func eyedropperSizeValue(state *GemPaintState) int {
+	for _, size := range eyedropperSizes {
+		if size.Name == state.toolOptions.eyedropperSize.Value {
+			return size.Size
+		}
+	}
+	return 1
+}
+
+// pickColor makes the color under position the paint color, or the secondary color when picking with the right
+// button. Fully transparent pixels have no color to pick, so they leave it as it is.
+func pickColor(state *GemPaintState, position f32.Point) {
+	size := eyedropperSizeValue(state)
+	center := image.Point{X: int(position.X), Y: int(position.Y)}
+	square := image.Rectangle{Min: center, Max: center.Add(image.Point{X: 1, Y: 1})}.Inset(-(size - 1) / 2)
+	square = square.Intersect(state.document.Bounds())
+	if square.Empty() {
+		return
+	}
+
+	// Only the square is flattened, so picking stays fast while dragging over big documents.
+	layers := state.layers
+	if state.toolOptions.eyedropperSource.Value != SampleMerged {
+		layer := *activeLayer(state)
+		layer.Visible = true // Hidden or not, it is what is painted on
+		layers = []*Layer{&layer}
+	}
+	picked, ok := averageColor(flattenLayers(layers, square))
+	if !ok {
+		return
+	}
+
+	if state.paintingWithSecondary {
+		state.secondaryColor = picked
+	} else {
+		state.paintColor = picked
+	}
+	if debug {
+		fmt.Println("Picked color: ", formatHexColor(picked))
+	}
+}
+
+// averageColor averages the pixels of img. The channels are averaged premultiplied, so that transparent pixels
+// don't darken the color. It is false when every pixel is transparent.
+func averageColor(img *image.RGBA) (color.NRGBA, bool) {
+	var r, g, b, a int
+	for i := 0; i < len(img.Pix); i += 4 {
+		r += int(img.Pix[i])
+		g += int(img.Pix[i+1])
+		b += int(img.Pix[i+2])
+		a += int(img.Pix[i+3])
+	}
+	if a == 0 {
+		return color.NRGBA{}, false
+	}
+
+	pixels := len(img.Pix) / 4
+	unpremultiply := func(channel int) uint8 { return uint8((channel*255 + a/2) / a) }
+	return color.NRGBA{R: unpremultiply(r), G: unpremultiply(g), B: unpremultiply(b), A: uint8((a + pixels/2) / pixels)}, true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+)
+
+// FloatingSelection is pasted content that hasn't been put on a layer yet. It floats above the active layer
+// and can be dragged around until it is committed, which pastes it onto the layer as one undo step.
+type FloatingSelection struct {
+	image  *image.RGBA // Its bounds start at (0, 0)
+	offset image.Point // Where the top left corner of the image is on the canvas
+
+	dragging          bool
+	dragStart         image.Point
+	offsetAtDragStart image.Point
+}
+
+func (f *FloatingSelection) bounds() image.Rectangle {
+	return f.image.Rect.Add(f.offset)
+}
+
+// pasteFloating makes img float over the canvas. It is pasted in place over the selection if there is one,
+// otherwise in the middle of the canvas.
+func pasteFloating(state *GemPaintState, img *image.RGBA) {
+	if state.floating != nil {
+		commitFloating(state)
+	}
+
+	documentBounds := state.document.Bounds()
+	offset := documentBounds.Min.Add(documentBounds.Size().Sub(img.Rect.Size()).Div(2))
+	if state.selection != nil {
+		offset = selectionBounds(state.selection).Min
+	}
+
+	state.floating = &FloatingSelection{image: img, offset: offset}
+	state.selection = nil
+}
+
+// commitFloating pastes the floating selection onto the active layer.
+func commitFloating(state *GemPaintState) {
+	floating := state.floating
+	if floating == nil {
+		return
+	}
+	state.floating = nil
+
+	pasted := activeLayer(state).Image.Clone()
+	pasted.Draw(floating.bounds(), floating.image, image.Point{}, draw.Over)
+	commitLayerChange(state, pasted)
+}
+
+// handleFloatingPointer moves the floating selection when it is dragged. Pressing outside of it commits it.
+func handleFloatingPointer(state *GemPaintState, p pointer.Event) {
+	floating := state.floating
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		if !position.In(floating.bounds()) {
+			commitFloating(state)
+			state.ignoreDragUntilRelease = true
+			return
+		}
+		floating.dragging = true
+		floating.dragStart = position
+		floating.offsetAtDragStart = floating.offset
+
+	case pointer.Drag:
+		if floating.dragging {
+			floating.offset = floating.offsetAtDragStart.Add(position.Sub(floating.dragStart))
+		}
+
+	case pointer.Release:
+		floating.dragging = false
+	}
+}
+
+// layersWithFloating returns the layers with the floating selection drawn over the active layer, for display.
+func layersWithFloating(state *GemPaintState) []*Layer {
+	layers := make([]*Layer, len(state.layers))
+	copy(layers, state.layers)
+
+	active := *activeLayer(state)
+	active.Image = active.Image.Clone()
+	active.Image.Draw(state.floating.bounds(), state.floating.image, image.Point{}, draw.Over)
+	layers[state.activeLayerIndex] = &active
+
+	return layers
+}
+
+func drawFloatingOverlay(gtx layout.Context, state *GemPaintState) {
+	if state.floating == nil {
+		return
+	}
+	strokeRect(gtx, state.floating.bounds(), darkGray)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+
+	"gioui.org/f32"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// FreeTransform moves, scales, rotates and skews content lifted off the active layer: the floating selection,
+// the selected pixels, or everything on the layer. The content is previewed on top of the canvas until the
+// transform is applied, which resamples it onto the layer as one undo step.
+type FreeTransform struct {
+	source       *image.RGBA // The content being transformed. Its bounds start at (0, 0).
+	sourceOp     paint.ImageOp
+	layer        *Layer             // The layer the content was lifted off, which it is applied to
+	base         *TiledCanvas       // The layer with the content cut out of it
+	fromFloating *FloatingSelection // Put back if the transform is cancelled
+
+	params transformParams
+
+	drag              transformDrag
+	dragHandle        image.Point // Which side of the box the dragged handle is on, eg. (-1, 1) for the bottom left corner
+	dragStart         f32.Point
+	paramsAtDragStart transformParams
+}
+
+// transformParams place the source on the canvas: it is scaled, then skewed, then rotated around its center,
+// which is then moved to (centerX, centerY).
+type transformParams struct {
+	centerX, centerY float64
+	scaleX, scaleY   float64
+	rotation         float64 // Degrees clockwise
+	skewX, skewY     float64 // Degrees
+}
+
+type transformDrag int
+
+const (
+	transformDragNone transformDrag = iota
+	transformDragMove
+	transformDragScale
+	transformDragRotate
+	transformDragSkew
+)
+
+var transformRotationSnap = 15.0
+var minimumTransformSize = 1.0
+
+// frame maps from the coordinates of the scaled source, centered on (0, 0), to the canvas.
+func (p transformParams) frame() affineMatrix {
+	return skewMatrix(p.skewX, p.skewY).then(rotationMatrix(p.rotation)).then(translationMatrix(p.centerX, p.centerY))
+}
+
+// matrix maps from the coordinates of a source of the given size to the canvas.
+func (p transformParams) matrix(size image.Point) affineMatrix {
+	return translationMatrix(-float64(size.X)/2, -float64(size.Y)/2).then(scaleMatrix(p.scaleX, p.scaleY)).then(p.frame())
+}
+
+func (t *FreeTransform) matrix() affineMatrix {
+	return t.params.matrix(t.source.Rect.Size())
+}
+
+// startFreeTransform lifts the content to transform off the active layer. It does nothing when there is
+// nothing to transform.
+func startFreeTransform(state *GemPaintState) {
+	if state.freeTransform != nil || state.job != nil {
+		return
+	}
+
+	layer := activeLayer(state).Image
+	transform := &FreeTransform{layer: activeLayer(state)}
+	var offset image.Point
+
+	switch {
+	case state.floating != nil:
+		transform.source = state.floating.image
+		transform.base = layer
+		transform.fromFloating = state.floating
+		offset = state.floating.offset
+		state.floating = nil
+
+	case state.selection != nil:
+		rect := selectionBounds(state.selection)
+		transform.source = copySelectionImage(layer.RGBA(), state.selection)
+		transform.base = clearedLayerImage(state, transparent)
+		offset = rect.Min
+
+	default:
+		pixels := layer.RGBA()
+		rect := opaqueBounds(pixels)
+		if rect.Empty() {
+			return
+		}
+		transform.source = cropRGBA(pixels, rect)
+		transform.base = newTiledCanvas(layer.Rect, transparent) // Everything else on the layer is transparent already
+		offset = rect.Min
+	}
+
+	size := transform.source.Rect.Size()
+	transform.sourceOp = paint.NewImageOp(transform.source)
+	transform.params = transformParams{
+		centerX: float64(offset.X) + float64(size.X)/2,
+		centerY: float64(offset.Y) + float64(size.Y)/2,
+		scaleX:  1,
+		scaleY:  1,
+	}
+
+	state.freeTransform = transform
+	state.selection = nil
+}
+
+// cancelFreeTransform drops the transform. The layer was never changed, so only a floating selection is restored.
+func cancelFreeTransform(state *GemPaintState) {
+	if state.freeTransform == nil {
+		return
+	}
+	state.floating = state.freeTransform.fromFloating
+	state.freeTransform = nil
+}
+
+// applyFreeTransform resamples the content onto the layer it was lifted off in the background.
+func applyFreeTransform(state *GemPaintState, filter ResampleFilter) {
+	transform := state.freeTransform
+	if transform == nil {
+		return
+	}
+
+	bounds := transform.base.Rect
+	matrix := transform.matrix()
+	result := transform.base.Clone()
+	startJob(state, "Transforming", func(ctx context.Context, reportProgress func(done float32)) (func(state *GemPaintState), error) {
+		transformed, err := transformRGBA(ctx, transform.source, matrix, bounds, filter, reportProgress)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Draw(bounds, transformed, bounds.Min, draw.Over)
+
+		return func(state *GemPaintState) {
+			state.freeTransform = nil
+			replaceLayerImage(state, transform.layer, result)
+		}, nil
+	})
+}
+
+type transformHandleCenter struct {
+	handle image.Point // Which side of the box the handle is on
+	center f32.Point
+}
+
+// transformHandles are the sides of the box that have handles. The corners come first, so that they are the ones
+// grabbed when the box is so small that the handles overlap.
+var transformHandles = []image.Point{{X: -1, Y: -1}, {X: 1, Y: -1}, {X: 1, Y: 1}, {X: -1, Y: 1}, {Y: -1}, {X: 1}, {Y: 1}, {X: -1}}
+
+// handleCenters returns where the handles of the transform box are on the canvas.
+func (t *FreeTransform) handleCenters() []transformHandleCenter {
+	m := t.matrix()
+	size := t.source.Rect.Size()
+
+	centers := make([]transformHandleCenter, len(transformHandles))
+	for i, handle := range transformHandles {
+		x, y := m.apply(float64(handle.X+1)/2*float64(size.X), float64(handle.Y+1)/2*float64(size.Y))
+		centers[i] = transformHandleCenter{handle: handle, center: f32.Pt(float32(x), float32(y))}
+	}
+	return centers
+}
+
+func handleFreeTransformPointer(state *GemPaintState, p pointer.Event) {
+	if state.freeTransform == nil {
+		if p.Kind != pointer.Press {
+			return
+		}
+		startFreeTransform(state)
+		if state.freeTransform == nil {
+			return
+		}
+	}
+
+	t := state.freeTransform
+
+	switch p.Kind {
+	case pointer.Press:
+		t.dragStart = p.Position
+		t.paramsAtDragStart = t.params
+		t.drag = transformDragNone
+
+		for _, h := range t.handleCenters() {
+			distance := h.center.Sub(p.Position)
+			if math.Abs(float64(distance.X)) <= float64(cropHandleSize) && math.Abs(float64(distance.Y)) <= float64(cropHandleSize) {
+				t.dragHandle = h.handle
+				t.drag = transformDragScale
+				isEdge := h.handle.X == 0 || h.handle.Y == 0
+				if isEdge && p.Modifiers.Contain(key.ModCtrl) {
+					t.drag = transformDragSkew
+				}
+				break
+			}
+		}
+
+		if t.drag == transformDragNone {
+			size := t.source.Rect.Size()
+			x, y := t.matrix().invert().apply(float64(p.Position.X), float64(p.Position.Y))
+			if x >= 0 && y >= 0 && x < float64(size.X) && y < float64(size.Y) {
+				t.drag = transformDragMove
+			} else {
+				t.drag = transformDragRotate
+			}
+		}
+
+	case pointer.Drag:
+		constrain := p.Modifiers.Contain(key.ModShift)
+		switch t.drag {
+		case transformDragMove:
+			t.params = moveTransform(t.paramsAtDragStart, t.dragStart, p.Position, constrain)
+		case transformDragScale:
+			t.params = scaleTransform(t.paramsAtDragStart, t.source.Rect.Size(), t.dragHandle, p.Position, constrain)
+		case transformDragRotate:
+			t.params = rotateTransform(t.paramsAtDragStart, t.dragStart, p.Position, constrain)
+		case transformDragSkew:
+			t.params = skewTransform(t.paramsAtDragStart, t.source.Rect.Size(), t.dragHandle, p.Position)
+		}
+
+	case pointer.Release:
+		t.drag = transformDragNone
+	}
+}
+
+// moveTransform moves the content by how far the pointer moved. Constrained moves are only horizontal or vertical.
+func moveTransform(params transformParams, from, to f32.Point, constrain bool) transformParams {
+	dx, dy := float64(to.X-from.X), float64(to.Y-from.Y)
+	if constrain {
+		if math.Abs(dx) > math.Abs(dy) {
+			dy = 0
+		} else {
+			dx = 0
+		}
+	}
+
+	params.centerX += dx
+	params.centerY += dy
+	return params
+}
+
+// scaleTransform moves the dragged handle to the pointer while the opposite handle stays in place.
+// Constrained scaling keeps the proportions of the content.
+func scaleTransform(params transformParams, size image.Point, handle image.Point, to f32.Point, constrain bool) transformParams {
+	// Work in the frame of the box before it is skewed and rotated, centered on (0, 0).
+	frame := params.frame()
+	u, v := frame.invert().apply(float64(to.X), float64(to.Y))
+
+	width, height := float64(size.X)*params.scaleX, float64(size.Y)*params.scaleY
+	anchorX, anchorY := -float64(handle.X)*width/2, -float64(handle.Y)*height/2
+
+	newWidth, newHeight := width, height
+	if handle.X != 0 {
+		newWidth = keepAwayFromZero((u-anchorX)*float64(handle.X), width)
+	}
+	if handle.Y != 0 {
+		newHeight = keepAwayFromZero((v-anchorY)*float64(handle.Y), height)
+	}
+
+	if constrain {
+		switch {
+		case handle.X != 0 && handle.Y != 0:
+			factor := (newWidth/width + newHeight/height) / 2
+			newWidth, newHeight = width*factor, height*factor
+		case handle.X != 0:
+			newHeight = height * newWidth / width
+		case handle.Y != 0:
+			newWidth = width * newHeight / height
+		}
+	}
+
+	// The anchor stays where it is, so the center moves by half of the change in size.
+	centerU, centerV := 0.0, 0.0
+	if handle.X != 0 {
+		centerU = anchorX + float64(handle.X)*newWidth/2
+	}
+	if handle.Y != 0 {
+		centerV = anchorY + float64(handle.Y)*newHeight/2
+	}
+
+	params.centerX, params.centerY = frame.apply(centerU, centerV)
+	params.scaleX = newWidth / float64(size.X)
+	params.scaleY = newHeight / float64(size.Y)
+	return params
+}
+
+// keepAwayFromZero stops a side of the box from collapsing, which would make the transform impossible to undo.
+// Crossing zero flips the content.
+func keepAwayFromZero(length, previous float64) float64 {
+	if math.Abs(length) >= minimumTransformSize {
+		return length
+	}
+	if length == 0 {
+		return math.Copysign(minimumTransformSize, previous)
+	}
+	return math.Copysign(minimumTransformSize, length)
+}
+
+// rotateTransform turns the content around its center by the angle the pointer turned around it.
+// Constrained rotations snap to multiples of transformRotationSnap.
+func rotateTransform(params transformParams, from, to f32.Point, constrain bool) transformParams {
+	angle := func(p f32.Point) float64 {
+		return math.Atan2(float64(p.Y)-params.centerY, float64(p.X)-params.centerX) * 180 / math.Pi
+	}
+
+	params.rotation += angle(to) - angle(from)
+	if constrain {
+		params.rotation = math.Round(params.rotation/transformRotationSnap) * transformRotationSnap
+	}
+	params.rotation = math.Mod(params.rotation+540, 360) - 180
+	return params
+}
+
+// skewTransform slides the dragged edge along itself, so that the middle of the edge follows the pointer.
+func skewTransform(params transformParams, size image.Point, handle image.Point, to f32.Point) transformParams {
+	unrotate := rotationMatrix(params.rotation).then(translationMatrix(params.centerX, params.centerY)).invert()
+	u, v := unrotate.apply(float64(to.X), float64(to.Y))
+
+	width, height := float64(size.X)*params.scaleX, float64(size.Y)*params.scaleY
+	if handle.Y != 0 { // Top or bottom edge
+		params.skewX = math.Atan(u/(float64(handle.Y)*height/2)) * 180 / math.Pi
+	} else { // Left or right edge
+		params.skewY = math.Atan(v/(float64(handle.X)*width/2)) * 180 / math.Pi
+	}
+	return params
+}
+
+// layersWithFreeTransform returns the layers with the content being transformed cut out of its layer, for
+// display. The content itself is drawn by drawFreeTransformOverlay.
+func layersWithFreeTransform(state *GemPaintState) []*Layer {
+	layers := make([]*Layer, len(state.layers))
+	copy(layers, state.layers)
+
+	index := slices.Index(layers, state.freeTransform.layer)
+	if index < 0 {
+		return layers
+	}
+	lifted := *layers[index]
+	lifted.Image = state.freeTransform.base
+	layers[index] = &lifted
+
+	return layers
+}
+
+// drawFreeTransformOverlay previews the transformed content, and draws the box around it with its handles.
+func drawFreeTransformOverlay(gtx layout.Context, state *GemPaintState) {
+	t := state.freeTransform
+	if t == nil {
+		return
+	}
+
+	m := t.matrix()
+	contentTransform := op.Affine(f32.NewAffine2D(float32(m[0]), float32(m[1]), float32(m[2]), float32(m[3]), float32(m[4]), float32(m[5]))).Push(gtx.Ops)
+	content := clip.Rect(t.source.Rect).Push(gtx.Ops)
+	t.sourceOp.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+	content.Pop()
+	contentTransform.Pop()
+
+	size := t.source.Rect.Size()
+	var outline clip.Path
+	outline.Begin(gtx.Ops)
+	for i, corner := range []image.Point{{}, {X: size.X}, size, {Y: size.Y}} {
+		x, y := m.apply(float64(corner.X), float64(corner.Y))
+		if i == 0 {
+			outline.MoveTo(f32.Pt(float32(x), float32(y)))
+		} else {
+			outline.LineTo(f32.Pt(float32(x), float32(y)))
+		}
+	}
+	outline.Close()
+	paint.FillShape(gtx.Ops, darkGray, clip.Stroke{Path: outline.End(), Width: 1}.Op())
+
+	for _, h := range t.handleCenters() {
+		c := image.Point{X: int(h.center.X), Y: int(h.center.Y)}
+		handle := image.Rectangle{Min: c, Max: c}.Inset(-cropHandleSize / 2)
+		stack := clip.Rect(handle).Push(gtx.Ops)
+		paint.Fill(gtx.Ops, golangBlue)
+		stack.Pop()
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+)
+
+// History keeps snapshots of the document for undo and redo.
+type History struct {
+	undoStack []historyEntry
+	redoStack []historyEntry
+}
+
+// historyEntry is the state of the document at one point in time. The document settings are saved with the
+// layers, because operations like resizing change both.
+type historyEntry struct {
+	document         Document
+	layers           []*Layer
+	activeLayerIndex int
+}
+
+// Record saves the document as it is before a change. It must be called before the change is made.
+//
+// The pixels of the layers are shared with the entry, not copied, since most changes replace the image of a layer
+// rather than painting on it. A change that paints on a layer in place must give it its own copy of its pixels
+// first, like startStroke does.
+func (h *History) Record(state *GemPaintState) {
+	entry := currentHistoryEntry(state)
+	for i, layer := range entry.layers {
+		clone := *layer // The image and the shapes are never changed in place, so they can be shared
+		entry.layers[i] = &clone
+	}
+
+	h.undoStack = append(h.undoStack, entry)
+	if len(h.undoStack) > maximumUndoSteps {
+		h.undoStack = h.undoStack[1:]
+	}
+
+	h.redoStack = nil // A new change invalidates the redo history.
+}
+
+// Undo restores the previous document and saves the current one so that it can be redone.
+func (h *History) Undo(state *GemPaintState) bool {
+	if len(h.undoStack) == 0 {
+		return false
+	}
+
+	previous := h.undoStack[len(h.undoStack)-1]
+	h.undoStack = h.undoStack[:len(h.undoStack)-1]
+	h.redoStack = append(h.redoStack, currentHistoryEntry(state))
+
+	restoreHistoryEntry(state, previous)
+	return true
+}
+
+// Redo restores the document that was last undone and saves the current one so that it can be undone again.
+func (h *History) Redo(state *GemPaintState) bool {
+	if len(h.redoStack) == 0 {
+		return false
+	}
+
+	next := h.redoStack[len(h.redoStack)-1]
+	h.redoStack = h.redoStack[:len(h.redoStack)-1]
+	h.undoStack = append(h.undoStack, currentHistoryEntry(state))
+
+	restoreHistoryEntry(state, next)
+	return true
+}
+
+// currentHistoryEntry captures the current document without copying any pixels. The layer slice is copied so
+// that adding, removing or reordering layers later doesn't change the entry.
+func currentHistoryEntry(state *GemPaintState) historyEntry {
+	layers := make([]*Layer, len(state.layers))
+	copy(layers, state.layers)
+	return historyEntry{document: state.document, layers: layers, activeLayerIndex: state.activeLayerIndex}
+}
+
+func restoreHistoryEntry(state *GemPaintState, entry historyEntry) {
+	state.document = entry.document
+	state.layers = entry.layers
+	state.activeLayerIndex = entry.activeLayerIndex
+	state.previousPaintPosition = mouseIsOutsideCanvas
+	keepSelectionInBounds(state)
+	cancelFreeTransform(state) // It was lifted off a layer that may not be there anymore
+	cancelPen(state)
+	cancelText(state)
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Rect)
+	copy(clone.Pix, img.Pix)
+	return clone
+}
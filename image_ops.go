@@ -0,0 +1,128 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// resizeCanvasRGBA changes the dimensions of img without scaling its content. The content is placed according
+// to anchor, where each coordinate is 0 (left/top), 1 (center) or 2 (right/bottom). New areas are filled with fill.
+func resizeCanvasRGBA(img *image.RGBA, width, height int, anchor image.Point, fill color.Color) *image.RGBA {
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	if _, _, _, a := fill.RGBA(); a != 0 {
+		fillImageWithColor(resized, fill)
+	}
+
+	offset := canvasOffset(img.Rect.Size(), width, height, anchor)
+	draw.Draw(resized, img.Rect.Sub(img.Rect.Min).Add(offset), img, img.Rect.Min, draw.Src)
+	return resized
+}
+
+// canvasOffset returns where resizeCanvasRGBA places the content of an image with size.
+func canvasOffset(size image.Point, width, height int, anchor image.Point) image.Point {
+	return image.Point{
+		X: (width - size.X) * anchor.X / 2,
+		Y: (height - size.Y) * anchor.Y / 2,
+	}
+}
+
+// cropRGBA copies the part of img inside rect into a new image whose origin is (0, 0).
+func cropRGBA(img *image.RGBA, rect image.Rectangle) *image.RGBA {
+	rect = rect.Intersect(img.Rect)
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Rect, img, rect.Min, draw.Src)
+	return cropped
+}
+
+// commitImageChange replaces the images of all the layers (bottom to top), possibly with different
+// dimensions, as one undo step. All the images must have the same bounds. They are the images from layerImages
+// mapped through m, which moves the vector shapes of the layers along with them.
+func commitImageChange(state *GemPaintState, images []*image.RGBA, m affineMatrix) {
+	state.history.Record(state)
+
+	for i, layer := range state.layers {
+		layer.Image = tiledCanvasFromRGBA(images[i])
+		moveLayerContent(state, layer, m)
+	}
+
+	bounds := images[0].Rect
+	state.document.Width = bounds.Dx()
+	state.document.Height = bounds.Dy()
+	state.previousPaintPosition = mouseIsOutsideCanvas
+	keepSelectionInBounds(state)
+}
+
+// commitLayerChange replaces the image of the active layer as one undo step. It must have the document's bounds.
+// A text layer whose pixels are changed becomes a raster layer, since setting its text again would undo the change.
+func commitLayerChange(state *GemPaintState, img *TiledCanvas) {
+	replaceLayerImage(state, activeLayer(state), img)
+}
+
+// replaceLayerImage is commitLayerChange for any of the layers, eg. the one a transform was lifted off.
+func replaceLayerImage(state *GemPaintState, layer *Layer, img *TiledCanvas) {
+	state.history.Record(state)
+	layer.Image = img
+	if layer.Kind == TextLayer {
+		layer.Kind = RasterLayer
+	}
+	state.previousPaintPosition = mouseIsOutsideCanvas
+}
+
+// commitLayerTransform replaces the image of the active layer with img as one undo step. img is the layer's own
+// image mapped through m, eg. flipped, and the vector shapes of the layer are moved along with it.
+func commitLayerTransform(state *GemPaintState, img *image.RGBA, m affineMatrix) {
+	state.history.Record(state)
+	activeLayer(state).Image = tiledCanvasFromRGBA(img)
+	moveLayerContent(state, activeLayer(state), m)
+	state.previousPaintPosition = mouseIsOutsideCanvas
+}
+
+// moveLayerContent maps the vector shapes and the text of a layer through m, after its pixels were. Text that is
+// only moved, eg. by a crop, is set again at its new position, so the parts that were outside the canvas come
+// back. Text can't be flipped, rotated or stretched, so then the layer keeps its transformed pixels and becomes
+// a raster layer.
+func moveLayerContent(state *GemPaintState, layer *Layer, m affineMatrix) {
+	layer.Shapes = transformShapes(layer.Shapes, m)
+	if layer.Kind != TextLayer {
+		return
+	}
+
+	if m[0] == 1 && m[1] == 0 && m[3] == 0 && m[4] == 1 {
+		layer.Text.Position = m.applyVec(layer.Text.Position)
+		layer.Image = renderText(layer.Text, findFont(state.fonts, layer.Text.Font).Face, layer.Image.Rect)
+	} else {
+		layer.Kind = RasterLayer
+	}
+}
+
+// transformImage applies transform to every layer as one undo step, eg. to crop the whole image. m maps the
+// old image onto the new one, as transform does.
+func transformImage(state *GemPaintState, transform func(img *image.RGBA) *image.RGBA, m affineMatrix) {
+	images := make([]*image.RGBA, len(state.layers))
+	for i, img := range layerImages(state.layers) {
+		images[i] = transform(img)
+	}
+	commitImageChange(state, images, m)
+}
+
+// opaqueBounds returns the smallest rectangle holding every pixel of img that isn't fully transparent.
+func opaqueBounds(img *image.RGBA) image.Rectangle {
+	bounds := image.Rectangle{}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		first, last := -1, -1
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0 {
+				if first < 0 {
+					first = i / 4
+				}
+				last = i / 4
+			}
+		}
+		if first >= 0 {
+			bounds = bounds.Union(image.Rect(img.Rect.Min.X+first, y, img.Rect.Min.X+last+1, y+1))
+		}
+	}
+	return bounds
+}
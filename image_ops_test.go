@@ -0,0 +1,125 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+// Cropping, flipping and rotating must move the shapes of a vector layer along with the pixels, and keep the
+// layer a vector layer.
+func TestImageChangesMoveVectorShapes(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	path := VectorPath{Anchors: []PathAnchor{
+		cornerAnchor(vec2{X: 100, Y: 50}),
+		{Point: vec2{X: 300, Y: 250}, In: vec2{X: 300, Y: 150}, Out: vec2{X: 300, Y: 250}},
+	}}
+	movedPath := func(m func(p vec2) vec2) []PathAnchor {
+		var anchors []PathAnchor
+		for _, anchor := range path.Anchors {
+			anchors = append(anchors, PathAnchor{Point: m(anchor.Point), In: m(anchor.In), Out: m(anchor.Out)})
+		}
+		return anchors
+	}
+
+	tests := []struct {
+		name     string
+		change   func(state *GemPaintState)
+		expected []PathAnchor
+	}{
+		{
+			"crop",
+			func(state *GemPaintState) {
+				state.crop.rect = image.Rect(50, 20, 350, 280)
+				applyCrop(state)
+			},
+			movedPath(func(p vec2) vec2 { return vec2{X: p.X - 50, Y: p.Y - 20} }),
+		},
+		{
+			"flip",
+			func(state *GemPaintState) { transformImageLosslessly(state, flipHorizontalTransform) },
+			movedPath(func(p vec2) vec2 { return vec2{X: 400 - p.X, Y: p.Y} }),
+		},
+		{
+			// The rotated 300 x 400 layer is centered in the 400 x 300 document.
+			"rotate the layer",
+			func(state *GemPaintState) { transformActiveLayer(state, rotate90Transform) },
+			movedPath(func(p vec2) vec2 { return vec2{X: 300 - p.Y + 50, Y: p.X - 50} }),
+		},
+	}
+	for _, test := range tests {
+		layer := newVectorLayer("Shapes", bounds)
+		layer.Shapes = []VectorShape{{Path: path, Mode: ShapeStroke, Color: red, Width: 4}}
+		state := &GemPaintState{
+			document:              Document{Width: bounds.Dx(), Height: bounds.Dy()},
+			layers:                []*Layer{newLayer("Background", newTiledCanvas(bounds, opaqueWhite)), layer},
+			activeLayerIndex:      1,
+			previousPaintPosition: mouseIsOutsideCanvas,
+		}
+
+		test.change(state)
+
+		changed := activeLayer(state)
+		if changed.Kind != VectorLayer || len(changed.Shapes) != 1 {
+			t.Errorf("%s: the layer has kind %v and %d shapes, expected a vector layer with 1 shape", test.name, changed.Kind, len(changed.Shapes))
+			continue
+		}
+		if anchors := changed.Shapes[0].Path.Anchors; !reflect.DeepEqual(anchors, test.expected) {
+			t.Errorf("%s: the anchors moved to %+v, expected %+v", test.name, anchors, test.expected)
+		}
+		if width := changed.Shapes[0].Width; width != 4 {
+			t.Errorf("%s: the stroke is %v wide, expected 4", test.name, width)
+		}
+		if saved := state.history.undoStack[0].layers[1].Shapes[0].Path; !reflect.DeepEqual(saved, path) {
+			t.Errorf("%s: the shape in the undo history was changed to %+v", test.name, saved)
+		}
+	}
+}
+
+// Cropping moves a text layer's text, which stays editable, while flipping it turns it into pixels.
+func TestImageChangesMoveText(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	fonts := builtInFonts()
+	text := TextObject{Content: "Gem", Font: fonts[0].Name, Size: 48, Color: red, Alignment: AlignLeft, LineSpacing: 1, Position: vec2{X: 220, Y: 120}}
+
+	tests := []struct {
+		name     string
+		change   func(state *GemPaintState)
+		kind     LayerKind
+		position vec2
+	}{
+		{
+			"crop",
+			func(state *GemPaintState) {
+				state.crop.rect = image.Rect(200, 100, 400, 300)
+				applyCrop(state)
+			},
+			TextLayer,
+			vec2{X: 20, Y: 20},
+		},
+		{"flip", func(state *GemPaintState) { transformImageLosslessly(state, flipHorizontalTransform) }, RasterLayer, text.Position},
+	}
+	for _, test := range tests {
+		layer := newTextLayer("Text", bounds, text)
+		layer.Image = renderText(text, fonts[0].Face, bounds)
+		state := &GemPaintState{
+			document:              Document{Width: bounds.Dx(), Height: bounds.Dy()},
+			layers:                []*Layer{layer},
+			fonts:                 fonts,
+			previousPaintPosition: mouseIsOutsideCanvas,
+		}
+
+		test.change(state)
+
+		if layer.Kind != test.kind || layer.Text.Position != test.position {
+			t.Errorf("%s: the layer has kind %v with the text at %v, expected kind %v with the text at %v", test.name, layer.Kind, layer.Text.Position, test.kind, test.position)
+			continue
+		}
+		if layer.Kind == TextLayer {
+			expected := renderText(layer.Text, fonts[0].Face, layer.Image.Rect).RGBA()
+			if !reflect.DeepEqual(layer.Image.RGBA(), expected) {
+				t.Errorf("%s: the layer's pixels aren't its text set again", test.name)
+			}
+		}
+	}
+}
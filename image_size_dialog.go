@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// ImageSizeDialog scales the whole image to new dimensions with a choice of resampling filter.
+type ImageSizeDialog struct {
+	widthEditor     widget.Editor
+	heightEditor    widget.Editor
+	keepProportions widget.Bool
+	filter          widget.Enum
+
+	aspectRatio float64 // width / height of the canvas when the dialog was opened
+
+	okButton     widget.Clickable
+	cancelButton widget.Clickable
+
+	errorMessage string
+}
+
+func newImageSizeDialog(document Document) *ImageSizeDialog {
+	d := &ImageSizeDialog{aspectRatio: float64(document.Width) / float64(document.Height)}
+
+	for _, editor := range []*widget.Editor{&d.widthEditor, &d.heightEditor} {
+		editor.SingleLine = true
+		editor.Filter = "0123456789"
+	}
+	d.widthEditor.SetText(strconv.Itoa(document.Width))
+	d.heightEditor.SetText(strconv.Itoa(document.Height))
+
+	d.keepProportions.Value = true
+	d.filter.Value = Bicubic.Name
+
+	return d
+}
+
+func (d *ImageSizeDialog) Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	// Keep the proportions while the user types in one of the dimensions.
+	for {
+		ev, ok := d.widthEditor.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.ChangeEvent); ok && d.keepProportions.Value && gtx.Focused(&d.widthEditor) {
+			if width, err := editorInt(&d.widthEditor); err == nil {
+				d.heightEditor.SetText(strconv.Itoa(max(int(math.Round(float64(width)/d.aspectRatio)), 1)))
+			}
+		}
+	}
+
+	for {
+		ev, ok := d.heightEditor.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.ChangeEvent); ok && d.keepProportions.Value && gtx.Focused(&d.heightEditor) {
+			if height, err := editorInt(&d.heightEditor); err == nil {
+				d.widthEditor.SetText(strconv.Itoa(max(int(math.Round(float64(height)*d.aspectRatio)), 1)))
+			}
+		}
+	}
+
+	if d.cancelButton.Clicked(gtx) {
+		closeDialog(state)
+	}
+
+	if d.okButton.Clicked(gtx) {
+		width, widthErr := editorInt(&d.widthEditor)
+		height, heightErr := editorInt(&d.heightEditor)
+
+		if widthErr != nil || heightErr != nil || width < 1 || height < 1 || width > maximumCanvasSize || height > maximumCanvasSize {
+			d.errorMessage = fmt.Sprintf("width and height must be between 1 and %d", maximumCanvasSize)
+		} else {
+			resizeImage(state, width, height, resampleFilterByName(d.filter.Value))
+			closeDialog(state)
+		}
+	}
+
+	return layoutDialog(gtx, state, theme, "Image Size", func(gtx layout.Context) layout.Dimensions {
+		filters := make([]layout.FlexChild, 0, len(resampleFilters))
+		for _, filter := range resampleFilters {
+			filters = append(filters, layout.Rigid(material.RadioButton(theme, &d.filter, filter.Name, filter.Name).Layout))
+		}
+
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Width (px)", &d.widthEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Height (px)", &d.heightEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(material.CheckBox(theme, &d.keepProportions, "Keep proportions").Layout),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(material.Body1(theme, "Resampling").Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, filters...)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogError(gtx, theme, d.errorMessage)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				ok := material.Button(theme, &d.okButton, "Resize")
+				ok.Background = golangBlue
+				cancel := material.Button(theme, &d.cancelButton, "Cancel")
+				cancel.Background = darkGray
+				return layoutDialogButtons(gtx, theme, cancel, ok)
+			}),
+		)
+	})
+}
+
+// resizeImage scales every layer in the background, since the better filters are slow on big images.
+func resizeImage(state *GemPaintState, width, height int, filter ResampleFilter) {
+	sources := layerImages(state.layers)
+
+	startJob(state, "Resizing", func(ctx context.Context, reportProgress func(done float32)) (func(state *GemPaintState), error) {
+		resized := make([]*image.RGBA, len(sources))
+		for i, source := range sources {
+			layerProgress := func(done float32) {
+				reportProgress((float32(i) + done) / float32(len(sources)))
+			}
+
+			var err error
+			resized[i], err = resampleRGBA(ctx, source, width, height, filter, layerProgress)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return func(state *GemPaintState) {
+			scale := scaleMatrix(float64(width)/float64(sources[0].Rect.Dx()), float64(height)/float64(sources[0].Rect.Dy()))
+			commitImageChange(state, resized, scale)
+		}, nil
+	})
+}
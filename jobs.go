@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+)
+
+// JobFunc does the work of a long-running operation off the ui goroutine. It must only touch data it owns
+// (eg. a snapshot of the canvas) and should return ctx.Err() soon after ctx is cancelled. On success it
+// returns a commit function, which is later called on the ui goroutine to apply the result to the state
+// and history in one step.
+type JobFunc func(ctx context.Context, reportProgress func(done float32)) (commit func(state *GemPaintState), err error)
+
+// Job is a long-running operation, like a flood fill on a huge canvas, running in its own goroutine.
+type Job struct {
+	Name string
+
+	cancel   context.CancelFunc
+	progress atomic.Uint32 // math.Float32bits of the fraction done
+	finished atomic.Bool
+
+	// Only read after finished is set.
+	commit func(state *GemPaintState)
+	err    error
+}
+
+func (j *Job) Progress() float32 {
+	return math.Float32frombits(j.progress.Load())
+}
+
+// startJob runs work in a new goroutine. Only one job runs at a time, painting is disabled while it runs.
+func startJob(state *GemPaintState, name string, work JobFunc) {
+	if state.job != nil {
+		if debug {
+			fmt.Println("Error: A job is already running: ", state.job.Name)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{Name: name, cancel: cancel}
+	state.job = job
+
+	window := state.window
+	go func() {
+		reportProgress := func(done float32) {
+			job.progress.Store(math.Float32bits(done))
+			window.Invalidate()
+		}
+
+		commit, err := work(ctx, reportProgress)
+
+		job.commit, job.err = commit, err
+		job.finished.Store(true)
+		window.Invalidate() // Wake up the ui goroutine so it can commit the result.
+	}()
+}
+
+// cancelJob asks the running job to stop. Its result is thrown away once it returns.
+func cancelJob(state *GemPaintState) {
+	if state.job == nil {
+		return
+	}
+
+	state.job.cancel()
+	if debug {
+		fmt.Println("Cancelling job: ", state.job.Name)
+	}
+}
+
+// pollJob commits the running job if it has finished. It must be called from the ui goroutine.
+func pollJob(state *GemPaintState) {
+	job := state.job
+	if job == nil || !job.finished.Load() {
+		return
+	}
+
+	state.job = nil
+	job.cancel() // Release the context's resources.
+
+	if job.err != nil {
+		if debug && !errors.Is(job.err, context.Canceled) {
+			fmt.Println("Error: ", job.Name, ": ", job.err)
+		}
+		return
+	}
+
+	if job.commit != nil {
+		job.commit(state)
+	}
+}
+
+func layoutJobProgress(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	if state.job == nil {
+		return layout.Dimensions{}
+	}
+
+	return layoutPanel(gtx, state.job, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(12).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(unit.Dp(240))
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(material.Body1(theme, state.job.Name+"… (Esc to cancel)").Layout),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+				layout.Rigid(material.ProgressBar(theme, state.job.Progress()).Layout),
+			)
+		})
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+)
+
+// The keymap binds keyboard shortcuts to actions. The defaults below can be changed in keymap.json, next to the
+// saved palettes, which maps action names to shortcuts, eg. {"brush": "B", "redo": ["Ctrl+Shift+Z", "Ctrl+Y"]}.
+// An action mapped to nothing, eg. "", has no shortcut. Esc and Enter always cancel and finish what the current
+// tool is doing, so they can't be bound.
+
+const keymapFileName = "keymap.json"
+
+// Shortcut is a key with the modifiers that have to be held with it. Ctrl stands for Cmd on macOS.
+type Shortcut struct {
+	Name      key.Name
+	Modifiers key.Modifiers
+}
+
+type KeyBinding struct {
+	Shortcut Shortcut
+	Action   string
+}
+
+var defaultKeymap = []struct {
+	Action    string
+	Shortcuts []string
+}{
+	{"brush", []string{"B"}},
+	{"eraser", []string{"E"}},
+	{"bucket", []string{"G"}},
+	{"eyedropper", []string{"I"}},
+	{"crop", []string{"C"}},
+	{"rectangle-select", []string{"M"}},
+	{"ellipse-select", []string{"Shift+M"}},
+	{"lasso", []string{"L"}},
+	{"polygon-lasso", []string{"Shift+L"}},
+	{"magic-wand", []string{"W"}},
+	{"transform", []string{"Ctrl+T"}},
+	{"line", []string{"U"}},
+	{"rectangle-shape", []string{"R"}},
+	{"ellipse-shape", []string{"O"}},
+	{"polygon-shape", []string{"Shift+U"}},
+	{"pen", []string{"P"}},
+	{"text", []string{"T"}},
+	{"increase-radius", []string{"]"}},
+	{"decrease-radius", []string{"["}},
+	{"swap-colors", []string{"X"}},
+	{"reset-colors", []string{"D"}},
+	{"undo", []string{"Ctrl+Z"}},
+	{"redo", []string{"Ctrl+Shift+Z", "Ctrl+Y"}},
+	{"new", []string{"Ctrl+N"}},
+	{"save", []string{"Ctrl+S"}},
+	{"export-svg", []string{"Ctrl+Shift+S"}},
+	{"clear", []string{"Delete"}},
+	{"cut", []string{"Ctrl+X"}},
+	{"copy", []string{"Ctrl+C"}},
+	{"paste", []string{"Ctrl+V"}},
+	{"select-all", []string{"Ctrl+A"}},
+	{"deselect", []string{"Ctrl+D"}},
+	{"image-size", []string{"Ctrl+Alt+I"}},
+	{"canvas-size", []string{"Ctrl+Alt+C"}},
+}
+
+// The keys that have names, by how they are written in the keymap. Other keys are written as the character on
+// them, eg. B or [.
+var keyNames = map[string]key.Name{
+	"delete":    key.NameDeleteForward,
+	"backspace": key.NameDeleteBackward,
+	"space":     key.NameSpace,
+	"tab":       key.NameTab,
+	"home":      key.NameHome,
+	"end":       key.NameEnd,
+	"pageup":    key.NamePageUp,
+	"pagedown":  key.NamePageDown,
+	"up":        key.NameUpArrow,
+	"down":      key.NameDownArrow,
+	"left":      key.NameLeftArrow,
+	"right":     key.NameRightArrow,
+	"plus":      "+",
+}
+
+var modifierNames = map[string]key.Modifiers{
+	"ctrl":  key.ModShortcut,
+	"cmd":   key.ModShortcut,
+	"shift": key.ModShift,
+	"alt":   key.ModAlt,
+}
+
+// parseShortcut reads a shortcut written like Ctrl+Shift+Z.
+func parseShortcut(s string) (Shortcut, error) {
+	parts := strings.Split(strings.TrimSpace(s), "+")
+	keyName := strings.TrimSpace(parts[len(parts)-1])
+	if keyName == "" {
+		return Shortcut{}, fmt.Errorf("invalid shortcut %q", s)
+	}
+
+	var shortcut Shortcut
+	for _, part := range parts[:len(parts)-1] {
+		modifier, ok := modifierNames[strings.ToLower(strings.TrimSpace(part))]
+		if !ok {
+			return Shortcut{}, fmt.Errorf("invalid modifier %q in shortcut %q", part, s)
+		}
+		shortcut.Modifiers |= modifier
+	}
+
+	lower := strings.ToLower(keyName)
+	switch {
+	case keyNames[lower] != "":
+		shortcut.Name = keyNames[lower]
+	case len(lower) >= 2 && lower[0] == 'f' && strings.Trim(lower[1:], "0123456789") == "": // F1 to F12
+		shortcut.Name = key.Name(strings.ToUpper(keyName))
+	case len([]rune(keyName)) == 1:
+		shortcut.Name = key.Name(strings.ToUpper(keyName))
+	default:
+		return Shortcut{}, fmt.Errorf("invalid key %q in shortcut %q", keyName, s)
+	}
+
+	if shortcut.Name == key.NameEscape || shortcut.Name == key.NameReturn || shortcut.Name == key.NameEnter {
+		return Shortcut{}, fmt.Errorf("%q can't be bound", s)
+	}
+	return shortcut, nil
+}
+
+// loadKeymap binds the default shortcuts, then the ones in the keymap file. The first time, the file is made
+// with the defaults in it, so that there is something to edit.
+func loadKeymap(state *GemPaintState) {
+	state.keymap = nil
+	for _, binding := range defaultKeymap {
+		state.keymap = bindShortcuts(state.keymap, binding.Action, binding.Shortcuts)
+	}
+
+	data, err := readConfigOnPlatform(keymapFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := writeConfigOnPlatform(keymapFileName, encodeDefaultKeymap()); err != nil && debug {
+			fmt.Println("Error: ", err)
+		}
+		return
+	}
+	if err != nil {
+		if debug {
+			fmt.Println("Error: ", err)
+		}
+		return
+	}
+
+	var saved map[string]json.RawMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		if debug {
+			fmt.Println("Error: could not read the keymap: ", err)
+		}
+		return
+	}
+	state.keymap = bindSavedShortcuts(state.keymap, saved)
+}
+
+// bindSavedShortcuts binds the shortcuts read from the keymap file. The actions are bound in alphabetical order,
+// so that a shortcut given to two of them always ends up with the same one, rather than a random one.
+func bindSavedShortcuts(keymap []KeyBinding, saved map[string]json.RawMessage) []KeyBinding {
+	names := make([]string, 0, len(saved))
+	for action := range saved {
+		names = append(names, action)
+	}
+	slices.Sort(names)
+
+	for _, action := range names {
+		value := saved[action]
+		if _, ok := actions[action]; !ok {
+			if debug {
+				fmt.Println("Error: Unknown action in the keymap: ", action)
+			}
+			continue
+		}
+
+		// An action has one shortcut or a list of them.
+		var shortcuts []string
+		var shortcut string
+		if err := json.Unmarshal(value, &shortcut); err == nil {
+			shortcuts = []string{shortcut}
+		} else if err := json.Unmarshal(value, &shortcuts); err != nil {
+			if debug {
+				fmt.Println("Error: Invalid shortcuts for ", action, ": ", string(value))
+			}
+			continue
+		}
+		keymap = bindShortcuts(keymap, action, shortcuts)
+	}
+	return keymap
+}
+
+// bindShortcuts replaces the shortcuts of action with the ones given. Blank ones are left out, so that an action
+// can be unbound, and a shortcut that was bound to another action is taken from it.
+func bindShortcuts(keymap []KeyBinding, action string, shortcuts []string) []KeyBinding {
+	keymap = slices.DeleteFunc(keymap, func(b KeyBinding) bool { return b.Action == action })
+	for _, s := range shortcuts {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		shortcut, err := parseShortcut(s)
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			continue
+		}
+		keymap = slices.DeleteFunc(keymap, func(b KeyBinding) bool { return b.Shortcut == shortcut })
+		keymap = append(keymap, KeyBinding{Shortcut: shortcut, Action: action})
+	}
+	return keymap
+}
+
+func encodeDefaultKeymap() []byte {
+	var buf strings.Builder
+	buf.WriteString("{\n")
+	for i, binding := range defaultKeymap {
+		name, _ := json.Marshal(binding.Action)
+		var shortcuts []byte
+		if len(binding.Shortcuts) == 1 {
+			shortcuts, _ = json.Marshal(binding.Shortcuts[0])
+		} else {
+			shortcuts, _ = json.Marshal(binding.Shortcuts)
+		}
+		fmt.Fprintf(&buf, "\t%s: %s", name, shortcuts)
+		if i < len(defaultKeymap)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	return []byte(buf.String())
+}
+
+// shortcutFilters returns a filter for every bound shortcut, so that their key presses reach handleKeys. None are
+// returned while a text field is typed in, so that it gets its keys, Ctrl+Z and Ctrl+C included.
+func shortcutFilters(gtx layout.Context, state *GemPaintState) []event.Filter {
+	if isTyping(gtx, state) {
+		return nil
+	}
+
+	filters := make([]event.Filter, len(state.keymap))
+	for i, binding := range state.keymap {
+		filters[i] = key.Filter{Name: binding.Shortcut.Name, Required: binding.Shortcut.Modifiers}
+	}
+	return filters
+}
+
+// shortcutAction returns the action bound to the key that was pressed, if any.
+func shortcutAction(keymap []KeyBinding, e key.Event) (string, bool) {
+	for _, binding := range keymap {
+		if binding.Shortcut.Name == e.Name && binding.Shortcut.Modifiers == e.Modifiers {
+			return binding.Action, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gioui.org/io/key"
+)
+
+func TestParseShortcut(t *testing.T) {
+	tests := []struct {
+		shortcut string
+		expected Shortcut
+	}{
+		{"B", Shortcut{Name: "B"}},
+		{"b", Shortcut{Name: "B"}},
+		{"[", Shortcut{Name: "["}},
+		{"]", Shortcut{Name: "]"}},
+		{"Ctrl+Z", Shortcut{Name: "Z", Modifiers: key.ModShortcut}},
+		{"Ctrl+Shift+Z", Shortcut{Name: "Z", Modifiers: key.ModShortcut | key.ModShift}},
+		{" shift + ctrl + z ", Shortcut{Name: "Z", Modifiers: key.ModShortcut | key.ModShift}},
+		{"Cmd+S", Shortcut{Name: "S", Modifiers: key.ModShortcut}},
+		{"Ctrl+Alt+I", Shortcut{Name: "I", Modifiers: key.ModShortcut | key.ModAlt}},
+		{"Delete", Shortcut{Name: key.NameDeleteForward}},
+		{"Space", Shortcut{Name: key.NameSpace}},
+		{"Ctrl+Plus", Shortcut{Name: "+", Modifiers: key.ModShortcut}},
+		{"F5", Shortcut{Name: key.NameF5}},
+		{"Shift+f12", Shortcut{Name: key.NameF12, Modifiers: key.ModShift}},
+	}
+	for _, test := range tests {
+		shortcut, err := parseShortcut(test.shortcut)
+		if err != nil {
+			t.Errorf("%q: %v", test.shortcut, err)
+			continue
+		}
+		if shortcut != test.expected {
+			t.Errorf("%q: read %+v, expected %+v", test.shortcut, shortcut, test.expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "+", "Ctrl+", "Ctrl++", "Hyper+B", "Shift", "BB", "Ctrl+Escape", "Esc", "Enter", "Return"} {
+		if shortcut, err := parseShortcut(invalid); err == nil {
+			t.Errorf("%q was read as %+v without an error", invalid, shortcut)
+		}
+	}
+}
+
+func TestBindShortcuts(t *testing.T) {
+	var keymap []KeyBinding
+	keymap = bindShortcuts(keymap, "undo", []string{"Ctrl+Z"})
+	keymap = bindShortcuts(keymap, "redo", []string{"Ctrl+Shift+Z", "Ctrl+Y"})
+
+	// Binding a shortcut to another action takes it from the action it was bound to.
+	keymap = bindShortcuts(keymap, "brush", []string{"Ctrl+Y"})
+	// Invalid shortcuts are left out, and blank ones unbind the action.
+	keymap = bindShortcuts(keymap, "undo", []string{"Hyper+Z", ""})
+
+	tests := []struct {
+		event    key.Event
+		action   string
+		expected bool
+	}{
+		{key.Event{Name: "Z", Modifiers: key.ModShortcut | key.ModShift}, "redo", true},
+		{key.Event{Name: "Y", Modifiers: key.ModShortcut}, "brush", true},
+		{key.Event{Name: "Z", Modifiers: key.ModShortcut}, "", false},
+		{key.Event{Name: "Y"}, "", false}, // The modifiers must match exactly
+	}
+	for _, test := range tests {
+		action, ok := shortcutAction(keymap, test.event)
+		if action != test.action || ok != test.expected {
+			t.Errorf("%+v: ran %q (%v), expected %q (%v)", test.event, action, ok, test.action, test.expected)
+		}
+	}
+}
+
+// A shortcut that the keymap file gives to two actions must always go to the same one.
+func TestBindSavedShortcutsInOrder(t *testing.T) {
+	saved := map[string]json.RawMessage{
+		"undo":  json.RawMessage(`"Ctrl+K"`),
+		"redo":  json.RawMessage(`["Ctrl+K", "Ctrl+Y"]`),
+		"brush": json.RawMessage(`"Ctrl+K"`),
+	}
+
+	// Maps are iterated in a random order, so a few tries would bind it to different actions.
+	for try := 0; try < 20; try++ {
+		keymap := bindSavedShortcuts(nil, saved)
+		action, _ := shortcutAction(keymap, key.Event{Name: "K", Modifiers: key.ModShortcut})
+		if action != "undo" {
+			t.Fatalf("the shortcut was bound to %q, expected %q", action, "undo")
+		}
+	}
+}
+
+// The keymap file written the first time the app runs must read back as the defaults, and only name actions that exist.
+func TestDefaultKeymapFile(t *testing.T) {
+	var saved map[string]any
+	if err := json.Unmarshal(encodeDefaultKeymap(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(defaultKeymap) {
+		t.Errorf("the file has %d actions, expected %d", len(saved), len(defaultKeymap))
+	}
+
+	for _, binding := range defaultKeymap {
+		if _, ok := actions[binding.Action]; !ok {
+			t.Errorf("the default keymap binds the unknown action %q", binding.Action)
+		}
+		for _, s := range binding.Shortcuts {
+			if _, err := parseShortcut(s); err != nil {
+				t.Errorf("the default shortcut %q of %q is invalid: %v", s, binding.Action, err)
+			}
+		}
+	}
+}
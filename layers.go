@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"slices"
+
+	"gioui.org/layout"
+)
+
+// Layer is one of the stacked images that make up the document. Layers are drawn bottom (index 0) to top.
+type Layer struct {
+	Name    string
+	Kind    LayerKind
+	Image   *TiledCanvas
+	Visible bool
+
+	// Vector shapes drawn above the image, that can still be edited. The slice is shared with the undo history,
+	// so it is replaced rather than changed in place.
+	Shapes []VectorShape
+
+	Text TextObject // What a text layer says, its image is the text set in its font
+}
+
+// LayerKind decides what the tools do to a layer. The shape tools paint pixels on a raster layer, but add
+// shapes that stay editable to a vector layer, which has no pixels of its own. A text layer can be typed in
+// again with the text tool, so its pixels can't be painted on either.
+type LayerKind int
+
+const (
+	RasterLayer LayerKind = iota
+	VectorLayer
+	TextLayer
+)
+
+func newLayer(name string, img *TiledCanvas) *Layer {
+	return &Layer{Name: name, Image: img, Visible: true}
+}
+
+func newVectorLayer(name string, bounds image.Rectangle) *Layer {
+	return &Layer{Name: name, Kind: VectorLayer, Image: newTiledCanvas(bounds, transparent), Visible: true}
+}
+
+func newTextLayer(name string, bounds image.Rectangle, text TextObject) *Layer {
+	return &Layer{Name: name, Kind: TextLayer, Image: newTiledCanvas(bounds, transparent), Visible: true, Text: text}
+}
+
+// activeLayer returns the layer that the tools paint on.
+func activeLayer(state *GemPaintState) *Layer {
+	return state.layers[state.activeLayerIndex]
+}
+
+// flattenLayers composites the visible layers, with their vector shapes, into a single image, eg. for exporting.
+func flattenLayers(layers []*Layer, bounds image.Rectangle) *image.RGBA {
+	flattened := image.NewRGBA(bounds)
+	for _, layer := range layers {
+		if !layer.Visible {
+			continue
+		}
+		layer.Image.drawInto(flattened, draw.Over)
+		rasterizeShapes(flattened, layer.Shapes)
+	}
+	return flattened
+}
+
+// displayLayers returns the layers as the canvas should show them. Content that was pasted but not committed
+// yet is shown above the active layer, content being transformed is cut out of it, and the shape being edited
+// with the pen is left out, since the pen draws it.
+func displayLayers(state *GemPaintState) []*Layer {
+	switch {
+	case state.freeTransform != nil:
+		return layersWithFreeTransform(state)
+	case state.floating != nil:
+		return layersWithFloating(state)
+	case state.selectedTool == Pen && editedShapeIsValid(state):
+		return layersWithoutEditedShape(state)
+	}
+	return state.layers
+}
+
+// drawLayers draws the visible layers bottom to top, under the view transform. Runs of layers without vector
+// shapes are flattened into one canvas, and the shapes are drawn with clip paths above their layer. A lone
+// layer is shown as is.
+func drawLayers(gtx layout.Context, layers []*Layer, bounds image.Rectangle) layout.Dimensions {
+	var run []*Layer
+	drawRun := func() {
+		if len(run) == 0 {
+			return
+		}
+		canvas := run[0].Image
+		if len(run) > 1 {
+			canvas = flattenTiles(run, bounds)
+		}
+		drawTiles(gtx, canvas)
+		run = run[:0]
+	}
+
+	for _, layer := range layers {
+		if !layer.Visible {
+			continue
+		}
+		run = append(run, layer)
+		if len(layer.Shapes) > 0 {
+			drawRun()
+			for _, shape := range layer.Shapes {
+				drawVectorShape(gtx, shape)
+			}
+		}
+	}
+	drawRun()
+
+	return layout.Dimensions{Size: bounds.Size()}
+}
+
+// flattenTiles composites the images of the layers, without their shapes, for display. Only the tiles that one
+// of the layers has painted on are allocated, the others are solid. The layers must all have bounds.
+func flattenTiles(layers []*Layer, bounds image.Rectangle) *TiledCanvas {
+	solid := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	for _, layer := range layers {
+		draw.Draw(solid, solid.Rect, image.NewUniform(layer.Image.Solid), image.Point{}, draw.Over)
+	}
+	flattened := newTiledCanvas(bounds, solid.RGBAAt(0, 0))
+
+	for i := range flattened.tiles {
+		painted := false
+		for _, layer := range layers {
+			painted = painted || layer.Image.tiles[i] != nil
+		}
+		if !painted {
+			continue
+		}
+
+		tile := image.NewRGBA(flattened.tileRect(i))
+		for _, layer := range layers {
+			layer.Image.drawInto(tile, draw.Over)
+		}
+		flattened.tiles[i] = tile
+	}
+	return flattened
+}
+
+func addLayer(state *GemPaintState) {
+	insertLayer(state, newLayer(fmt.Sprintf("Layer %d", len(state.layers)+1), newTiledCanvas(state.document.Bounds(), transparent)))
+}
+
+func addVectorLayer(state *GemPaintState) {
+	insertLayer(state, newVectorLayer(fmt.Sprintf("Vector %d", len(state.layers)+1), state.document.Bounds()))
+}
+
+// insertLayer inserts layer above the active one and makes it active.
+func insertLayer(state *GemPaintState, layer *Layer) {
+	state.history.Record(state)
+
+	index := state.activeLayerIndex + 1
+	state.layers = append(state.layers[:index], append([]*Layer{layer}, state.layers[index:]...)...)
+	state.activeLayerIndex = index
+}
+
+func deleteActiveLayer(state *GemPaintState) {
+	if len(state.layers) == 1 { // There must always be a layer to paint on
+		return
+	}
+
+	state.history.Record(state)
+
+	index := state.activeLayerIndex
+	state.layers = append(state.layers[:index], state.layers[index+1:]...)
+	state.activeLayerIndex = max(index-1, 0)
+}
+
+// moveActiveLayer moves the active layer up (towards the top) or down the stack by offset.
+func moveActiveLayer(state *GemPaintState, offset int) {
+	from := state.activeLayerIndex
+	to := from + offset
+	if to < 0 || to >= len(state.layers) {
+		return
+	}
+
+	state.history.Record(state)
+
+	state.layers[from], state.layers[to] = state.layers[to], state.layers[from]
+	state.activeLayerIndex = to
+}
+
+// rasterizeActiveLayer paints the shapes of the active layer into its pixels, and turns a text layer into
+// pixels, so that it can be painted on.
+func rasterizeActiveLayer(state *GemPaintState) {
+	layer := activeLayer(state)
+	if layer.Kind == RasterLayer && len(layer.Shapes) == 0 {
+		return
+	}
+
+	commitLayerChange(state, tiledCanvasFromRGBA(layerPixels(layer)))
+	layer.Kind = RasterLayer
+	layer.Shapes = nil
+}
+
+// addShapeToActiveLayer adds shape to the active layer as one undo step.
+func addShapeToActiveLayer(state *GemPaintState, shape VectorShape) {
+	state.history.Record(state)
+	layer := activeLayer(state)
+	layer.Shapes = append(slices.Clone(layer.Shapes), shape)
+}
+
+// layerImages copies the image of every layer, bottom to top, into dense images. The vector shapes aren't
+// painted in, they are moved separately by commitImageChange.
+func layerImages(layers []*Layer) []*image.RGBA {
+	images := make([]*image.RGBA, len(layers))
+	for i, layer := range layers {
+		images[i] = layer.Image.RGBA()
+	}
+	return images
+}
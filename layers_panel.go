@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// LayersPanel lists the layers of the document, top layer first, and lets the user pick the layer to paint on.
+type LayersPanel struct {
+	rows []layerRow
+
+	addButton       widget.Clickable
+	deleteButton    widget.Clickable
+	moveUpButton    widget.Clickable
+	moveDownButton  widget.Clickable
+	addVectorButton widget.Clickable
+	rasterizeButton widget.Clickable
+
+	list layout.List
+}
+
+type layerRow struct {
+	selectButton     widget.Clickable
+	visibilityButton widget.Clickable
+}
+
+func layoutLayersPanel(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	panel := &state.layersPanel
+	for len(panel.rows) < len(state.layers) {
+		panel.rows = append(panel.rows, layerRow{})
+	}
+
+	canEdit := state.job == nil
+	for i, layer := range state.layers {
+		row := &panel.rows[i]
+		if row.selectButton.Clicked(gtx) && canEdit {
+			state.activeLayerIndex = i
+			state.previousPaintPosition = mouseIsOutsideCanvas
+			if debug {
+				fmt.Println("Active layer: ", layer.Name)
+			}
+		}
+		if row.visibilityButton.Clicked(gtx) {
+			layer.Visible = !layer.Visible
+		}
+	}
+
+	if panel.addButton.Clicked(gtx) && canEdit {
+		addLayer(state)
+	}
+	if panel.deleteButton.Clicked(gtx) && canEdit {
+		deleteActiveLayer(state)
+	}
+	if panel.moveUpButton.Clicked(gtx) && canEdit {
+		moveActiveLayer(state, 1)
+	}
+	if panel.moveDownButton.Clicked(gtx) && canEdit {
+		moveActiveLayer(state, -1)
+	}
+	if panel.addVectorButton.Clicked(gtx) && canEdit {
+		addVectorLayer(state)
+	}
+	if panel.rasterizeButton.Clicked(gtx) && canEdit {
+		rasterizeActiveLayer(state)
+	}
+
+	return layoutPanel(gtx, panel, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(10).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Max.X = gtx.Dp(unit.Dp(220))
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			gtx.Constraints.Max.Y = gtx.Dp(unit.Dp(320))
+
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(material.Body1(theme, "Layers").Layout),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					panel.list.Axis = layout.Vertical
+					return panel.list.Layout(gtx, len(state.layers), func(gtx layout.Context, i int) layout.Dimensions {
+						index := len(state.layers) - 1 - i // The top layer is listed first
+						return layoutLayerRow(gtx, state, theme, index)
+					})
+				}),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(ToolButton(theme, &panel.addButton, AddIcon, false, golangBlue, lightGray, "Add layer").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+						layout.Rigid(ToolButton(theme, &panel.deleteButton, ClearIcon, false, golangBlue, lightGray, "Delete layer").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+						layout.Rigid(ToolButton(theme, &panel.moveUpButton, MoveUpIcon, false, golangBlue, lightGray, "Move layer up").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+						layout.Rigid(ToolButton(theme, &panel.moveDownButton, MoveDownIcon, false, golangBlue, lightGray, "Move layer down").Layout),
+					)
+				}),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(6)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(ToolButton(theme, &panel.addVectorButton, VectorLayerIcon, false, golangBlue, lightGray, "Add vector layer").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+						layout.Rigid(ToolButton(theme, &panel.rasterizeButton, RasterizeIcon, false, golangBlue, lightGray, "Rasterize layer").Layout),
+					)
+				}),
+			)
+		})
+	})
+}
+
+func layoutLayerRow(gtx layout.Context, state *GemPaintState, theme *material.Theme, index int) layout.Dimensions {
+	layer := state.layers[index]
+	row := &state.layersPanel.rows[index]
+
+	visibilityIcon := VisibleIcon
+	if !layer.Visible {
+		visibilityIcon = HiddenIcon
+	}
+
+	return layout.Inset{Bottom: 4}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				button := material.IconButton(theme, &row.visibilityButton, visibilityIcon, "Toggle visibility")
+				button.Background = lightGray
+				button.Size = unit.Dp(16)
+				button.Inset = layout.UniformInset(6)
+				return button.Layout(gtx)
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				label := layer.Name
+				switch layer.Kind {
+				case VectorLayer:
+					label += " (Vector)"
+				case TextLayer:
+					label += " (Text)"
+				}
+				button := material.Button(theme, &row.selectButton, label)
+				button.Background = lightGray
+				button.Color = darkGray
+				if index == state.activeLayerIndex {
+					button.Background = golangBlue
+					button.Color = defaultCanvasColor
+				}
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return button.Layout(gtx)
+			}),
+		)
+	})
+}
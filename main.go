@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"log"
 	"math"
 	"os"
-	"runtime"
+	"slices"
 
 	"gioui.org/app"
 	"gioui.org/f32"
 	"gioui.org/io/event"
+	"gioui.org/io/key"
 	"gioui.org/io/pointer"
 	"gioui.org/layout"
 	"gioui.org/op"
@@ -28,29 +31,101 @@ var debug = false
 type GemPaintState struct {
 	theme *material.Theme
 
-	brushButton  widget.Clickable
-	eraserButton widget.Clickable
-	BucketButton widget.Clickable
-	selectedTool SelectedTool
+	brushButton      widget.Clickable
+	eraserButton     widget.Clickable
+	BucketButton     widget.Clickable
+	eyedropperButton widget.Clickable
+	cropButton       widget.Clickable
+	selectedTool     SelectedTool
+
+	rectangleSelectButton widget.Clickable
+	ellipseSelectButton   widget.Clickable
+	lassoButton           widget.Clickable
+	polygonLassoButton    widget.Clickable
+	magicWandButton       widget.Clickable
+	transformButton       widget.Clickable
+	lineButton            widget.Clickable
+	rectangleShapeButton  widget.Clickable
+	ellipseShapeButton    widget.Clickable
+	polygonShapeButton    widget.Clickable
+	penButton             widget.Clickable
+	textButton            widget.Clickable
+	cropToSelectionButton widget.Clickable
+	selectButton          widget.Clickable
+	toolOptions           ToolOptions
 
 	increaseButton widget.Clickable
 	decreaseButton widget.Clickable
 	cursorRadius   int
 
-	clearButton widget.Clickable
-	saveButton  widget.Clickable
-
-	colorButtons       []ColorButtonStyle
-	selectedColorIndex int
+	newButton       widget.Clickable
+	clearButton     widget.Clickable
+	saveButton      widget.Clickable
+	exportSVGButton widget.Clickable
+	undoButton      widget.Clickable
+	redoButton      widget.Clickable
+
+	cutButton   widget.Clickable
+	copyButton  widget.Clickable
+	pasteButton widget.Clickable
+
+	imageSizeButton  widget.Clickable
+	canvasSizeButton widget.Clickable
+	flipButton       widget.Clickable
+	rotateButton     widget.Clickable
+
+	colorButtons       []ColorButtonStyle // Made from the swatches of the active palette
+	recentColors       []color.NRGBA      // The colors painted with last, most recent first
+	recentColorButtons []ColorButtonStyle
+	palettes           []Palette
+	activePaletteIndex int
+	importedPalettes   chan Palette // Palettes read from a file, which arrive once the user has picked it
+	palettesButton     widget.Clickable
+	colorPicker        ColorPicker
+	colorPickerButton  widget.Clickable
+	paintColor         color.NRGBA // The primary color, what the tools paint with, picked from the color buttons or off the canvas
+	secondaryColor     color.NRGBA // What the right button paints with
+	colorPairButton    widget.Clickable
+	pickingColor       bool // Set while the brush picks colors, after an Alt-click
 
 	sidebarButtons layout.List
 
-	canvas                *image.RGBA
-	canvasInputTag        bool
-	mousePositionOnCanvas f32.Point
-	previousPaintPosition f32.Point
-
-	expl *explorer.Explorer
+	document               Document
+	layers                 []*Layer // Bottom to top
+	activeLayerIndex       int
+	layersPanel            LayersPanel
+	view                   ViewTransform
+	canvasInputTag         bool
+	checkerboard           checkerboard
+	mousePositionOnCanvas  f32.Point
+	previousPaintPosition  f32.Point
+	strokeStart            f32.Point // Where the current brush or eraser stroke was pressed
+	paintingWithSecondary  bool      // Set when the current stroke or shape was started with the right button
+	crop                   CropTool
+	selection              *image.Alpha // nil when nothing is selected
+	selectionTool          SelectionTool
+	selectionChannels      []SelectionChannel
+	shapeTool              ShapeTool
+	penTool                PenTool
+	textTool               TextTool
+	fonts                  []TextFont         // The fonts text can be set in, the Go fonts and the ones loaded from files
+	loadedFonts            chan []TextFont    // Fonts read from a file, which arrive once the user has picked it
+	floating               *FloatingSelection // Pasted content that hasn't been committed to the layer yet
+	ignoreDragUntilRelease bool               // Set when a press has been handled, so that the drag that follows doesn't paint
+	clipboard              Clipboard
+	freeTransform          *FreeTransform // The content being transformed with the Transform tool, if any
+	strokeBase             *TiledCanvas   // The active layer as it was when the stroke started, used to keep the stroke inside the selection
+
+	keymap []KeyBinding // The keyboard shortcuts, from the defaults and the keymap file
+
+	history History
+	job     *Job // The long-running operation in progress, if any.
+
+	dialog         Dialog // The open modal dialog, if any.
+	dialogInputTag bool
+
+	window *app.Window
+	expl   *explorer.Explorer
 
 	debug bool
 }
@@ -58,9 +133,25 @@ type GemPaintState struct {
 type SelectedTool string
 
 const (
-	Brush  SelectedTool = "Brush"
-	Eraser SelectedTool = "Eraser"
-	Bucket SelectedTool = "Bucket"
+	Brush      SelectedTool = "Brush"
+	Eraser     SelectedTool = "Eraser"
+	Bucket     SelectedTool = "Bucket"
+	Eyedropper SelectedTool = "Eyedropper"
+	Crop       SelectedTool = "Crop"
+
+	RectangleSelect SelectedTool = "Rectangle Select"
+	EllipseSelect   SelectedTool = "Ellipse Select"
+	Lasso           SelectedTool = "Lasso"
+	PolygonLasso    SelectedTool = "Polygonal Lasso"
+	MagicWand       SelectedTool = "Magic Wand"
+	Transform       SelectedTool = "Transform"
+
+	Line           SelectedTool = "Line"
+	RectangleShape SelectedTool = "Rectangle"
+	EllipseShape   SelectedTool = "Ellipse"
+	PolygonShape   SelectedTool = "Polygon"
+	Pen            SelectedTool = "Pen"
+	Text           SelectedTool = "Text"
 )
 
 func main() {
@@ -96,26 +187,29 @@ func run(window *app.Window) error {
 
 	// Initialize the application state
 	state := GemPaintState{
-		theme:        material.NewTheme(),
-		selectedTool: Brush,
-		cursorRadius: defaultCursorRadius,
-		colorButtons: []ColorButtonStyle{
-			{Color: red, Label: "Red", Clickable: &widget.Clickable{}},
-			{Color: orange, Label: "Orange", Clickable: &widget.Clickable{}},
-			{Color: green, Label: "Green", Clickable: &widget.Clickable{}},
-			{Color: blue, Label: "Blue", Clickable: &widget.Clickable{}},
-			{Color: yellow, Label: "Yellow", Clickable: &widget.Clickable{}},
-			{Color: purple, Label: "Purple", Clickable: &widget.Clickable{}},
-			{Color: darkGray, Label: "Gray", Clickable: &widget.Clickable{}},
-		},
-		selectedColorIndex:    0,
+		theme:                 material.NewTheme(),
+		selectedTool:          Brush,
+		cursorRadius:          defaultCursorRadius,
+		paintColor:            red,
+		secondaryColor:        white,
 		sidebarButtons:        layout.List{Axis: layout.Vertical},
-		canvas:                image.NewRGBA(defaultCanvasDimensions),
+		document:              defaultDocument,
+		layers:                []*Layer{newLayer("Background", defaultDocument.NewCanvas())},
 		mousePositionOnCanvas: mouseIsOutsideCanvas,
+		view:                  newViewTransform(),
+		toolOptions:           newToolOptions(),
+		colorPicker:           newColorPicker(),
+		fonts:                 builtInFonts(),
+		loadedFonts:           make(chan []TextFont, 1),
+		importedPalettes:      make(chan Palette, 1),
+		clipboard:             newClipboard(),
+		window:                window,
 		expl:                  explorer.NewExplorer(window),
 	}
 
-	fillImageWithColor(state.canvas, defaultCanvasColor)
+	loadPalettes(&state)
+	loadKeymap(&state)
+
 	theme := material.NewTheme()
 
 	var ops op.Ops
@@ -130,6 +224,31 @@ func run(window *app.Window) error {
 		case app.FrameEvent:
 			gtx := app.NewContext(&ops, e)
 
+			pollJob(&state)
+
+			receiveLoadedFonts(&state)
+
+			receiveImportedPalette(&state)
+
+			handleKeys(gtx, &state)
+
+			handleClipboard(gtx, &state)
+
+			// Switching to another tool or layer applies the transform in progress. It is dropped if its layer was deleted.
+			if state.freeTransform != nil && state.job == nil {
+				switch {
+				case !slices.Contains(state.layers, state.freeTransform.layer):
+					cancelFreeTransform(&state)
+				case state.selectedTool != Transform || state.freeTransform.layer != activeLayer(&state):
+					applyFreeTransform(&state, resampleFilterByName(state.toolOptions.transformFilter.Value))
+				}
+			}
+
+			// Switching to another tool or layer finishes the text being edited.
+			if state.textTool.layer != nil && state.job == nil && (state.selectedTool != Text || state.textTool.layer != activeLayer(&state)) {
+				finishText(&state)
+			}
+
 			layout.Stack{Alignment: layout.NE}.Layout(gtx,
 				layout.Expanded(
 					func(gtx layout.Context) layout.Dimensions {
@@ -150,6 +269,14 @@ func run(window *app.Window) error {
 				layout.Stacked(
 					func(gtx layout.Context) layout.Dimensions {
 						return layout.UniformInset(32).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							if state.job != nil {
+								return layoutJobProgress(gtx, &state, theme)
+							}
+
+							if hint := toolHint(&state); hint != "" {
+								return material.Body1(theme, hint).Layout(gtx)
+							}
+
 							if debug {
 								return material.Body1(theme, fmt.Sprintf("🐭: %.2f, %.2f", state.mousePositionOnCanvas.X, state.mousePositionOnCanvas.Y)).Layout(gtx)
 							}
@@ -157,6 +284,53 @@ func run(window *app.Window) error {
 						})
 					},
 				),
+				layout.Expanded(
+					func(gtx layout.Context) layout.Dimensions {
+						return layout.N.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return layout.UniformInset(16).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								return layoutToolOptions(gtx, &state, theme)
+							})
+						})
+					},
+				),
+				layout.Expanded(
+					func(gtx layout.Context) layout.Dimensions {
+						return layout.S.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return layout.UniformInset(16).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								return layoutViewControls(gtx, &state, theme)
+							})
+						})
+					},
+				),
+				layout.Expanded(
+					func(gtx layout.Context) layout.Dimensions {
+						return layout.SE.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return layout.UniformInset(16).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								return layoutLayersPanel(gtx, &state, theme)
+							})
+						})
+					},
+				),
+				layout.Expanded(
+					func(gtx layout.Context) layout.Dimensions {
+						if !state.colorPicker.open {
+							return layout.Dimensions{}
+						}
+						return layout.NE.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return layout.Inset{Top: 64, Right: 16}.Layout(gtx, func(gtx layout.Context) layout.Dimensions { // Below the tool hint
+								return layoutColorPicker(gtx, &state, theme)
+							})
+						})
+					},
+				),
+				layout.Expanded(
+					func(gtx layout.Context) layout.Dimensions {
+						if state.dialog == nil {
+							return layout.Dimensions{}
+						}
+						return state.dialog.Layout(gtx, &state, theme)
+					},
+				),
 			)
 
 			e.Frame(gtx.Ops)
@@ -164,97 +338,208 @@ func run(window *app.Window) error {
 	}
 }
 
-func layoutSidebar(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
-
-	// Handle tool button clicks
-	if state.brushButton.Clicked(gtx) {
-		state.selectedTool = Brush
-		state.previousPaintPosition = mouseIsOutsideCanvas
-		if debug {
-			fmt.Println("Current tool: ", state.selectedTool)
+func handleKeys(gtx layout.Context, state *GemPaintState) {
+	filters := append([]event.Filter{key.Filter{Name: key.NameEscape}, key.Filter{Name: key.NameReturn}, key.Filter{Name: key.NameEnter}}, shortcutFilters(gtx, state)...)
+	for {
+		ev, ok := gtx.Event(filters...)
+		if !ok {
+			break
 		}
-	}
 
-	if state.eraserButton.Clicked(gtx) {
-		state.selectedTool = Eraser
-		state.previousPaintPosition = mouseIsOutsideCanvas
-		if debug {
-			fmt.Println("Current tool: ", state.selectedTool)
+		keyEvent, ok := ev.(key.Event)
+		if !ok || keyEvent.State != key.Press {
+			continue
 		}
-	}
 
-	if state.BucketButton.Clicked(gtx) {
-		state.selectedTool = Bucket
-		state.previousPaintPosition = mouseIsOutsideCanvas
-		if debug {
-			fmt.Println("Current tool: ", state.selectedTool)
-		}
-	}
+		switch keyEvent.Name {
+		case key.NameEscape: // Esc closes the open dialog, cancels the running job, cancels the crop or lasso, or deselects.
+			switch {
+			case state.dialog != nil:
+				closeDialog(state)
+			case state.job != nil:
+				cancelJob(state)
+			case state.freeTransform != nil:
+				cancelFreeTransform(state)
+			case state.floating != nil: // Throw the pasted content away
+				state.floating = nil
+			case len(state.selectionTool.lassoPoints) > 0:
+				state.selectionTool.lassoPoints = nil
+			case len(state.shapeTool.points) > 0:
+				state.shapeTool.points = nil
+			case len(state.penTool.path.Anchors) > 0:
+				cancelPen(state)
+			case state.textTool.layer != nil:
+				finishText(state)
+			case !state.crop.rect.Empty():
+				state.crop = CropTool{}
+			default:
+				state.selection = nil
+			}
 
-	if state.increaseButton.Clicked(gtx) {
-		if state.cursorRadius < maximumCursorRadius {
-			state.cursorRadius += cursorRadiusChangeStep
-		}
-		if debug {
-			fmt.Println("Cursor radius: ", state.cursorRadius)
+		case key.NameReturn, key.NameEnter:
+			if state.dialog == nil && state.job == nil && state.freeTransform != nil {
+				applyFreeTransform(state, resampleFilterByName(state.toolOptions.transformFilter.Value))
+				continue
+			}
+			if state.dialog == nil && state.job == nil && state.floating != nil {
+				commitFloating(state)
+				continue
+			}
+			if state.dialog == nil && state.job == nil && state.selectedTool == Crop {
+				applyCrop(state)
+			}
+			if state.dialog == nil && state.selectedTool == PolygonLasso && len(state.selectionTool.lassoPoints) > 0 {
+				closeLasso(state)
+			}
+			if state.dialog == nil && state.job == nil && state.selectedTool == PolygonShape && len(state.shapeTool.points) > 0 {
+				closePolygonShape(state)
+			}
+			if state.dialog == nil && state.job == nil && state.selectedTool == Pen && len(state.penTool.path.Anchors) > 0 {
+				paintPenPath(state)
+			}
+			if state.dialog == nil && state.job == nil && state.selectedTool == Text && state.textTool.layer != nil { // The text itself takes Enter while it is typed in
+				finishText(state)
+			}
+
+		default:
+			if action, ok := shortcutAction(state.keymap, keyEvent); ok {
+				runAction(gtx, state, action)
+			}
 		}
 	}
+}
 
-	if state.decreaseButton.Clicked(gtx) {
-		if state.cursorRadius > minimumCursorRadius {
-			state.cursorRadius -= cursorRadiusChangeStep
-		}
-		if debug {
-			fmt.Println("Cursor radius: ", state.cursorRadius)
-		}
+// isTyping reports whether the keyboard goes to a text field, so that the keys typed in it aren't taken as
+// shortcuts as well.
+func isTyping(gtx layout.Context, state *GemPaintState) bool {
+	if state.dialog != nil {
+		return true
 	}
 
-	if state.clearButton.Clicked(gtx) {
-		state.canvas = image.NewRGBA(defaultCanvasDimensions)
-		fillImageWithColor(state.canvas, defaultCanvasColor)
-		if debug {
-			fmt.Println("Canvas cleared")
+	options := &state.toolOptions
+	editors := []*widget.Editor{&options.textEditor, &options.textSizeEditor, &options.textLineSpacingEditor, &state.colorPicker.hexEditor}
+	for i := range options.transformEditors {
+		editors = append(editors, &options.transformEditors[i])
+	}
+	for _, editor := range editors {
+		if gtx.Focused(editor) {
+			return true
 		}
 	}
+	return false
+}
 
-	if state.saveButton.Clicked(gtx) {
-		go func() { // Do not block the ui thread
-
-			if state.canvas == nil {
-				if debug {
-					fmt.Println("Error: No image to save")
-				}
-				return
-			}
-
-			extension := "png"
-			fileName := "gem." + extension
-
-			// Depending on the platform, how we access the file system will differ
-			platform := runtime.GOOS
-			if debug {
-				fmt.Println("Saving on GOOS: ", platform)
-			}
+// toolHint explains how to finish what the current tool is doing, if anything.
+func toolHint(state *GemPaintState) string {
+	if state.freeTransform != nil {
+		return "Drag inside to move, handles to scale, outside to rotate, Ctrl+edge to skew, Shift to constrain, Enter to apply"
+	}
+	if state.floating != nil {
+		return "Drag to move, Enter to paste, Esc to cancel"
+	}
+	if state.selectedTool == Crop && !state.crop.rect.Empty() {
+		return "Enter to crop, Esc to cancel"
+	}
+	if state.selectedTool == PolygonLasso && len(state.selectionTool.lassoPoints) > 0 {
+		return "Click the first point, double-click or Enter to close, Esc to cancel"
+	}
+	if state.selectedTool == PolygonShape && len(state.shapeTool.points) > 0 {
+		return "Click the first point, double-click or Enter to close, Shift for 45°, Esc to cancel"
+	}
+	if state.selectedTool == Pen && len(state.penTool.path.Anchors) > 0 {
+		return "Click to add a corner, drag to curve, click the first anchor to close, Alt-drag a handle to break it, Enter to paint, Esc to cancel"
+	}
+	if state.selectedTool == Text && state.textTool.layer != nil {
+		return "Type in the tool options, drag the text to move it, Esc to finish"
+	}
+	isPixelTool := state.selectedTool == Brush || state.selectedTool == Eraser || state.selectedTool == Bucket
+	if isPixelTool && activeLayer(state).Kind == VectorLayer {
+		return "This is a vector layer, rasterize it in the layers panel to paint on it"
+	}
+	if isPixelTool && activeLayer(state).Kind == TextLayer {
+		return "This is a text layer, edit it with the text tool, or rasterize it in the layers panel to paint on it"
+	}
+	switch state.selectedTool {
+	case RectangleSelect, EllipseSelect, Lasso, PolygonLasso, MagicWand:
+		return "Shift to add, Alt to subtract, Shift+Alt to intersect, Esc to deselect"
+	case Brush, Eraser:
+		return "Right-click to paint with the secondary color, Shift-click to draw a straight line from the last stroke, Shift-drag to lock to 45°, Alt-click to pick a color"
+	case Line, PolygonShape:
+		return "Shift to snap to 45°"
+	case RectangleShape:
+		return "Shift to draw a square"
+	case EllipseShape:
+		return "Shift to draw a circle"
+	case Pen:
+		return "Click to start a path, or click an anchor of a vector shape to edit it"
+	case Text:
+		return "Click to place text, or click a text to edit it"
+	case Eyedropper:
+		return "Click or drag to pick a color"
+	}
+	return ""
+}
 
-			saveOnPlatform(state, fileName)
+func layoutSidebar(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
 
-		}()
+	// Handle sidebar button clicks. The buttons run the same actions as the keyboard shortcuts.
+	buttonActions := map[*widget.Clickable]string{
+		&state.brushButton:           "brush",
+		&state.eraserButton:          "eraser",
+		&state.BucketButton:          "bucket",
+		&state.eyedropperButton:      "eyedropper",
+		&state.cropButton:            "crop",
+		&state.rectangleSelectButton: "rectangle-select",
+		&state.ellipseSelectButton:   "ellipse-select",
+		&state.lassoButton:           "lasso",
+		&state.polygonLassoButton:    "polygon-lasso",
+		&state.magicWandButton:       "magic-wand",
+		&state.transformButton:       "transform",
+		&state.lineButton:            "line",
+		&state.rectangleShapeButton:  "rectangle-shape",
+		&state.ellipseShapeButton:    "ellipse-shape",
+		&state.polygonShapeButton:    "polygon-shape",
+		&state.penButton:             "pen",
+		&state.textButton:            "text",
+		&state.colorPickerButton:     "color-picker",
+		&state.palettesButton:        "palettes",
+		&state.increaseButton:        "increase-radius",
+		&state.decreaseButton:        "decrease-radius",
+		&state.undoButton:            "undo",
+		&state.redoButton:            "redo",
+		&state.newButton:             "new",
+		&state.imageSizeButton:       "image-size",
+		&state.canvasSizeButton:      "canvas-size",
+		&state.flipButton:            "flip",
+		&state.rotateButton:          "rotate",
+		&state.cropToSelectionButton: "crop-to-selection",
+		&state.cutButton:             "cut",
+		&state.copyButton:            "copy",
+		&state.pasteButton:           "paste",
+		&state.selectButton:          "select",
+		&state.clearButton:           "clear",
+		&state.saveButton:            "save",
+		&state.exportSVGButton:       "export-svg",
+	}
+	for button, name := range buttonActions {
+		if button.Clicked(gtx) {
+			runAction(gtx, state, name)
+		}
 	}
 
 	// Handle color button clicks
-	for i := range state.colorButtons {
-		btn := &state.colorButtons[i]
-		wasClicked := btn.Clickable.Clicked(gtx)
-
-		// Dynamically set isSelected based on selectedColorIndex
-		btn.isSelected = (i == state.selectedColorIndex)
-
-		if wasClicked {
-			state.selectedColorIndex = i
-
-			if debug {
-				fmt.Println("Selected color: ", btn.Label)
+	syncColorButtons(state)
+	for _, buttons := range [][]ColorButtonStyle{state.colorButtons, state.recentColorButtons} {
+		for i := range buttons {
+			btn := &buttons[i]
+			wasClicked := btn.Clickable.Clicked(gtx)
+
+			if wasClicked && btn.OnClick != nil {
+				btn.OnClick()
 			}
+
+			// Dynamically set isSelected based on the paint color, which may have been picked off the canvas instead
+			btn.isSelected = btn.Color == state.paintColor
 		}
 	}
 
@@ -272,6 +557,62 @@ func layoutSidebar(gtx layout.Context, state *GemPaintState, theme *material.The
 			return ToolButton(theme, &state.BucketButton, BucketIcon, state.selectedTool == Bucket, golangBlue, lightGray, "Bucket").Layout(gtx)
 		},
 		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.eyedropperButton, EyedropperIcon, state.selectedTool == Eyedropper, golangBlue, lightGray, "Eyedropper").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.cropButton, CropIcon, state.selectedTool == Crop, golangBlue, lightGray, "Crop").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.rectangleSelectButton, RectangleSelectIcon, state.selectedTool == RectangleSelect, golangBlue, lightGray, "Rectangle Select").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.ellipseSelectButton, EllipseSelectIcon, state.selectedTool == EllipseSelect, golangBlue, lightGray, "Ellipse Select").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.lassoButton, LassoIcon, state.selectedTool == Lasso, golangBlue, lightGray, "Lasso").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.polygonLassoButton, PolygonLassoIcon, state.selectedTool == PolygonLasso, golangBlue, lightGray, "Polygonal Lasso").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.magicWandButton, MagicWandIcon, state.selectedTool == MagicWand, golangBlue, lightGray, "Magic Wand").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.transformButton, TransformIcon, state.selectedTool == Transform, golangBlue, lightGray, "Transform").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.lineButton, LineIcon, state.selectedTool == Line, golangBlue, lightGray, "Line").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.rectangleShapeButton, RectangleShapeIcon, state.selectedTool == RectangleShape, golangBlue, lightGray, "Rectangle").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.ellipseShapeButton, EllipseShapeIcon, state.selectedTool == EllipseShape, golangBlue, lightGray, "Ellipse").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.polygonShapeButton, PolygonShapeIcon, state.selectedTool == PolygonShape, golangBlue, lightGray, "Polygon").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.penButton, PenIcon, state.selectedTool == Pen, golangBlue, lightGray, "Pen").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.textButton, TextIcon, state.selectedTool == Text, golangBlue, lightGray, "Text").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
 		func(gtx layout.Context) layout.Dimensions {
 			return ToolButton(theme, &state.increaseButton, AddIcon, false, golangBlue, lightGray, "Increase").Layout(gtx)
 		},
@@ -280,6 +621,18 @@ func layoutSidebar(gtx layout.Context, state *GemPaintState, theme *material.The
 			return ToolButton(theme, &state.decreaseButton, MinusIcon, false, golangBlue, lightGray, "Decrease").Layout(gtx)
 		},
 		layout.Spacer{Height: unit.Dp(16)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return layoutColorPair(gtx, state)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.colorPickerButton, ColorPickerIcon, state.colorPicker.open, golangBlue, lightGray, "Color Picker").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.palettesButton, PalettesIcon, false, golangBlue, lightGray, "Palettes").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
 	}
 
 	// Color buttons
@@ -294,9 +647,35 @@ func layoutSidebar(gtx layout.Context, state *GemPaintState, theme *material.The
 		)
 	}
 
+	// Recent colors, two small buttons to a row
+	for i := 0; i < len(state.recentColorButtons); i += 2 {
+		row := state.recentColorButtons[i:min(i+2, len(state.recentColorButtons))]
+		children = append(children,
+			func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return row[0].Layout(gtx, theme)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if len(row) < 2 {
+							return layout.Dimensions{}
+						}
+						return row[1].Layout(gtx, theme)
+					}),
+				)
+			},
+			layout.Spacer{Height: unit.Dp(8)}.Layout,
+		)
+	}
+
 	// Other buttons
 	children = append(children,
 		layout.Spacer{Height: unit.Dp(16)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.newButton, NewIcon, false, golangBlue, lightGray, "New").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
 		func(gtx layout.Context) layout.Dimensions {
 			return ToolButton(theme, &state.clearButton, ClearIcon, false, golangBlue, lightGray, "Clear").Layout(gtx)
 		},
@@ -304,6 +683,54 @@ func layoutSidebar(gtx layout.Context, state *GemPaintState, theme *material.The
 		func(gtx layout.Context) layout.Dimensions {
 			return ToolButton(theme, &state.saveButton, SaveIcon, false, golangBlue, lightGray, "Save").Layout(gtx)
 		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.exportSVGButton, ExportSVGIcon, false, golangBlue, lightGray, "Export SVG").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(16)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.undoButton, UndoIcon, false, golangBlue, lightGray, "Undo").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.redoButton, RedoIcon, false, golangBlue, lightGray, "Redo").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(16)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.cutButton, CutIcon, false, golangBlue, lightGray, "Cut").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.copyButton, CopyIcon, false, golangBlue, lightGray, "Copy").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.pasteButton, PasteIcon, false, golangBlue, lightGray, "Paste").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(16)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.imageSizeButton, ImageSizeIcon, false, golangBlue, lightGray, "Image Size").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.canvasSizeButton, CanvasSizeIcon, false, golangBlue, lightGray, "Canvas Size").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.flipButton, FlipIcon, false, golangBlue, lightGray, "Flip Horizontal").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.rotateButton, RotateIcon, false, golangBlue, lightGray, "Rotate & Flip").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.cropToSelectionButton, CropToSelectionIcon, false, golangBlue, lightGray, "Crop to Selection").Layout(gtx)
+		},
+		layout.Spacer{Height: unit.Dp(8)}.Layout,
+		func(gtx layout.Context) layout.Dimensions {
+			return ToolButton(theme, &state.selectButton, SelectIcon, false, golangBlue, lightGray, "Select").Layout(gtx)
+		},
 	)
 
 	return layout.Background{}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
@@ -339,7 +766,7 @@ func layoutCanvas(gtx layout.Context, state *GemPaintState) layout.Dimensions {
 				ev, ok := gtx.Event(
 					pointer.Filter{
 						Target: state.canvasInputTag,
-						Kinds:  pointer.Press | pointer.Drag | pointer.Move | pointer.Leave | pointer.Enter,
+						Kinds:  pointer.Press | pointer.Drag | pointer.Release | pointer.Move | pointer.Leave | pointer.Enter,
 					},
 				)
 
@@ -362,7 +789,16 @@ func layoutCanvas(gtx layout.Context, state *GemPaintState) layout.Dimensions {
 
 				case pointer.Press, pointer.Drag:
 					state.mousePositionOnCanvas = pointerEvent.Position
-					handlePaint(state, pointerEvent)
+
+					// The view may be mirrored or rotated, so find which canvas pixel is under the pointer.
+					canvasEvent := pointerEvent
+					canvasEvent.Position = state.view.toCanvas(pointerEvent.Position, state.document.Bounds().Size())
+					handlePaint(state, canvasEvent)
+
+				case pointer.Release:
+					canvasEvent := pointerEvent
+					canvasEvent.Position = state.view.toCanvas(pointerEvent.Position, state.document.Bounds().Size())
+					handleRelease(state, canvasEvent)
 
 				case pointer.Move:
 					state.mousePositionOnCanvas = pointerEvent.Position
@@ -376,16 +812,34 @@ func layoutCanvas(gtx layout.Context, state *GemPaintState) layout.Dimensions {
 				// fmt.Printf("Pointer Event: %+v\n", ev)
 			}
 
-			// Draw the canvas
-			op := paint.NewImageOp(state.canvas)
+			viewTransform := op.Affine(state.view.affine(state.document.Bounds().Size())).Push(gtx.Ops)
 
-			return widget.Image{
-				Src:   op,
+			// Draw a checkerboard under the canvas so that transparent pixels can be seen
+			widget.Image{
+				Src:   state.checkerboard.imageOp(state.document.Bounds().Size()),
 				Fit:   widget.Unscaled,
 				Scale: 1.0 / gtx.Metric.PxPerDp,
 			}.Layout(gtx)
+
+			// Draw the canvas
+			dimensions := drawLayers(gtx, displayLayers(state), state.document.Bounds())
+
+			viewTransform.Pop()
+			return dimensions
 		}),
 		layout.Expanded(func(gtx layout.Context) layout.Dimensions {
+			viewTransform := op.Affine(state.view.affine(state.document.Bounds().Size())).Push(gtx.Ops)
+			drawSelectionOverlay(gtx, state)
+			drawFloatingOverlay(gtx, state)
+			drawFreeTransformOverlay(gtx, state)
+			drawShapeOverlay(gtx, state)
+			drawPenOverlay(gtx, state)
+			drawTextOverlay(gtx, state)
+			if state.selectedTool == Crop {
+				drawCropOverlay(gtx, state)
+			}
+			viewTransform.Pop()
+
 			doDrawCursor := state.mousePositionOnCanvas != mouseIsOutsideCanvas
 			if !doDrawCursor {
 				return layout.Dimensions{Size: gtx.Constraints.Min}
@@ -395,7 +849,7 @@ func layoutCanvas(gtx layout.Context, state *GemPaintState) layout.Dimensions {
 
 			switch state.selectedTool {
 			case Brush:
-				cursorColor = state.colorButtons[state.selectedColorIndex].Color
+				cursorColor = state.paintColor
 				drawCircle(gtx, state.mousePositionOnCanvas.X, state.mousePositionOnCanvas.Y, float32(state.cursorRadius), cursorColor)
 
 			case Eraser:
@@ -404,8 +858,11 @@ func layoutCanvas(gtx layout.Context, state *GemPaintState) layout.Dimensions {
 				drawCircle(gtx, state.mousePositionOnCanvas.X, state.mousePositionOnCanvas.Y, float32(state.cursorRadius-1), cursorColor)
 
 			case Bucket:
-				cursorColor = state.colorButtons[state.selectedColorIndex].Color
+				cursorColor = state.paintColor
 				drawCircle(gtx, state.mousePositionOnCanvas.X, state.mousePositionOnCanvas.Y, 5, cursorColor)
+
+			case Eyedropper, Crop, RectangleSelect, EllipseSelect, Lasso, PolygonLasso, MagicWand, Transform, Line, RectangleShape, EllipseShape, PolygonShape, Pen, Text:
+				drawCircle(gtx, state.mousePositionOnCanvas.X, state.mousePositionOnCanvas.Y, 3, darkGray)
 			default:
 				if debug {
 					fmt.Println("Error: Using unknown tool")
@@ -423,31 +880,84 @@ func handlePaint(state *GemPaintState, p pointer.Event) {
 		return
 	}
 
+	if state.job != nil { // The canvas is being worked on in the background
+		return
+	}
+
+	if state.ignoreDragUntilRelease {
+		return
+	}
+
+	// The right button paints with the secondary color. The tools that don't paint ignore it.
+	if p.Kind == pointer.Press {
+		state.paintingWithSecondary = p.Buttons.Contain(pointer.ButtonSecondary)
+	}
+	if ignoresSecondaryButton(state) {
+		return
+	}
+
+	if state.selectedTool == Transform { // Transforming pasted content takes it over from the floating selection
+		handleFreeTransformPointer(state, p)
+		return
+	}
+
+	if state.floating != nil { // Pasted content takes the pointer until it is committed
+		handleFloatingPointer(state, p)
+		return
+	}
+
+	// Alt-clicking with the brush picks colors until the pointer is released.
+	if state.selectedTool == Brush && (state.pickingColor || (p.Kind == pointer.Press && p.Modifiers.Contain(key.ModAlt))) {
+		state.pickingColor = true
+		pickColor(state, p.Position)
+		return
+	}
+
+	isPixelTool := state.selectedTool == Brush || state.selectedTool == Eraser || state.selectedTool == Bucket
+	if isPixelTool && activeLayer(state).Kind != RasterLayer { // It only holds shapes or text
+		return
+	}
+
 	switch state.selectedTool {
 	case Brush:
-		color := state.colorButtons[state.selectedColorIndex].Color
+		if p.Kind == pointer.Press { // Each stroke is one undo step
+			state.history.Record(state)
+			startStroke(state)
+			rememberColor(state, strokeColor(state))
+		}
+		p = constrainStroke(state, p)
+
+		color := strokeColor(state)
 		positionOnCanvas := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
-		paintCircle(state.canvas, positionOnCanvas, state.cursorRadius, color)
+		paintCircle(activeLayer(state).Image, positionOnCanvas, state.cursorRadius, color)
 
 		// Due to the way the ui frameworks returns pointer drag events, if the user drags the mouse too quickly, some pixels will be skipped.
 		// To fix this, we need to fill in pixels between the previous and current mouse positions, that is, use interpolation.
 		previousPaintPositionIsOutsideCanvas := state.previousPaintPosition == mouseIsOutsideCanvas
-		if !previousPaintPositionIsOutsideCanvas && p.Kind == pointer.Drag {
-			interpolatePaintBetweenPoints(state.previousPaintPosition, p.Position, state.canvas, state.cursorRadius, color)
+		if !previousPaintPositionIsOutsideCanvas && (p.Kind == pointer.Drag || continuesLastStroke(state, p)) {
+			interpolatePaintBetweenPoints(state.previousPaintPosition, p.Position, activeLayer(state).Image, state.cursorRadius, color)
 		}
+		limitStrokeToSelection(state, p)
 
 		// Update at the end of the paint operation
 		state.previousPaintPosition = p.Position
 
 	case Eraser:
-		color := defaultCanvasColor
+		if p.Kind == pointer.Press {
+			state.history.Record(state)
+			startStroke(state)
+		}
+		p = constrainStroke(state, p)
+
+		color := transparent // The eraser clears pixels back to transparent
 		positionOnCanvas := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
-		paintCircle(state.canvas, positionOnCanvas, state.cursorRadius, color)
+		paintCircle(activeLayer(state).Image, positionOnCanvas, state.cursorRadius, color)
 
 		previousPaintPositionIsOutsideCanvas := state.previousPaintPosition == mouseIsOutsideCanvas
-		if !previousPaintPositionIsOutsideCanvas && p.Kind == pointer.Drag {
-			interpolatePaintBetweenPoints(state.previousPaintPosition, p.Position, state.canvas, state.cursorRadius, color)
+		if !previousPaintPositionIsOutsideCanvas && (p.Kind == pointer.Drag || continuesLastStroke(state, p)) {
+			interpolatePaintBetweenPoints(state.previousPaintPosition, p.Position, activeLayer(state).Image, state.cursorRadius, color)
 		}
+		limitStrokeToSelection(state, p)
 
 		state.previousPaintPosition = p.Position
 
@@ -457,13 +967,51 @@ func handlePaint(state *GemPaintState, p pointer.Event) {
 		}
 
 		positionOnCanvas := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
-		newColor := state.colorButtons[state.selectedColorIndex].Color
+		newColor := strokeColor(state)
+		rememberColor(state, newColor)
+
+		// Find all pixels that need to be replaced with the new color that are connected to the clicked pixel, or
+		// anywhere on the layer when shift is held. This can take a while on big canvases, so the fill runs as a
+		// job on a copy of the canvas.
+		global := p.Modifiers.Contain(key.ModShift)
+		original, selection := activeLayer(state).Image, state.selection
+		snapshot := original.Clone()
+		startJob(state, "Filling", func(ctx context.Context, reportProgress func(done float32)) (func(state *GemPaintState), error) {
+			var err error
+			if global {
+				err = replaceColor(ctx, snapshot, positionOnCanvas, newColor, reportProgress)
+			} else {
+				err = floodFill(ctx, snapshot, positionOnCanvas, newColor, reportProgress)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if selection != nil {
+				maskTileChanges(snapshot, original, selection, snapshot.Rect)
+			}
 
-		// Find all pixels that need to be replaced with the new color that are connected to the clicked pixel
-		err := floodFill(state.canvas, positionOnCanvas, newColor)
-		if err != nil && debug {
-			fmt.Println(err)
-		}
+			return func(state *GemPaintState) {
+				commitLayerChange(state, snapshot)
+			}, nil
+		})
+
+	case Eyedropper:
+		pickColor(state, p.Position)
+
+	case Crop:
+		handleCropPointer(state, p)
+
+	case RectangleSelect, EllipseSelect, Lasso, PolygonLasso, MagicWand:
+		handleSelectionPointer(state, p)
+
+	case Line, RectangleShape, EllipseShape, PolygonShape:
+		handleShapePointer(state, p)
+
+	case Pen:
+		handlePenPointer(state, p)
+
+	case Text:
+		handleTextPointer(state, p)
 
 	default:
 		if debug {
@@ -474,8 +1022,44 @@ func handlePaint(state *GemPaintState, p pointer.Event) {
 
 }
 
-func floodFill(canvas *image.RGBA, start image.Point, newColor color.Color) error {
-	if !start.In(canvas.Rect) {
+// handleRelease finishes what the current tool started when the pointer is released.
+func handleRelease(state *GemPaintState, p pointer.Event) {
+	state.strokeBase = nil
+	state.ignoreDragUntilRelease = false
+	state.pickingColor = false
+
+	if ignoresSecondaryButton(state) {
+		return
+	}
+
+	if state.selectedTool == Transform {
+		handleFreeTransformPointer(state, p)
+		return
+	}
+
+	if state.floating != nil {
+		handleFloatingPointer(state, p)
+		return
+	}
+
+	switch state.selectedTool {
+	case RectangleSelect, EllipseSelect, Lasso, PolygonLasso, MagicWand:
+		handleSelectionPointer(state, p)
+	case Line, RectangleShape, EllipseShape, PolygonShape:
+		handleShapePointer(state, p)
+	case Pen:
+		handlePenPointer(state, p)
+	case Text:
+		handleTextPointer(state, p)
+	}
+}
+
+// floodFillProgressInterval is how many pixels are filled between progress reports and cancellation checks.
+const floodFillProgressInterval = 1 << 16
+
+// floodFill stops early with ctx.Err() when ctx is cancelled. reportProgress may be nil.
+func floodFill(ctx context.Context, canvas draw.Image, start image.Point, newColor color.Color, reportProgress func(done float32)) error {
+	if !start.In(canvas.Bounds()) {
 		return fmt.Errorf("start point is outside canvas") // Nothing to be done!
 	}
 
@@ -485,35 +1069,136 @@ func floodFill(canvas *image.RGBA, start image.Point, newColor color.Color) erro
 		return fmt.Errorf("old color is the same as new fill color")
 	}
 
+	matches := func(x, y int) bool { return colorsAreEqual(canvas.At(x, y), oldColor) }
+	fill := func(x, y int) { canvas.Set(x, y, newColor) }
+	return floodRegion(ctx, canvas.Bounds(), start, matches, fill, reportProgress)
+}
+
+// floodRegion calls visit once for every pixel that matches and is connected to start through matching
+// pixels. It is the region logic shared by the bucket fill and the magic wand.
+func floodRegion(ctx context.Context, bounds image.Rectangle, start image.Point, matches func(x, y int) bool, visit func(x, y int), reportProgress func(done float32)) error {
+	visited := make([]bool, bounds.Dx()*bounds.Dy())
+	isVisited := func(p image.Point) *bool {
+		return &visited[(p.Y-bounds.Min.Y)*bounds.Dx()+p.X-bounds.Min.X]
+	}
+
 	queue := []image.Point{start}
+	*isVisited(start) = true
+	filledPixels := 0
+	totalPixels := len(visited)
 
 	for len(queue) > 0 {
 		// Dequeue a point
 		currentPixel := queue[0]
 		queue = queue[1:]
 
-		if !currentPixel.In(canvas.Rect) {
+		if !matches(currentPixel.X, currentPixel.Y) {
 			continue
 		}
 
-		currentPixelColor := canvas.At(currentPixel.X, currentPixel.Y)
-		if !colorsAreEqual(currentPixelColor, oldColor) {
-			continue
-		}
+		visit(currentPixel.X, currentPixel.Y)
 
-		canvas.Set(currentPixel.X, currentPixel.Y, newColor)
+		filledPixels++
+		if filledPixels%floodFillProgressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if reportProgress != nil {
+				reportProgress(float32(filledPixels) / float32(totalPixels))
+			}
+		}
 
 		// Add the neighboring pixels to the queue
-		queue = append(queue, image.Point{X: currentPixel.X + 1, Y: currentPixel.Y})
-		queue = append(queue, image.Point{X: currentPixel.X - 1, Y: currentPixel.Y})
-		queue = append(queue, image.Point{X: currentPixel.X, Y: currentPixel.Y + 1})
-		queue = append(queue, image.Point{X: currentPixel.X, Y: currentPixel.Y - 1})
+		neighbors := []image.Point{
+			{X: currentPixel.X + 1, Y: currentPixel.Y},
+			{X: currentPixel.X - 1, Y: currentPixel.Y},
+			{X: currentPixel.X, Y: currentPixel.Y + 1},
+			{X: currentPixel.X, Y: currentPixel.Y - 1},
+		}
+		for _, neighbor := range neighbors {
+			if !neighbor.In(bounds) || *isVisited(neighbor) {
+				continue
+			}
+			*isVisited(neighbor) = true
+			queue = append(queue, neighbor)
+		}
 	}
 
 	return nil
 }
 
-func interpolatePaintBetweenPoints(start, end f32.Point, canvas *image.RGBA, radius int, color color.Color) {
+// replaceColor is the global (non-contiguous) counterpart of floodFill: every pixel with the color at start is set
+// to newColor, whether it is connected to start or not. It stops early with ctx.Err() when ctx is cancelled.
+// reportProgress may be nil.
+func replaceColor(ctx context.Context, canvas *TiledCanvas, start image.Point, newColor color.Color, reportProgress func(done float32)) error {
+	if !start.In(canvas.Rect) {
+		return fmt.Errorf("start point is outside canvas") // Nothing to be done!
+	}
+
+	old := rgbaPixel(canvas.RGBAAt(start.X, start.Y))
+	replacement := rgbaPixel(newColor)
+	if old == replacement {
+		return fmt.Errorf("old color is the same as new fill color")
+	}
+
+	// The tiles that were never painted are all the solid color, so they are filled by changing it.
+	if rgbaPixel(canvas.Solid) == old {
+		canvas.Solid = color.RGBA{R: replacement[0], G: replacement[1], B: replacement[2], A: replacement[3]}
+	}
+
+	// The tiles are made writable up front, so that the workers only touch the pixels of their own band.
+	for i, tile := range canvas.tiles {
+		if tile != nil {
+			canvas.writableTile(i)
+		}
+	}
+
+	parallelBandsWithProgress(canvas.Rect, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			for _, i := range canvas.tilesIn(image.Rect(band.Min.X, y, band.Max.X, y+1)) {
+				tile := canvas.tiles[i]
+				if tile == nil {
+					continue
+				}
+
+				row := tile.Pix[tile.PixOffset(tile.Rect.Min.X, y) : tile.PixOffset(tile.Rect.Max.X-1, y)+4]
+				for x := 0; x < len(row); x += 4 {
+					if [4]uint8(row[x:x+4]) == old {
+						copy(row[x:x+4], replacement[:])
+					}
+				}
+			}
+		}
+	}, reportProgress)
+
+	return ctx.Err()
+}
+
+// continuesLastStroke reports whether p is a shift-click, which paints a straight line from where the last
+// stroke ended to the click.
+func continuesLastStroke(state *GemPaintState, p pointer.Event) bool {
+	return p.Kind == pointer.Press && p.Modifiers.Contain(key.ModShift) && state.previousPaintPosition != mouseIsOutsideCanvas
+}
+
+// constrainStroke locks a stroke dragged with Shift held to horizontal, vertical or 45° from where it was pressed.
+func constrainStroke(state *GemPaintState, p pointer.Event) pointer.Event {
+	if p.Kind == pointer.Press {
+		state.strokeStart = p.Position
+		return p
+	}
+	if p.Kind == pointer.Drag && p.Modifiers.Contain(key.ModShift) {
+		start := vec2{X: float64(state.strokeStart.X), Y: float64(state.strokeStart.Y)}
+		snapped := snapTo45Degrees(start, vec2{X: float64(p.Position.X), Y: float64(p.Position.Y)})
+		p.Position = f32.Pt(float32(snapped.X), float32(snapped.Y))
+	}
+	return p
+}
+
+func interpolatePaintBetweenPoints(start, end f32.Point, canvas draw.Image, radius int, color color.Color) {
 	dx := end.X - start.X
 	dy := end.Y - start.Y
 	distance := float32(math.Sqrt(float64(dx*dx + dy*dy)))
@@ -531,7 +1216,20 @@ func interpolatePaintBetweenPoints(start, end f32.Point, canvas *image.RGBA, rad
 	}
 }
 
-func paintCircle(canvas *image.RGBA, position image.Point, radius int, color color.Color) {
+func paintCircle(canvas draw.Image, position image.Point, radius int, color color.Color) {
+	switch canvas := canvas.(type) {
+	case *image.RGBA:
+		paintCircleSpans(canvas, position, radius, color)
+		return
+	case *TiledCanvas:
+		paintCircleTiles(canvas, position, radius, color)
+		return
+	}
+
+	paintCirclePixelByPixel(canvas, position, radius, color)
+}
+
+func paintCirclePixelByPixel(canvas draw.Image, position image.Point, radius int, color color.Color) {
 	rSquared := radius * radius
 	for x := position.X - radius; x <= position.X+radius; x++ { // Loop through the bounding box of the circle, ie, the square
 		for y := position.Y - radius; y <= position.Y+radius; y++ {
@@ -557,11 +1255,15 @@ func fillImageWithColor(img *image.RGBA, col color.Color) {
 		return
 	}
 
-	for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
-		for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
-			img.Set(x, y, col)
+	pixel := rgbaPixel(col)
+
+	parallelBands(img.Rect, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			start := img.PixOffset(band.Min.X, y)
+			end := img.PixOffset(band.Max.X-1, y) + 4
+			fillSpan(img.Pix[start:end], pixel)
 		}
-	}
+	})
 }
 
 func drawCircle(gtx layout.Context, x, y, radius float32, fillcolor color.NRGBA) {
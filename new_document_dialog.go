@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+type DocumentPreset struct {
+	Label  string
+	Width  int
+	Height int
+	DPI    int
+}
+
+var documentPresets = []DocumentPreset{
+	{Label: "HD", Width: 1920, Height: 1080, DPI: 72},
+	{Label: "4K", Width: 3840, Height: 2160, DPI: 72},
+	{Label: "A4 @ 300 DPI", Width: 2480, Height: 3508, DPI: 300},
+	{Label: "Square Social", Width: 1080, Height: 1080, DPI: 72},
+	{Label: "Icon 512", Width: 512, Height: 512, DPI: 72},
+	{Label: "Icon 256", Width: 256, Height: 256, DPI: 72},
+	{Label: "Icon 64", Width: 64, Height: 64, DPI: 72},
+}
+
+const (
+	whiteBackground       = "White"
+	customBackground      = "Custom"
+	transparentBackground = "Transparent"
+)
+
+type NewDocumentDialog struct {
+	widthEditor  widget.Editor
+	heightEditor widget.Editor
+	dpiEditor    widget.Editor
+	colorEditor  widget.Editor
+
+	presetButtons []widget.Clickable
+	background    widget.Enum
+
+	createButton widget.Clickable
+	cancelButton widget.Clickable
+
+	errorMessage string
+}
+
+// newNewDocumentDialog creates the dialog, filled in with the settings of the current document.
+func newNewDocumentDialog(document Document) *NewDocumentDialog {
+	d := &NewDocumentDialog{presetButtons: make([]widget.Clickable, len(documentPresets))}
+
+	for _, editor := range []*widget.Editor{&d.widthEditor, &d.heightEditor, &d.dpiEditor} {
+		editor.SingleLine = true
+		editor.Filter = "0123456789"
+	}
+	d.colorEditor.SingleLine = true
+
+	d.widthEditor.SetText(strconv.Itoa(document.Width))
+	d.heightEditor.SetText(strconv.Itoa(document.Height))
+	d.dpiEditor.SetText(strconv.Itoa(document.DPI))
+
+	switch {
+	case document.Background.A == 0:
+		d.background.Value = transparentBackground
+		d.colorEditor.SetText(formatHexColor(defaultCanvasColor))
+	case document.Background == defaultCanvasColor:
+		d.background.Value = whiteBackground
+		d.colorEditor.SetText(formatHexColor(defaultCanvasColor))
+	default:
+		d.background.Value = customBackground
+		d.colorEditor.SetText(formatHexColor(document.Background))
+	}
+
+	return d
+}
+
+func (d *NewDocumentDialog) Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	for i := range d.presetButtons {
+		if d.presetButtons[i].Clicked(gtx) {
+			preset := documentPresets[i]
+			d.widthEditor.SetText(strconv.Itoa(preset.Width))
+			d.heightEditor.SetText(strconv.Itoa(preset.Height))
+			d.dpiEditor.SetText(strconv.Itoa(preset.DPI))
+		}
+	}
+
+	if d.cancelButton.Clicked(gtx) {
+		closeDialog(state)
+	}
+
+	if d.createButton.Clicked(gtx) {
+		document, err := d.document()
+		if err != nil {
+			d.errorMessage = err.Error()
+		} else {
+			newDocument(state, document)
+			closeDialog(state)
+		}
+	}
+
+	return layoutDialog(gtx, state, theme, "New Document", func(gtx layout.Context) layout.Dimensions {
+		presets := make([]layout.FlexChild, 0, len(documentPresets)*2)
+		for i := range documentPresets {
+			preset := documentPresets[i]
+			button := material.Button(theme, &d.presetButtons[i], preset.Label)
+			button.Background = golangBlue
+			presets = append(presets,
+				layout.Rigid(button.Layout),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+			)
+		}
+
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, presets...)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Width (px)", &d.widthEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Height (px)", &d.heightEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "DPI", &d.dpiEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(material.Body1(theme, "Background").Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(material.RadioButton(theme, &d.background, whiteBackground, "White").Layout),
+					layout.Rigid(material.RadioButton(theme, &d.background, transparentBackground, "Transparent").Layout),
+					layout.Rigid(material.RadioButton(theme, &d.background, customBackground, "Custom").Layout),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layoutLabeledEditor(gtx, theme, "Hex color", &d.colorEditor)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogError(gtx, theme, d.errorMessage)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				create := material.Button(theme, &d.createButton, "Create")
+				create.Background = golangBlue
+				cancel := material.Button(theme, &d.cancelButton, "Cancel")
+				cancel.Background = darkGray
+				return layoutDialogButtons(gtx, theme, cancel, create)
+			}),
+		)
+	})
+}
+
+// document validates the inputs of the dialog.
+func (d *NewDocumentDialog) document() (Document, error) {
+	width, err := editorInt(&d.widthEditor)
+	if err != nil || width < 1 || width > maximumCanvasSize {
+		return Document{}, fmt.Errorf("width must be between 1 and %d", maximumCanvasSize)
+	}
+
+	height, err := editorInt(&d.heightEditor)
+	if err != nil || height < 1 || height > maximumCanvasSize {
+		return Document{}, fmt.Errorf("height must be between 1 and %d", maximumCanvasSize)
+	}
+
+	dpi, err := editorInt(&d.dpiEditor)
+	if err != nil || dpi < 1 || dpi > maximumDPI {
+		return Document{}, fmt.Errorf("DPI must be between 1 and %d", maximumDPI)
+	}
+
+	var background color.NRGBA
+	switch d.background.Value {
+	case whiteBackground:
+		background = defaultCanvasColor
+	case transparentBackground:
+		background = color.NRGBA{}
+	case customBackground:
+		background, err = parseHexColor(d.colorEditor.Text())
+		if err != nil {
+			return Document{}, err
+		}
+	}
+
+	return Document{Width: width, Height: height, DPI: dpi, Background: background}, nil
+}
+
+// newDocument replaces the canvas with an empty one made from the given settings. It can be undone.
+func newDocument(state *GemPaintState, document Document) {
+	state.history.Record(state)
+	state.document = document
+	state.layers = []*Layer{newLayer("Background", document.NewCanvas())}
+	state.activeLayerIndex = 0
+	state.previousPaintPosition = mouseIsOutsideCanvas
+	state.selection = nil
+	state.floating = nil
+	state.freeTransform = nil
+	cancelPen(state)
+	cancelText(state)
+
+	if debug {
+		fmt.Printf("New document: %+v\n", document)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Palettes are the named lists of colors shown as color buttons in the sidebar. They are saved between sessions,
+// and can be shared as GIMP, Adobe Swatch Exchange and hex list files.
+
+type Palette struct {
+	Name     string
+	Swatches []Swatch
+}
+
+type Swatch struct {
+	Name  string
+	Color color.NRGBA
+}
+
+func defaultPalette() Palette {
+	return Palette{
+		Name: "Default",
+		Swatches: []Swatch{
+			{Name: "Red", Color: red},
+			{Name: "Orange", Color: orange},
+			{Name: "Green", Color: green},
+			{Name: "Blue", Color: blue},
+			{Name: "Yellow", Color: yellow},
+			{Name: "Purple", Color: purple},
+			{Name: "Gray", Color: darkGray},
+		},
+	}
+}
+
+func activePalette(state *GemPaintState) *Palette {
+	return &state.palettes[state.activePaletteIndex]
+}
+
+// maximumRecentColors is how many of the colors painted with last are kept in the sidebar.
+const maximumRecentColors = 8
+
+// rememberColor puts c first in the recent colors, moving it there if it already was one of them.
+func rememberColor(state *GemPaintState, c color.NRGBA) {
+	recent := slices.DeleteFunc(slices.Clone(state.recentColors), func(r color.NRGBA) bool { return r == c })
+	state.recentColors = append([]color.NRGBA{c}, recent[:min(len(recent), maximumRecentColors-1)]...)
+}
+
+// syncColorButtons makes the color buttons match the swatches of the active palette, and the recent color
+// buttons match the recent colors.
+func syncColorButtons(state *GemPaintState) {
+	state.colorButtons = colorButtonsFor(state, state.colorButtons, activePalette(state).Swatches)
+
+	recent := make([]Swatch, len(state.recentColors))
+	for i, c := range state.recentColors {
+		recent[i] = Swatch{Name: formatHexColor(c), Color: c}
+	}
+	state.recentColorButtons = colorButtonsFor(state, state.recentColorButtons, recent)
+	for i := range state.recentColorButtons {
+		state.recentColorButtons[i].Small = true
+	}
+}
+
+// colorButtonsFor returns a button for each swatch. The buttons are only made again when the swatches changed,
+// so that clicks in progress aren't lost.
+func colorButtonsFor(state *GemPaintState, buttons []ColorButtonStyle, swatches []Swatch) []ColorButtonStyle {
+	if len(buttons) == len(swatches) {
+		changed := false
+		for i, swatch := range swatches {
+			if buttons[i].Color != swatch.Color || buttons[i].Label != swatch.Name {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return buttons
+		}
+	}
+
+	buttons = make([]ColorButtonStyle, len(swatches))
+	for i, swatch := range swatches {
+		swatch := swatch // Each button needs its own copy, the loop variable is shared by all the iterations
+		buttons[i] = ColorButton(swatch.Color, swatch.Name, func() {
+			state.paintColor = swatch.Color
+			if debug {
+				fmt.Println("Selected color: ", swatch.Name)
+			}
+		})
+		buttons[i].OnSecondaryClick = func() {
+			state.secondaryColor = swatch.Color
+			if debug {
+				fmt.Println("Selected secondary color: ", swatch.Name)
+			}
+		}
+	}
+	return buttons
+}
+
+// The palettes are saved as JSON, with the colors written in hex so that the file can be edited by hand.
+const palettesFileName = "palettes.json"
+
+type savedPalettes struct {
+	Active   int            `json:"active"`
+	Palettes []savedPalette `json:"palettes"`
+}
+
+type savedPalette struct {
+	Name     string        `json:"name"`
+	Swatches []savedSwatch `json:"swatches"`
+}
+
+type savedSwatch struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// loadPalettes reads the palettes saved in an earlier session. The default palette is used the first time, or
+// when the saved ones can't be read.
+func loadPalettes(state *GemPaintState) {
+	state.palettes = []Palette{defaultPalette()}
+	state.activePaletteIndex = 0
+
+	data, err := readConfigOnPlatform(palettesFileName)
+	if err != nil {
+		if debug && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("Error: ", err)
+		}
+		return
+	}
+
+	palettes, active, err := decodePalettes(data)
+	if err != nil {
+		if debug {
+			fmt.Println("Error: ", err)
+		}
+		return
+	}
+	state.palettes = palettes
+	state.activePaletteIndex = active
+}
+
+// savePalettes saves the palettes for the next session.
+func savePalettes(state *GemPaintState) {
+	data, err := encodePalettes(state.palettes, state.activePaletteIndex)
+	if err == nil {
+		err = writeConfigOnPlatform(palettesFileName, data)
+	}
+	if err != nil {
+		if debug {
+			fmt.Println("Error: ", err)
+		}
+	}
+}
+
+func encodePalettes(palettes []Palette, active int) ([]byte, error) {
+	saved := savedPalettes{Active: active}
+	for _, p := range palettes {
+		sp := savedPalette{Name: p.Name, Swatches: []savedSwatch{}}
+		for _, swatch := range p.Swatches {
+			sp.Swatches = append(sp.Swatches, savedSwatch{Name: swatch.Name, Color: formatHexColor(swatch.Color)})
+		}
+		saved.Palettes = append(saved.Palettes, sp)
+	}
+	return json.MarshalIndent(saved, "", "\t")
+}
+
+func decodePalettes(data []byte) (palettes []Palette, active int, err error) {
+	var saved savedPalettes
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, 0, fmt.Errorf("could not read the saved palettes: %w", err)
+	}
+	if len(saved.Palettes) == 0 {
+		return nil, 0, errors.New("no palettes were saved")
+	}
+
+	for _, sp := range saved.Palettes {
+		p := Palette{Name: sp.Name}
+		for _, ss := range sp.Swatches {
+			c, err := parseHexColor(ss.Color)
+			if err != nil {
+				return nil, 0, fmt.Errorf("could not read the saved palette %q: %w", sp.Name, err)
+			}
+			p.Swatches = append(p.Swatches, Swatch{Name: ss.Name, Color: c})
+		}
+		palettes = append(palettes, p)
+	}
+	return palettes, min(max(saved.Active, 0), len(palettes)-1), nil
+}
+
+// The file formats that palettes can be exported to.
+const (
+	PaletteFormatGPL = "GIMP (.gpl)"
+	PaletteFormatASE = "Adobe (.ase)"
+	PaletteFormatHex = "Hex List (.hex)"
+)
+
+var paletteFormats = []string{PaletteFormatGPL, PaletteFormatASE, PaletteFormatHex}
+
+// encodePaletteFile writes the palette in one of the paletteFormats, and returns the file name it should be
+// saved under.
+func encodePaletteFile(p Palette, format string) (data []byte, fileName string) {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, p.Name)
+	if strings.TrimSpace(name) == "" {
+		name = "palette"
+	}
+
+	switch format {
+	case PaletteFormatASE:
+		return encodeASE(p), name + ".ase"
+	case PaletteFormatHex:
+		return encodeHexList(p), name + ".hex"
+	default:
+		return encodeGPL(p), name + ".gpl"
+	}
+}
+
+// parsePaletteFile reads a palette from a GIMP, Adobe Swatch Exchange or hex list file, telling them apart by
+// their content. Palettes without a name of their own are named after the file.
+func parsePaletteFile(data []byte, fileName string) (Palette, error) {
+	var p Palette
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("ASEF")):
+		p, err = parseASE(data)
+	case bytes.HasPrefix(bytes.TrimLeft(data, "\ufeff \t\r\n"), []byte("GIMP Palette")):
+		p, err = parseGPL(data)
+	default:
+		p, err = parseHexList(data)
+	}
+	if err != nil {
+		return Palette{}, err
+	}
+	if len(p.Swatches) == 0 {
+		return Palette{}, errors.New("the palette has no colors")
+	}
+
+	if strings.TrimSpace(p.Name) == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	}
+	if strings.TrimSpace(p.Name) == "" || p.Name == "." {
+		p.Name = "Imported Palette"
+	}
+	return p, nil
+}
+
+// GIMP palettes are text: a header, the palette's name, and a line per color with its red, green and blue from 0
+// to 255 followed by its name. They have no alpha, so exporting one makes every color opaque.
+
+func parseGPL(data []byte) (Palette, error) {
+	var p Palette
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	header := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if !header {
+			if line == "" {
+				continue
+			}
+			if line != "GIMP Palette" {
+				return Palette{}, errors.New("not a GIMP palette")
+			}
+			header = true
+			continue
+		}
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "Name:"):
+			p.Name = strings.TrimSpace(strings.TrimPrefix(line, "Name:"))
+			continue
+		case strings.HasPrefix(line, "Columns:"):
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return Palette{}, fmt.Errorf("invalid GIMP palette line %q", line)
+		}
+		var channels [3]uint8
+		for i := range channels {
+			value, err := strconv.Atoi(fields[i])
+			if err != nil || value < 0 || value > 255 {
+				return Palette{}, fmt.Errorf("invalid GIMP palette line %q", line)
+			}
+			channels[i] = uint8(value)
+		}
+		c := color.NRGBA{R: channels[0], G: channels[1], B: channels[2], A: 255}
+		p.Swatches = append(p.Swatches, Swatch{Name: swatchName(strings.Join(fields[3:], " "), c), Color: c})
+	}
+	if err := scanner.Err(); err != nil {
+		return Palette{}, err
+	}
+	return p, nil
+}
+
+func encodeGPL(p Palette) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "GIMP Palette\nName: %s\nColumns: 0\n#\n", p.Name)
+	for _, swatch := range p.Swatches {
+		fmt.Fprintf(&buf, "%3d %3d %3d\t%s\n", swatch.Color.R, swatch.Color.G, swatch.Color.B, swatch.Name)
+	}
+	return buf.Bytes()
+}
+
+// Hex lists have a color per line, eg. ff8800, as the palettes on Lospec are shared. A name may follow the
+// color, and lines starting with ; or // are comments.
+
+func parseHexList(data []byte) (Palette, error) {
+	var p Palette
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		c, err := parseHexColor(fields[0])
+		if err != nil {
+			return Palette{}, err
+		}
+		p.Swatches = append(p.Swatches, Swatch{Name: swatchName(strings.Join(fields[1:], " "), c), Color: c})
+	}
+	if err := scanner.Err(); err != nil {
+		return Palette{}, err
+	}
+	return p, nil
+}
+
+func encodeHexList(p Palette) []byte {
+	var buf bytes.Buffer
+	for _, swatch := range p.Swatches {
+		fmt.Fprintln(&buf, strings.TrimPrefix(formatHexColor(swatch.Color), "#"))
+	}
+	return buf.Bytes()
+}
+
+// swatchName names a swatch that has no name after its color.
+func swatchName(name string, c color.NRGBA) string {
+	if name = strings.TrimSpace(name); name != "" {
+		return name
+	}
+	return formatHexColor(c)
+}
+
+// Adobe Swatch Exchange files are binary and big endian: a header, then blocks of colors, which can be grouped.
+// Each color has a UTF-16 name, a color model and a float per channel. RGB, gray and CMYK colors are read, and
+// Lab colors are skipped. The palette's name is kept as the name of a group around its colors.
+
+const (
+	aseColorEntry = 0x0001
+	aseGroupStart = 0xc001
+	aseGroupEnd   = 0xc002
+)
+
+func parseASE(data []byte) (Palette, error) {
+	r := bytes.NewReader(data)
+	var header struct {
+		Signature    [4]byte
+		Major, Minor uint16
+		Blocks       uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil || string(header.Signature[:]) != "ASEF" {
+		return Palette{}, errors.New("not an Adobe Swatch Exchange file")
+	}
+
+	var p Palette
+	for i := 0; i < int(header.Blocks); i++ {
+		var blockType uint16
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &blockType); err != nil {
+			return Palette{}, fmt.Errorf("the swatch file is cut short: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return Palette{}, fmt.Errorf("the swatch file is cut short: %w", err)
+		}
+		if int64(length) > int64(r.Len()) {
+			return Palette{}, errors.New("the swatch file is cut short")
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return Palette{}, fmt.Errorf("the swatch file is cut short: %w", err)
+		}
+
+		switch blockType {
+		case aseGroupStart:
+			name, _, err := readASEName(block)
+			if err != nil {
+				return Palette{}, err
+			}
+			if p.Name == "" { // Only the first group names the palette, the colors of all groups are kept
+				p.Name = name
+			}
+
+		case aseColorEntry:
+			swatch, ok, err := readASEColor(block)
+			if err != nil {
+				return Palette{}, err
+			}
+			if ok {
+				p.Swatches = append(p.Swatches, swatch)
+			}
+		}
+	}
+	return p, nil
+}
+
+// readASEName reads a name, which starts with its length in UTF-16 code units, counting the 0 that ends it.
+func readASEName(block []byte) (name string, rest []byte, err error) {
+	if len(block) < 2 {
+		return "", nil, errors.New("invalid swatch name")
+	}
+	length := int(binary.BigEndian.Uint16(block))
+	block = block[2:]
+	if len(block) < length*2 {
+		return "", nil, errors.New("invalid swatch name")
+	}
+
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(block[i*2:])
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units)), block[length*2:], nil
+}
+
+// readASEColor reads a color block. It is false for colors in models that aren't read.
+func readASEColor(block []byte) (Swatch, bool, error) {
+	name, block, err := readASEName(block)
+	if err != nil {
+		return Swatch{}, false, err
+	}
+	if len(block) < 4 {
+		return Swatch{}, false, errors.New("invalid swatch color")
+	}
+	model := string(block[:4])
+	block = block[4:]
+
+	channels := map[string]int{"RGB ": 3, "Gray": 1, "CMYK": 4, "LAB ": 3}[model]
+	if channels == 0 || len(block) < channels*4 {
+		return Swatch{}, false, fmt.Errorf("invalid swatch color model %q", model)
+	}
+	var v [4]float64
+	for i := 0; i < channels; i++ {
+		v[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(block[i*4:])))
+	}
+
+	var r, g, b float64
+	switch model {
+	case "RGB ":
+		r, g, b = v[0], v[1], v[2]
+	case "Gray":
+		r, g, b = v[0], v[0], v[0]
+	case "CMYK":
+		r, g, b = (1-v[0])*(1-v[3]), (1-v[1])*(1-v[3]), (1-v[2])*(1-v[3])
+	default:
+		return Swatch{}, false, nil
+	}
+
+	c := color.NRGBA{R: toChannel(r), G: toChannel(g), B: toChannel(b), A: 255}
+	return Swatch{Name: swatchName(name, c), Color: c}, true, nil
+}
+
+func encodeASE(p Palette) []byte {
+	var blocks bytes.Buffer
+	writeBlock := func(blockType uint16, content []byte) {
+		binary.Write(&blocks, binary.BigEndian, blockType)
+		binary.Write(&blocks, binary.BigEndian, uint32(len(content)))
+		blocks.Write(content)
+	}
+
+	writeBlock(aseGroupStart, appendASEName(nil, p.Name))
+	for _, swatch := range p.Swatches {
+		content := appendASEName(nil, swatch.Name)
+		content = append(content, "RGB "...)
+		for _, channel := range []uint8{swatch.Color.R, swatch.Color.G, swatch.Color.B} {
+			content = binary.BigEndian.AppendUint32(content, math.Float32bits(float32(channel)/255))
+		}
+		content = binary.BigEndian.AppendUint16(content, 2) // A normal color, rather than a global or spot one
+		writeBlock(aseColorEntry, content)
+	}
+	writeBlock(aseGroupEnd, nil)
+
+	var buf bytes.Buffer
+	buf.WriteString("ASEF")
+	binary.Write(&buf, binary.BigEndian, [2]uint16{1, 0}) // Version 1.0
+	binary.Write(&buf, binary.BigEndian, uint32(len(p.Swatches)+2))
+	buf.Write(blocks.Bytes())
+	return buf.Bytes()
+}
+
+func appendASEName(b []byte, name string) []byte {
+	units := append(utf16.Encode([]rune(name)), 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(units)))
+	for _, unit := range units {
+		b = binary.BigEndian.AppendUint16(b, unit)
+	}
+	return b
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"slices"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// PaletteDialog edits the palettes: which one the sidebar shows, their names and swatches, and importing and
+// exporting them. Every change is saved right away, so that nothing is lost if the app is closed, except for
+// typed names, which are saved once the typing pauses or the dialog closes.
+type PaletteDialog struct {
+	paletteButtons      []widget.Clickable
+	newPaletteButton    widget.Clickable
+	deletePaletteButton widget.Clickable
+	paletteNameEditor   widget.Editor
+
+	rows            []swatchRow
+	rowsPalette     int // The index of the palette that the rows were made for
+	swatchList      layout.List
+	addSwatchButton widget.Clickable
+
+	colorCountEditor widget.Editor
+	generateButton   widget.Clickable
+
+	importButton widget.Clickable
+	exportFormat widget.Enum
+	exportButton widget.Clickable
+
+	closeButton widget.Clickable
+
+	namesEditedAt time.Time // When a name was last typed in, zero once it is saved
+	errorMessage  string
+}
+
+// paletteNameSaveDelay is how long the typing in a name has to pause before the palettes are saved.
+var paletteNameSaveDelay = time.Second
+
+type swatchRow struct {
+	nameEditor     widget.Editor
+	moveUpButton   widget.Clickable
+	moveDownButton widget.Clickable
+	setButton      widget.Clickable
+	removeButton   widget.Clickable
+}
+
+func newPaletteDialog(state *GemPaintState) *PaletteDialog {
+	d := &PaletteDialog{}
+	d.paletteNameEditor.SingleLine = true
+	d.swatchList.Axis = layout.Vertical
+	d.exportFormat.Value = PaletteFormatGPL
+	d.colorCountEditor.SingleLine = true
+	d.colorCountEditor.Filter = "0123456789"
+	d.colorCountEditor.SetText("8")
+	d.loadRows(state)
+	return d
+}
+
+// loadRows makes the rows for the swatches of the active palette, and fills in their names.
+func (d *PaletteDialog) loadRows(state *GemPaintState) {
+	palette := activePalette(state)
+	d.rowsPalette = state.activePaletteIndex
+	d.paletteNameEditor.SetText(palette.Name)
+
+	d.rows = make([]swatchRow, len(palette.Swatches))
+	for i, swatch := range palette.Swatches {
+		d.rows[i].nameEditor.SingleLine = true
+		d.rows[i].nameEditor.SetText(swatch.Name)
+	}
+}
+
+func (d *PaletteDialog) Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	// The palettes may have changed under the dialog, eg. when one was imported.
+	if d.rowsPalette != state.activePaletteIndex || len(d.rows) != len(activePalette(state).Swatches) {
+		d.loadRows(state)
+	}
+
+	changed := false
+
+	for len(d.paletteButtons) < len(state.palettes) {
+		d.paletteButtons = append(d.paletteButtons, widget.Clickable{})
+	}
+	for i := range state.palettes {
+		if d.paletteButtons[i].Clicked(gtx) && i != state.activePaletteIndex {
+			state.activePaletteIndex = i
+			d.loadRows(state)
+			changed = true
+		}
+	}
+
+	if d.newPaletteButton.Clicked(gtx) {
+		state.palettes = append(state.palettes, Palette{Name: fmt.Sprintf("Palette %d", len(state.palettes)+1)})
+		state.activePaletteIndex = len(state.palettes) - 1
+		d.loadRows(state)
+		changed = true
+	}
+
+	if d.deletePaletteButton.Clicked(gtx) {
+		if len(state.palettes) == 1 {
+			d.errorMessage = "the last palette can't be deleted"
+		} else {
+			state.palettes = slices.Delete(state.palettes, state.activePaletteIndex, state.activePaletteIndex+1)
+			state.activePaletteIndex = min(state.activePaletteIndex, len(state.palettes)-1)
+			d.loadRows(state)
+			changed = true
+		}
+	}
+
+	palette := activePalette(state)
+
+	for {
+		e, ok := d.paletteNameEditor.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := e.(widget.ChangeEvent); ok && d.paletteNameEditor.Text() != palette.Name {
+			palette.Name = d.paletteNameEditor.Text()
+			d.namesEditedAt = gtx.Now
+		}
+	}
+
+	for i := range d.rows {
+		row := &d.rows[i]
+		for {
+			e, ok := row.nameEditor.Update(gtx)
+			if !ok {
+				break
+			}
+			if _, ok := e.(widget.ChangeEvent); ok && row.nameEditor.Text() != palette.Swatches[i].Name {
+				palette.Swatches[i].Name = row.nameEditor.Text()
+				d.namesEditedAt = gtx.Now
+			}
+		}
+	}
+
+	// Only one swatch is moved or removed per frame, since either changes the rows.
+	for i := range d.rows {
+		row := &d.rows[i]
+		if row.setButton.Clicked(gtx) {
+			palette.Swatches[i].Color = state.paintColor
+			changed = true
+		}
+		if row.moveUpButton.Clicked(gtx) && i > 0 {
+			palette.Swatches[i-1], palette.Swatches[i] = palette.Swatches[i], palette.Swatches[i-1]
+			d.loadRows(state)
+			changed = true
+			break
+		}
+		if row.moveDownButton.Clicked(gtx) && i < len(d.rows)-1 {
+			palette.Swatches[i+1], palette.Swatches[i] = palette.Swatches[i], palette.Swatches[i+1]
+			d.loadRows(state)
+			changed = true
+			break
+		}
+		if row.removeButton.Clicked(gtx) {
+			palette.Swatches = slices.Delete(palette.Swatches, i, i+1)
+			d.loadRows(state)
+			changed = true
+			break
+		}
+	}
+
+	if d.addSwatchButton.Clicked(gtx) {
+		palette.Swatches = append(palette.Swatches, Swatch{Name: formatHexColor(state.paintColor), Color: state.paintColor})
+		d.loadRows(state)
+		changed = true
+	}
+
+	if d.generateButton.Clicked(gtx) && state.job == nil {
+		count, err := editorInt(&d.colorCountEditor)
+		if err != nil || count < 2 || count > 256 {
+			d.errorMessage = "the number of colors must be a whole number from 2 to 256"
+		} else {
+			closeDialog(state)
+			generatePaletteFromImage(state, count)
+		}
+	}
+
+	if d.importButton.Clicked(gtx) {
+		importPalette(state)
+	}
+
+	if d.exportButton.Clicked(gtx) {
+		data, fileName := encodePaletteFile(*palette, d.exportFormat.Value)
+		go saveFileOnPlatform(state, data, fileName)
+		if debug {
+			fmt.Println("Exported palette: ", fileName)
+		}
+	}
+
+	if d.closeButton.Clicked(gtx) {
+		closeDialog(state)
+	}
+
+	if changed {
+		d.errorMessage = ""
+		d.save(state)
+	}
+
+	if !d.namesEditedAt.IsZero() {
+		if saveAt := d.namesEditedAt.Add(paletteNameSaveDelay); gtx.Now.Before(saveAt) {
+			gtx.Execute(op.InvalidateCmd{At: saveAt})
+		} else {
+			d.save(state)
+		}
+	}
+
+	return layoutDialog(gtx, state, theme, "Palettes", func(gtx layout.Context) layout.Dimensions {
+		button := func(clickable *widget.Clickable, label string) material.ButtonStyle {
+			b := material.Button(theme, clickable, label)
+			b.Background = golangBlue
+			return b
+		}
+
+		paletteButtons := make([]material.ButtonStyle, len(state.palettes))
+		for i, p := range state.palettes {
+			paletteButtons[i] = button(&d.paletteButtons[i], p.Name)
+			if i != state.activePaletteIndex {
+				paletteButtons[i].Background = lightGray
+				paletteButtons[i].Color = darkGray
+			}
+		}
+
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, paletteButtons...)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Name", &d.paletteNameEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme,
+					button(&d.newPaletteButton, "New Palette"),
+					button(&d.deletePaletteButton, "Delete Palette"),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(unit.Dp(380))
+				gtx.Constraints.Max.X = gtx.Constraints.Min.X
+				gtx.Constraints.Max.Y = gtx.Dp(unit.Dp(280))
+				if len(d.rows) == 0 {
+					return material.Body2(theme, "This palette has no colors yet.").Layout(gtx)
+				}
+				return d.swatchList.Layout(gtx, len(d.rows), func(gtx layout.Context, i int) layout.Dimensions {
+					return d.layoutSwatchRow(gtx, theme, palette.Swatches[i], &d.rows[i])
+				})
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, button(&d.addSwatchButton, "Add Current Color"))
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Colors", &d.colorCountEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, button(&d.generateButton, "Generate From Image"))
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				children := []layout.FlexChild{layout.Rigid(material.Body1(theme, "Format: ").Layout)}
+				for _, format := range paletteFormats {
+					children = append(children, layout.Rigid(material.RadioButton(theme, &d.exportFormat, format, format).Layout))
+				}
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme,
+					button(&d.importButton, "Import..."),
+					button(&d.exportButton, "Export"),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogError(gtx, theme, d.errorMessage)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				closeButton := material.Button(theme, &d.closeButton, "Close")
+				closeButton.Background = darkGray
+				return layoutDialogButtons(gtx, theme, closeButton)
+			}),
+		)
+	})
+}
+
+// layoutSwatchRow lays out a swatch's color and name, with the buttons that move it, set it to the paint color
+// and remove it.
+// save writes the palettes, including the names typed since the last save.
+func (d *PaletteDialog) save(state *GemPaintState) {
+	d.namesEditedAt = time.Time{}
+	savePalettes(state)
+}
+
+// onClose saves the names that were typed too recently to be saved yet.
+func (d *PaletteDialog) onClose(state *GemPaintState) {
+	if !d.namesEditedAt.IsZero() {
+		d.save(state)
+	}
+}
+
+func (d *PaletteDialog) layoutSwatchRow(gtx layout.Context, theme *material.Theme, swatch Swatch, row *swatchRow) layout.Dimensions {
+	iconButton := func(clickable *widget.Clickable, icon *widget.Icon, description string) layout.Widget {
+		return func(gtx layout.Context) layout.Dimensions {
+			button := material.IconButton(theme, clickable, icon, description)
+			button.Background = lightGray
+			button.Color = darkGray
+			button.Size = unit.Dp(16)
+			button.Inset = layout.UniformInset(6)
+			return button.Layout(gtx)
+		}
+	}
+
+	return layout.Inset{Bottom: 4}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				rect := image.Rectangle{Max: image.Pt(gtx.Dp(unit.Dp(28)), gtx.Dp(unit.Dp(28)))}
+				paint.FillShape(gtx.Ops, swatch.Color, clip.Rect(rect).Op())
+				strokeRect(gtx, rect, darkGray)
+				return layout.Dimensions{Size: rect.Max}
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return widget.Border{Color: lightGray, Width: unit.Dp(1)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return layout.UniformInset(6).Layout(gtx, material.Editor(theme, &row.nameEditor, "Name").Layout)
+				})
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(6)}.Layout),
+			layout.Rigid(iconButton(&row.moveUpButton, MoveUpIcon, "Move up")),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(4)}.Layout),
+			layout.Rigid(iconButton(&row.moveDownButton, MoveDownIcon, "Move down")),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(4)}.Layout),
+			layout.Rigid(iconButton(&row.setButton, EyedropperIcon, "Set to the paint color")),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(4)}.Layout),
+			layout.Rigid(iconButton(&row.removeButton, ClearIcon, "Remove")),
+		)
+	})
+}
+
+// importPalette lets the user pick a palette file. The palette arrives in state.importedPalettes, since the file
+// picker blocks until a file is picked.
+func importPalette(state *GemPaintState) {
+	go func() {
+		file, err := state.expl.ChooseFile(".gpl", ".ase", ".hex", ".txt")
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+
+		fileName := ""
+		if named, ok := file.(interface{ Name() string }); ok { // Files on the desktop know their name
+			fileName = named.Name()
+		}
+		palette, err := parsePaletteFile(data, fileName)
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+
+		state.importedPalettes <- palette
+		state.window.Invalidate()
+	}()
+}
+
+// receiveImportedPalette adds the palette that was imported, and shows it in the sidebar.
+func receiveImportedPalette(state *GemPaintState) {
+	select {
+	case palette := <-state.importedPalettes:
+		state.palettes = append(state.palettes, palette)
+		state.activePaletteIndex = len(state.palettes) - 1
+		savePalettes(state)
+		if debug {
+			fmt.Println("Imported palette: ", palette.Name)
+		}
+	default:
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+var testPalette = Palette{Name: "Sunset ☀", Swatches: []Swatch{
+	{Name: "Deep Orange", Color: color.NRGBA{R: 255, G: 87, B: 34, A: 255}},
+	{Name: "Black", Color: color.NRGBA{A: 255}},
+	{Name: "White", Color: color.NRGBA{R: 255, G: 255, B: 255, A: 255}},
+	{Name: "Dusk Blue 2", Color: color.NRGBA{R: 18, G: 52, B: 86, A: 255}},
+}}
+
+func TestPaletteFileRoundTrips(t *testing.T) {
+	// Hex lists have no names, so their swatches come back named after their colors, and the palette after the file.
+	hexPalette := Palette{Name: "Sunset ☀"}
+	for _, swatch := range testPalette.Swatches {
+		hexPalette.Swatches = append(hexPalette.Swatches, Swatch{Name: formatHexColor(swatch.Color), Color: swatch.Color})
+	}
+
+	tests := []struct {
+		format   string
+		fileName string
+		expected Palette
+	}{
+		{PaletteFormatGPL, "Sunset ☀.gpl", testPalette},
+		{PaletteFormatASE, "Sunset ☀.ase", testPalette},
+		{PaletteFormatHex, "Sunset ☀.hex", hexPalette},
+	}
+	for _, test := range tests {
+		data, fileName := encodePaletteFile(testPalette, test.format)
+		if fileName != test.fileName {
+			t.Errorf("%s: file name is %q, expected %q", test.format, fileName, test.fileName)
+		}
+
+		parsed, err := parsePaletteFile(data, fileName)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, test.expected) {
+			t.Errorf("%s: read back %+v, expected %+v", test.format, parsed, test.expected)
+		}
+	}
+}
+
+func TestParseGPL(t *testing.T) {
+	data := "GIMP Palette\nName: Pico\nColumns: 4\n# A comment\n\n  0   0   0\tBlack\n255 236  39 Bright Yellow\n 41 173 255\n"
+	expected := Palette{Name: "Pico", Swatches: []Swatch{
+		{Name: "Black", Color: color.NRGBA{A: 255}},
+		{Name: "Bright Yellow", Color: color.NRGBA{R: 255, G: 236, B: 39, A: 255}},
+		{Name: "#29adff", Color: color.NRGBA{R: 41, G: 173, B: 255, A: 255}},
+	}}
+
+	p, err := parseGPL([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("read %+v, expected %+v", p, expected)
+	}
+
+	for _, invalid := range []string{
+		"JASC-PAL\n0100\n",
+		"GIMP Palette\n255 255\n",
+		"GIMP Palette\n256 0 0 Too Red\n",
+		"GIMP Palette\nred green blue\n",
+	} {
+		if _, err := parseGPL([]byte(invalid)); err == nil {
+			t.Errorf("%q was read without an error", invalid)
+		}
+	}
+}
+
+func TestParseHexList(t *testing.T) {
+	data := "\ufeff; Lospec palette\nff8800 Orange\n// Another comment\n#00ff00\n\n0000ff   Deep   Blue\n11223380\n"
+	expected := Palette{Swatches: []Swatch{
+		{Name: "Orange", Color: color.NRGBA{R: 255, G: 136, A: 255}},
+		{Name: "#00ff00", Color: color.NRGBA{G: 255, A: 255}},
+		{Name: "Deep Blue", Color: color.NRGBA{B: 255, A: 255}},
+		{Name: "#11223380", Color: color.NRGBA{R: 17, G: 34, B: 51, A: 128}},
+	}}
+
+	p, err := parseHexList([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("read %+v, expected %+v", p, expected)
+	}
+
+	if _, err := parseHexList([]byte("ff8800\nnot a color\n")); err == nil {
+		t.Error("a line that isn't a color was read without an error")
+	}
+}
+
+func TestParseASERejectsBrokenFiles(t *testing.T) {
+	valid := encodeASE(testPalette)
+
+	// Every way of cutting the file short must fail, rather than read past the end or make up colors.
+	for length := 0; length < len(valid); length++ {
+		if _, err := parseASE(valid[:length]); err == nil {
+			t.Errorf("the file cut short to %d of %d bytes was read without an error", length, len(valid))
+		}
+	}
+
+	header := []byte("ASEF\x00\x01\x00\x00\x00\x00\x00\x01")
+	block := func(blockType uint16, content []byte) []byte {
+		b := binary.BigEndian.AppendUint16(nil, blockType)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(content)))
+		return append(b, content...)
+	}
+	name := appendASEName(nil, "Swatch")
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"garbage", []byte("this is not a swatch file at all")},
+		{"a block longer than the file", join(header, []byte{0x00, 0x01, 0xff, 0xff, 0xff, 0xff, 0x00})},
+		{"a name longer than its block", join(header, block(aseColorEntry, []byte{0x00, 0x40, 0x00, 0x41}))},
+		{"a color without a model", join(header, block(aseColorEntry, name))},
+		{"an unknown color model", join(header, block(aseColorEntry, join(name, []byte("HSV "), make([]byte, 14))))},
+		{"fewer channels than the model has", join(header, block(aseColorEntry, join(name, []byte("RGB "), make([]byte, 8))))},
+	}
+	for _, test := range tests {
+		if _, err := parseASE(test.data); err == nil {
+			t.Errorf("%s was read without an error", test.name)
+		}
+	}
+}
+
+func TestParseASEColorModels(t *testing.T) {
+	colorBlock := func(name, model string, channels ...float32) []byte {
+		content := append(appendASEName(nil, name), model...)
+		for _, c := range channels {
+			content = binary.BigEndian.AppendUint32(content, math.Float32bits(c))
+		}
+		content = binary.BigEndian.AppendUint16(content, 2)
+
+		b := binary.BigEndian.AppendUint16(nil, aseColorEntry)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(content)))
+		return append(b, content...)
+	}
+
+	data := []byte("ASEF\x00\x01\x00\x00\x00\x00\x00\x04")
+	data = append(data, colorBlock("Red", "RGB ", 1, 0, 0)...)
+	data = append(data, colorBlock("Mid Gray", "Gray", 0.5)...)
+	data = append(data, colorBlock("Cyan", "CMYK", 1, 0, 0, 0)...)
+	data = append(data, colorBlock("Skipped", "LAB ", 50, 0, 0)...)
+
+	p, err := parseASE(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Swatch{
+		{Name: "Red", Color: color.NRGBA{R: 255, A: 255}},
+		{Name: "Mid Gray", Color: color.NRGBA{R: 128, G: 128, B: 128, A: 255}},
+		{Name: "Cyan", Color: color.NRGBA{G: 255, B: 255, A: 255}},
+	}
+	if !reflect.DeepEqual(p.Swatches, expected) {
+		t.Errorf("read %+v, expected %+v", p.Swatches, expected)
+	}
+}
+
+// Each swatch button must pick its own color, not the color of the last swatch, with either mouse button.
+func TestColorButtonsPickTheirSwatch(t *testing.T) {
+	state := &GemPaintState{palettes: []Palette{testPalette}}
+	syncColorButtons(state)
+
+	for _, i := range []int{0, 2} {
+		expected := testPalette.Swatches[i].Color
+
+		state.colorButtons[i].OnClick()
+		if state.paintColor != expected {
+			t.Errorf("clicking swatch %d picked %v, expected %v", i, state.paintColor, expected)
+		}
+
+		state.colorButtons[i].OnSecondaryClick()
+		if state.secondaryColor != expected {
+			t.Errorf("right-clicking swatch %d picked %v, expected %v", i, state.secondaryColor, expected)
+		}
+	}
+}
+
+func TestRecentColorButtonsPickTheirColor(t *testing.T) {
+	state := &GemPaintState{palettes: []Palette{testPalette}}
+	for _, swatch := range testPalette.Swatches {
+		rememberColor(state, swatch.Color)
+	}
+	syncColorButtons(state)
+
+	for i, expected := range state.recentColors {
+		state.recentColorButtons[i].OnClick()
+		if state.paintColor != expected {
+			t.Errorf("clicking recent color %d picked %v, expected %v", i, state.paintColor, expected)
+		}
+	}
+}
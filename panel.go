@@ -0,0 +1,32 @@
+package main
+
+import (
+	"image"
+
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+)
+
+// layoutPanel draws content on a rounded card floating over the canvas. The card swallows pointer events,
+// so that clicking between the buttons of a panel doesn't paint on the canvas under it.
+func layoutPanel(gtx layout.Context, tag event.Tag, content layout.Widget) layout.Dimensions {
+	return layout.Background{}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		rr := gtx.Dp(unit.Dp(8))
+		defer clip.UniformRRect(image.Rectangle{Max: gtx.Constraints.Min}, rr).Push(gtx.Ops).Pop()
+
+		event.Op(gtx.Ops, tag)
+		for {
+			_, ok := gtx.Event(pointer.Filter{Target: tag, Kinds: pointer.Press | pointer.Drag | pointer.Release | pointer.Scroll})
+			if !ok {
+				break
+			}
+		}
+
+		paint.Fill(gtx.Ops, softBlue)
+		return layout.Dimensions{Size: gtx.Constraints.Min}
+	}, content)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// minimumBandHeight keeps bands from becoming so thin that the goroutine overhead outweighs the work.
+const minimumBandHeight = 16
+
+// bandsPerWorker splits the work finer than one band per worker so that progress is reported more often
+// and workers that finish early can pick up the remaining bands.
+const bandsPerWorker = 4
+
+// parallelBands splits rect into horizontal bands and calls work on each of them from GOMAXPROCS worker
+// goroutines. It returns once every band has been processed. Work must only touch the pixels of its own band.
+func parallelBands(rect image.Rectangle, work func(band image.Rectangle)) {
+	parallelBandsWithProgress(rect, work, nil)
+}
+
+// parallelBandsWithProgress is parallelBands, but also calls progress with the fraction of rows completed
+// after each band. Progress calls are serialized, but they are made from the worker goroutines.
+func parallelBandsWithProgress(rect image.Rectangle, work func(band image.Rectangle), progress func(done float32)) {
+	if rect.Empty() {
+		return
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	bandHeight := max(rect.Dy()/(workers*bandsPerWorker), minimumBandHeight)
+
+	var bands []image.Rectangle
+	for y := rect.Min.Y; y < rect.Max.Y; y += bandHeight {
+		bands = append(bands, image.Rect(rect.Min.X, y, rect.Max.X, min(y+bandHeight, rect.Max.Y)))
+	}
+
+	if len(bands) == 1 || workers == 1 { // Not worth spinning up goroutines
+		for i, band := range bands {
+			work(band)
+			if progress != nil {
+				progress(float32(i+1) / float32(len(bands)))
+			}
+		}
+		return
+	}
+
+	queue := make(chan image.Rectangle, len(bands))
+	for _, band := range bands {
+		queue <- band
+	}
+	close(queue)
+
+	var progressMutex sync.Mutex
+	rowsDone := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(bands)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for band := range queue {
+				work(band)
+
+				if progress != nil {
+					progressMutex.Lock()
+					rowsDone += band.Dy()
+					progress(float32(rowsDone) / float32(rect.Dy()))
+					progressMutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"image"
+	"math"
+	"slices"
+
+	"gioui.org/f32"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// PenTool is the state of the pen while a path is drawn or edited. Clicking places corners, dragging right
+// after placing an anchor pulls its handles out into a curve. The path can then be painted onto the layer or
+// kept as a vector shape.
+type PenTool struct {
+	path VectorPath
+
+	// The vector shape that the path was picked up from, if it is being edited rather than drawn.
+	editedLayer *Layer
+	editedIndex int
+
+	drag              penDrag
+	dragIndex         int // Of the anchor being dragged
+	dragStart         vec2
+	anchorAtDragStart PathAnchor
+	breakHandles      bool // Alt was held when the drag started, so the two handles of the anchor move separately
+}
+
+type penDrag int
+
+const (
+	penNoDrag        penDrag = iota
+	penDragNewAnchor         // Pulling the handles out of the anchor just placed
+	penDragAnchor
+	penDragIn
+	penDragOut
+)
+
+func handlePenPointer(state *GemPaintState, p pointer.Event) {
+	tool := &state.penTool
+	position := vec2{X: float64(p.Position.X), Y: float64(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		if index, drag, ok := penHandleAt(tool.path, position); ok {
+			if drag == penDragAnchor && index == 0 && len(tool.path.Anchors) >= 2 && !tool.path.Closed {
+				tool.path.Closed = true
+				return
+			}
+			startPenDrag(tool, drag, index, position, p.Modifiers)
+			return
+		}
+
+		if tool.path.Closed { // There is nothing more to add, only the anchors and handles can still be moved
+			return
+		}
+		if len(tool.path.Anchors) == 0 && pickUpShape(state, position) {
+			return
+		}
+
+		if n := len(tool.path.Anchors); n > 0 && p.Modifiers.Contain(key.ModShift) {
+			position = snapTo45Degrees(tool.path.Anchors[n-1].Point, position)
+		}
+		tool.path.Anchors = append(tool.path.Anchors, cornerAnchor(position))
+		startPenDrag(tool, penDragNewAnchor, len(tool.path.Anchors)-1, position, p.Modifiers)
+
+	case pointer.Drag:
+		dragPen(tool, position)
+
+	case pointer.Release:
+		tool.drag = penNoDrag
+	}
+}
+
+func startPenDrag(tool *PenTool, drag penDrag, index int, position vec2, modifiers key.Modifiers) {
+	tool.drag = drag
+	tool.dragIndex = index
+	tool.dragStart = position
+	tool.anchorAtDragStart = tool.path.Anchors[index]
+	tool.breakHandles = modifiers.Contain(key.ModAlt)
+}
+
+func dragPen(tool *PenTool, position vec2) {
+	if tool.drag == penNoDrag {
+		return
+	}
+
+	anchor := &tool.path.Anchors[tool.dragIndex]
+	switch tool.drag {
+	case penDragNewAnchor: // Both handles, mirrored, so the curve is smooth
+		anchor.Out = position
+		anchor.In = vec2{X: 2*anchor.Point.X - position.X, Y: 2*anchor.Point.Y - position.Y}
+
+	case penDragAnchor: // The handles move with the anchor
+		dx, dy := position.X-tool.dragStart.X, position.Y-tool.dragStart.Y
+		start := tool.anchorAtDragStart
+		anchor.Point = vec2{X: start.Point.X + dx, Y: start.Point.Y + dy}
+		anchor.In = vec2{X: start.In.X + dx, Y: start.In.Y + dy}
+		anchor.Out = vec2{X: start.Out.X + dx, Y: start.Out.Y + dy}
+
+	case penDragIn:
+		anchor.In = position
+		if !tool.breakHandles {
+			anchor.Out = oppositeHandle(anchor.Point, position, anchor.Out)
+		}
+
+	case penDragOut:
+		anchor.Out = position
+		if !tool.breakHandles {
+			anchor.In = oppositeHandle(anchor.Point, position, anchor.In)
+		}
+	}
+}
+
+// oppositeHandle points the other handle of an anchor away from the dragged one, keeping its length, so that
+// the curve stays smooth through the anchor. A handle that wasn't pulled out yet mirrors the dragged one.
+func oppositeHandle(point, dragged, other vec2) vec2 {
+	draggedLength := distance(point, dragged)
+	if draggedLength == 0 {
+		return other
+	}
+
+	length := distance(point, other)
+	if length == 0 {
+		length = draggedLength
+	}
+	scale := length / draggedLength
+	return vec2{X: point.X - (dragged.X-point.X)*scale, Y: point.Y - (dragged.Y-point.Y)*scale}
+}
+
+// penHandleAt finds the handle or anchor of the path under position. Handles come first, since they may be
+// on top of their anchor.
+func penHandleAt(path VectorPath, position vec2) (index int, drag penDrag, ok bool) {
+	near := func(v vec2) bool {
+		return math.Abs(v.X-position.X) <= float64(cropHandleSize)/2 && math.Abs(v.Y-position.Y) <= float64(cropHandleSize)/2
+	}
+
+	for i, anchor := range path.Anchors {
+		if anchor.Out != anchor.Point && near(anchor.Out) {
+			return i, penDragOut, true
+		}
+		if anchor.In != anchor.Point && near(anchor.In) {
+			return i, penDragIn, true
+		}
+	}
+	for i, anchor := range path.Anchors {
+		if near(anchor.Point) {
+			return i, penDragAnchor, true
+		}
+	}
+	return 0, penNoDrag, false
+}
+
+// pickUpShape starts editing the topmost vector shape of the active layer with an anchor under position.
+func pickUpShape(state *GemPaintState, position vec2) bool {
+	layer := activeLayer(state)
+	for i := len(layer.Shapes) - 1; i >= 0; i-- {
+		path := layer.Shapes[i].Path
+		if _, drag, ok := penHandleAt(path, position); !ok || drag != penDragAnchor {
+			continue
+		}
+
+		path.Anchors = slices.Clone(path.Anchors) // The layer's shape is shared with the undo history
+		state.penTool = PenTool{path: path, editedLayer: layer, editedIndex: i}
+		state.toolOptions.shapeMode.Value = string(layer.Shapes[i].Mode)
+		return true
+	}
+	return false
+}
+
+// editedShapeIsValid reports whether the pen is editing a shape that is still on the active layer.
+func editedShapeIsValid(state *GemPaintState) bool {
+	tool := &state.penTool
+	return tool.editedLayer != nil && tool.editedLayer == activeLayer(state) && tool.editedIndex < len(tool.editedLayer.Shapes)
+}
+
+// layersWithoutEditedShape returns the layers with the shape being edited left out of the active layer.
+func layersWithoutEditedShape(state *GemPaintState) []*Layer {
+	layers := slices.Clone(state.layers)
+
+	active := *activeLayer(state)
+	active.Shapes = slices.Delete(slices.Clone(active.Shapes), state.penTool.editedIndex, state.penTool.editedIndex+1)
+	layers[state.activeLayerIndex] = &active
+
+	return layers
+}
+
+// penShape is the path with how it will be painted: a shape being edited keeps its own color and width, a new
+// one uses the current ones. Both use the mode picked in the tool options.
+func penShape(state *GemPaintState) VectorShape {
+	if editedShapeIsValid(state) {
+		shape := state.penTool.editedLayer.Shapes[state.penTool.editedIndex]
+		shape.Path = state.penTool.path
+		shape.Mode = shapeModeValue(state)
+		return shape
+	}
+	return VectorShape{
+		Path:  state.penTool.path,
+		Mode:  shapeModeValue(state),
+		Color: state.paintColor,
+		Width: shapeWidth(state),
+	}
+}
+
+// paintPenPath paints the path onto the active layer. A shape that was being edited is replaced by its pixels.
+// On a vector or text layer the path is kept as a shape instead.
+func paintPenPath(state *GemPaintState) {
+	if len(state.penTool.path.Anchors) == 0 {
+		return
+	}
+	if activeLayer(state).Kind != RasterLayer { // It has no pixels to paint on
+		keepPenPath(state)
+		return
+	}
+
+	shape := penShape(state)
+	wasEdited := editedShapeIsValid(state)
+	commitShape(state, shape.coverage(state.document.Bounds()), shape.Color)
+
+	if wasEdited {
+		layer := activeLayer(state)
+		layer.Shapes = slices.Delete(slices.Clone(layer.Shapes), state.penTool.editedIndex, state.penTool.editedIndex+1)
+	}
+	cancelPen(state)
+}
+
+// keepPenPath stores the path as a vector shape on the active layer, where it can be edited again later.
+func keepPenPath(state *GemPaintState) {
+	if len(state.penTool.path.Anchors) < 2 { // A single anchor has nothing to show
+		cancelPen(state)
+		return
+	}
+
+	shape := penShape(state)
+	layer := activeLayer(state)
+	shapes := slices.Clone(layer.Shapes)
+	if editedShapeIsValid(state) {
+		shapes[state.penTool.editedIndex] = shape
+	} else {
+		shapes = append(shapes, shape)
+	}
+
+	state.history.Record(state)
+	layer.Shapes = shapes
+	cancelPen(state)
+}
+
+// cancelPen throws away the path. A shape that was being edited is left as it was.
+func cancelPen(state *GemPaintState) {
+	state.penTool = PenTool{}
+}
+
+// drawPenOverlay draws the path being drawn with its anchors and handles, and a line from the last anchor to
+// the pointer, where the next one will go.
+func drawPenOverlay(gtx layout.Context, state *GemPaintState) {
+	tool := &state.penTool
+	if state.selectedTool != Pen || len(tool.path.Anchors) == 0 {
+		return
+	}
+
+	drawVectorShape(gtx, penShape(state))
+	paint.FillShape(gtx.Ops, darkGray, clip.Stroke{Path: vectorPathSpec(gtx, tool.path), Width: 1}.Op())
+
+	toF32 := func(v vec2) f32.Point { return f32.Pt(float32(v.X), float32(v.Y)) }
+	if !tool.path.Closed && tool.drag == penNoDrag && state.mousePositionOnCanvas != mouseIsOutsideCanvas {
+		var rubberBand clip.Path
+		rubberBand.Begin(gtx.Ops)
+		rubberBand.MoveTo(toF32(tool.path.Anchors[len(tool.path.Anchors)-1].Point))
+		rubberBand.LineTo(state.view.toCanvas(state.mousePositionOnCanvas, state.document.Bounds().Size()))
+		paint.FillShape(gtx.Ops, darkGray, clip.Stroke{Path: rubberBand.End(), Width: 1}.Op())
+	}
+
+	for _, anchor := range tool.path.Anchors {
+		for _, handle := range []vec2{anchor.In, anchor.Out} {
+			if handle == anchor.Point {
+				continue
+			}
+			var line clip.Path
+			line.Begin(gtx.Ops)
+			line.MoveTo(toF32(anchor.Point))
+			line.LineTo(toF32(handle))
+			paint.FillShape(gtx.Ops, golangBlue, clip.Stroke{Path: line.End(), Width: 1}.Op())
+			drawPenHandle(gtx, handle, true)
+		}
+		drawPenHandle(gtx, anchor.Point, false)
+	}
+}
+
+// drawPenHandle draws an anchor as a square, or a handle as a circle, centered on center.
+func drawPenHandle(gtx layout.Context, center vec2, round bool) {
+	c := image.Point{X: int(math.Round(center.X)), Y: int(math.Round(center.Y))}
+	r := image.Rectangle{Min: c, Max: c}.Inset(-cropHandleSize / 2)
+
+	area := clip.Rect(r).Op()
+	if round {
+		area = clip.Ellipse(r).Op(gtx.Ops)
+	}
+	paint.FillShape(gtx.Ops, golangBlue, area)
+}
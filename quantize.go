@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+// generatePaletteFromImage makes a new palette of the count colors that represent the image best, and shows it in
+// the sidebar. Big images take a while, so the colors are picked as a job.
+func generatePaletteFromImage(state *GemPaintState, count int) {
+	img := flattenLayers(state.layers, state.document.Bounds()) // Flatten on the ui thread, while no one is painting
+
+	startJob(state, "Generating palette", func(ctx context.Context, reportProgress func(done float32)) (func(state *GemPaintState), error) {
+		colors, err := medianCutColors(ctx, img, count, reportProgress)
+		if err != nil {
+			return nil, err
+		}
+		if len(colors) == 0 {
+			return nil, errors.New("the image has no opaque pixels to take colors from")
+		}
+
+		return func(state *GemPaintState) {
+			palette := Palette{Name: fmt.Sprintf("Image Colors %d", len(state.palettes)+1)}
+			for _, c := range colors {
+				palette.Swatches = append(palette.Swatches, Swatch{Name: formatHexColor(c), Color: c})
+			}
+			state.palettes = append(state.palettes, palette)
+			state.activePaletteIndex = len(state.palettes) - 1
+			savePalettes(state)
+		}, nil
+	})
+}
+
+// maximumQuantizeSamples is how many pixels are looked at, at most, when picking the colors of an image. Big
+// images are sampled on a grid, which finds the same colors in a fraction of the time.
+const maximumQuantizeSamples = 1 << 18
+
+// colorBox is a group of similar pixels, as red, green and blue.
+type colorBox [][3]uint8
+
+// medianCutColors picks count colors that represent img, most common first. The pixels are split in two at the
+// median of the channel they vary the most in, box after box, until there are count boxes, and each box becomes
+// the average of its pixels. Transparent pixels are left out, so an image can have fewer colors than count.
+func medianCutColors(ctx context.Context, img *image.RGBA, count int, reportProgress func(done float32)) ([]color.NRGBA, error) {
+	bounds := img.Bounds()
+	step := max(int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/maximumQuantizeSamples)), 1)
+
+	var pixels colorBox
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.RGBAAt(x, y)).(color.NRGBA)
+			if c.A < 128 { // Mostly transparent pixels don't show what the image looks like
+				continue
+			}
+			pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil, nil
+	}
+
+	boxes := []colorBox{pixels}
+	for len(boxes) < count {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if reportProgress != nil {
+			reportProgress(float32(len(boxes)) / float32(count))
+		}
+
+		// Split the box whose pixels are the most spread out, weighed by how many there are, so that the colors
+		// that cover a lot of the image get the most boxes.
+		widest, widestScore := -1, 0
+		for i, box := range boxes {
+			_, spread := widestChannel(box)
+			if score := spread * len(box); spread > 0 && score > widestScore {
+				widest, widestScore = i, score
+			}
+		}
+		if widest < 0 { // Every box is a single color
+			break
+		}
+
+		box := boxes[widest]
+		channel, _ := widestChannel(box)
+		slices.SortFunc(box, func(a, b [3]uint8) int { return int(a[channel]) - int(b[channel]) })
+		split := medianSplit(box, channel)
+		boxes[widest] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	slices.SortStableFunc(boxes, func(a, b colorBox) int { return len(b) - len(a) })
+	colors := make([]color.NRGBA, 0, len(boxes))
+	for _, box := range boxes {
+		if c := box.average(); !slices.Contains(colors, c) {
+			colors = append(colors, c)
+		}
+	}
+	return colors, nil
+}
+
+// medianSplit returns where to split the sorted box in two, as close to its median as it can be without pixels
+// with the same value in channel ending up in both halves. Otherwise a big area of one color would be cut in
+// two and mixed with its neighbors.
+func medianSplit(box colorBox, channel int) int {
+	median := len(box) / 2
+	value := box[median][channel]
+	start, _ := slices.BinarySearchFunc(box, value, func(p [3]uint8, v uint8) int { return int(p[channel]) - int(v) })
+	end, _ := slices.BinarySearchFunc(box, value+1, func(p [3]uint8, v uint8) int { return int(p[channel]) - int(v) })
+	if value == 255 {
+		end = len(box)
+	}
+
+	switch {
+	case start == 0:
+		return end
+	case end == len(box):
+		return start
+	case median-start <= end-median:
+		return start
+	default:
+		return end
+	}
+}
+
+// widestChannel returns the channel that the pixels of box vary the most in, and by how much.
+func widestChannel(box colorBox) (channel, spread int) {
+	low, high := [3]uint8{255, 255, 255}, [3]uint8{}
+	for _, p := range box {
+		for i := range p {
+			low[i] = min(low[i], p[i])
+			high[i] = max(high[i], p[i])
+		}
+	}
+	for i := range low {
+		if s := int(high[i]) - int(low[i]); s > spread {
+			channel, spread = i, s
+		}
+	}
+	return channel, spread
+}
+
+func (box colorBox) average() color.NRGBA {
+	var sum [3]int
+	for _, p := range box {
+		for i := range p {
+			sum[i] += int(p[i])
+		}
+	}
+	n := len(box)
+	return color.NRGBA{R: uint8((sum[0] + n/2) / n), G: uint8((sum[1] + n/2) / n), B: uint8((sum[2] + n/2) / n), A: 255}
+}
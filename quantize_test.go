@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestMedianCutColors(t *testing.T) {
+	orange := color.NRGBA{R: 255, G: 136, A: 255}
+	blue := color.NRGBA{R: 18, G: 52, B: 86, A: 255}
+
+	oneColor := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	fillImageWithColor(oneColor, orange)
+
+	// Three quarters orange, one quarter blue, so orange comes first.
+	twoColors := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	fillImageWithColor(twoColors, orange)
+	fillImageWithColor(twoColors.SubImage(image.Rect(0, 48, 64, 64)).(*image.RGBA), blue)
+
+	// The transparent half is left out.
+	halfTransparent := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	fillImageWithColor(halfTransparent.SubImage(image.Rect(0, 0, 32, 64)).(*image.RGBA), blue)
+
+	tests := []struct {
+		name     string
+		img      *image.RGBA
+		count    int
+		expected []color.NRGBA
+	}{
+		{"one color", oneColor, 8, []color.NRGBA{orange}},
+		{"two colors", twoColors, 8, []color.NRGBA{orange, blue}},
+		{"two colors, one asked for", twoColors, 1, []color.NRGBA{{R: 196, G: 115, B: 22, A: 255}}},
+		{"half transparent", halfTransparent, 4, []color.NRGBA{blue}},
+		{"transparent", image.NewRGBA(image.Rect(0, 0, 16, 16)), 4, nil},
+	}
+	for _, test := range tests {
+		colors, err := medianCutColors(context.Background(), test.img, test.count, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(colors) == 0 && len(test.expected) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(colors, test.expected) {
+			t.Errorf("%s: picked %v, expected %v", test.name, colors, test.expected)
+		}
+	}
+}
+
+func TestMedianCutColorsIsCancelled(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		img.SetRGBA(x, 0, color.RGBA{R: uint8(x), A: 255})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := medianCutColors(ctx, img, 16, nil); err != context.Canceled {
+		t.Errorf("returned %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestMedianSplit(t *testing.T) {
+	box := func(values ...uint8) colorBox {
+		b := make(colorBox, len(values))
+		for i, v := range values {
+			b[i] = [3]uint8{v, 0, 0}
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		box      colorBox
+		expected int
+	}{
+		{"all different", box(1, 2, 3, 4), 2},
+		{"median in the middle of a run", box(1, 5, 5, 5, 5, 9), 1},
+		{"median at the start of a run", box(1, 2, 3, 7, 7, 9), 3},
+		{"median near the end of a run", box(1, 3, 3, 3, 8, 9, 9), 4},
+		{"run at the start", box(4, 4, 4, 4, 8, 9), 4},
+		{"run at the end", box(1, 2, 255, 255, 255), 2},
+	}
+	for _, test := range tests {
+		if split := medianSplit(test.box, 0); split != test.expected {
+			t.Errorf("%s: split at %d, expected %d", test.name, split, test.expected)
+		}
+	}
+}
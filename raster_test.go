@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"gioui.org/f32"
+)
+
+func TestPaintCircleSpansMatchesPixelByPixel(t *testing.T) {
+	positions := []image.Point{{X: 100, Y: 100}, {X: 0, Y: 0}, {X: 1919, Y: 5}, {X: 960, Y: 1079}, {X: -30, Y: 50}}
+	for _, radius := range []int{1, 2, 7, 10, 55, 100} {
+		for _, position := range positions {
+			expected := image.NewRGBA(defaultCanvasDimensions)
+			actual := image.NewRGBA(defaultCanvasDimensions)
+
+			paintCirclePixelByPixel(expected, position, radius, purple)
+			paintCircleSpans(actual, position, radius, purple)
+
+			if !bytes.Equal(expected.Pix, actual.Pix) {
+				t.Fatalf("span rasterizer differs for radius %d at %v", radius, position)
+			}
+		}
+	}
+}
+
+func BenchmarkPaintCirclePixelByPixel(b *testing.B) {
+	canvas := image.NewRGBA(defaultCanvasDimensions)
+	for i := 0; i < b.N; i++ {
+		paintCirclePixelByPixel(canvas, image.Point{X: 960, Y: 540}, maximumCursorRadius, red)
+	}
+}
+
+func BenchmarkPaintCircleSpans(b *testing.B) {
+	canvas := image.NewRGBA(defaultCanvasDimensions)
+	for i := 0; i < b.N; i++ {
+		paintCircleSpans(canvas, image.Point{X: 960, Y: 540}, maximumCursorRadius, red)
+	}
+}
+
+// Simulates a fast drag across the canvas with the largest brush, which interpolates a dab every radius/4 pixels.
+func BenchmarkInterpolatedStroke(b *testing.B) {
+	canvas := image.NewRGBA(defaultCanvasDimensions)
+	start, end := f32.Point{X: 100, Y: 100}, f32.Point{X: 1800, Y: 900}
+	for i := 0; i < b.N; i++ {
+		interpolatePaintBetweenPoints(start, end, canvas, maximumCursorRadius, red)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// ResampleFilter decides how the colors of the source pixels are blended when an image is resized or transformed.
+type ResampleFilter struct {
+	Name    string
+	Support float64 // How far, in source pixels, the kernel reaches from the sample point. 0 means nearest neighbor.
+	Kernel  func(x float64) float64
+}
+
+var NearestNeighbor = ResampleFilter{Name: "Nearest"}
+
+var Bilinear = ResampleFilter{
+	Name:    "Bilinear",
+	Support: 1,
+	Kernel: func(x float64) float64 {
+		return max(1-math.Abs(x), 0)
+	},
+}
+
+// Bicubic uses the Catmull-Rom spline, which keeps edges sharper than a B-spline.
+var Bicubic = ResampleFilter{
+	Name:    "Bicubic",
+	Support: 2,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		switch {
+		case x < 1:
+			return 1.5*x*x*x - 2.5*x*x + 1
+		case x < 2:
+			return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+		default:
+			return 0
+		}
+	},
+}
+
+var Lanczos = ResampleFilter{
+	Name:    "Lanczos",
+	Support: 3,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x >= 3 {
+			return 0
+		}
+		return sinc(x) * sinc(x/3)
+	},
+}
+
+var resampleFilters = []ResampleFilter{NearestNeighbor, Bilinear, Bicubic, Lanczos}
+
+func resampleFilterByName(name string) ResampleFilter {
+	for _, filter := range resampleFilters {
+		if filter.Name == name {
+			return filter
+		}
+	}
+	return Bilinear
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// contribution lists the source pixels, and how much each of them counts, for one destination pixel.
+type contribution struct {
+	indices []int
+	weights []float64
+}
+
+// computeContributions precomputes, for one axis, which source pixels make up each destination pixel.
+// When shrinking, the kernel is stretched so that every source pixel is taken into account.
+func computeContributions(sourceSize, destinationSize int, filter ResampleFilter) []contribution {
+	scale := float64(sourceSize) / float64(destinationSize)
+	filterScale := max(scale, 1)
+	support := filter.Support * filterScale
+
+	contributions := make([]contribution, destinationSize)
+	for i := range contributions {
+		center := (float64(i) + 0.5) * scale
+
+		start := int(math.Floor(center - support))
+		end := int(math.Ceil(center + support))
+
+		var c contribution
+		total := 0.0
+		for j := start; j <= end; j++ {
+			weight := filter.Kernel((float64(j) + 0.5 - center) / filterScale)
+			if weight == 0 {
+				continue
+			}
+
+			c.indices = append(c.indices, min(max(j, 0), sourceSize-1)) // Repeat the edge pixels
+			c.weights = append(c.weights, weight)
+			total += weight
+		}
+
+		if total != 0 {
+			for k := range c.weights {
+				c.weights[k] /= total
+			}
+		}
+
+		contributions[i] = c
+	}
+
+	return contributions
+}
+
+// resampleRGBA resizes img to width x height with the given filter. The rows are processed in parallel.
+func resampleRGBA(ctx context.Context, img *image.RGBA, width, height int, filter ResampleFilter, reportProgress func(done float32)) (*image.RGBA, error) {
+	if filter.Support == 0 {
+		return resampleNearest(ctx, img, width, height, reportProgress)
+	}
+
+	// Resize horizontally, then vertically. Each pass reports half of the progress.
+	var horizontalProgress, verticalProgress func(done float32)
+	if reportProgress != nil {
+		horizontalProgress = func(done float32) { reportProgress(done / 2) }
+		verticalProgress = func(done float32) { reportProgress(0.5 + done/2) }
+	}
+
+	horizontal := image.NewRGBA(image.Rect(0, 0, width, img.Rect.Dy()))
+	columns := computeContributions(img.Rect.Dx(), width, filter)
+	parallelBandsWithProgress(horizontal.Rect, func(band image.Rectangle) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := 0; x < width; x++ {
+				var pixel [4]float64
+				for k, sourceX := range columns[x].indices {
+					offset := img.PixOffset(img.Rect.Min.X+sourceX, img.Rect.Min.Y+y)
+					for channel := range pixel {
+						pixel[channel] += float64(img.Pix[offset+channel]) * columns[x].weights[k]
+					}
+				}
+				storeResampledPixel(horizontal, x, y, pixel)
+			}
+		}
+	}, horizontalProgress)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	rows := computeContributions(img.Rect.Dy(), height, filter)
+	parallelBandsWithProgress(resized.Rect, func(band image.Rectangle) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := 0; x < width; x++ {
+				var pixel [4]float64
+				for k, sourceY := range rows[y].indices {
+					offset := horizontal.PixOffset(x, sourceY)
+					for channel := range pixel {
+						pixel[channel] += float64(horizontal.Pix[offset+channel]) * rows[y].weights[k]
+					}
+				}
+				storeResampledPixel(resized, x, y, pixel)
+			}
+		}
+	}, verticalProgress)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return resized, nil
+}
+
+func resampleNearest(ctx context.Context, img *image.RGBA, width, height int, reportProgress func(done float32)) (*image.RGBA, error) {
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaleX := float64(img.Rect.Dx()) / float64(width)
+	scaleY := float64(img.Rect.Dy()) / float64(height)
+
+	parallelBandsWithProgress(resized.Rect, func(band image.Rectangle) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			sourceY := img.Rect.Min.Y + int((float64(y)+0.5)*scaleY)
+			for x := 0; x < width; x++ {
+				sourceX := img.Rect.Min.X + int((float64(x)+0.5)*scaleX)
+				source := img.PixOffset(sourceX, sourceY)
+				destination := resized.PixOffset(x, y)
+				copy(resized.Pix[destination:destination+4], img.Pix[source:source+4])
+			}
+		}
+	}, reportProgress)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return resized, nil
+}
+
+// storeResampledPixel rounds and clamps a filtered premultiplied pixel. Filters with negative lobes (bicubic,
+// lanczos) can overshoot, and premultiplied color channels must never be larger than alpha.
+func storeResampledPixel(img *image.RGBA, x, y int, pixel [4]float64) {
+	alpha := clampToByte(pixel[3])
+	offset := img.PixOffset(x, y)
+	img.Pix[offset+0] = min(clampToByte(pixel[0]), alpha)
+	img.Pix[offset+1] = min(clampToByte(pixel[1]), alpha)
+	img.Pix[offset+2] = min(clampToByte(pixel[2]), alpha)
+	img.Pix[offset+3] = alpha
+}
+
+func clampToByte(value float64) uint8 {
+	return uint8(min(max(math.Round(value), 0), 255))
+}
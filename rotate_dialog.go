@@ -0,0 +1,134 @@
+package main
+
+import (
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+const (
+	rotateWholeImage  = "Image"
+	rotateActiveLayer = "Layer"
+)
+
+// RotateDialog rotates and flips the whole image or only the active layer.
+type RotateDialog struct {
+	target widget.Enum
+
+	rotate90Button       widget.Clickable
+	rotate180Button      widget.Clickable
+	rotate270Button      widget.Clickable
+	flipHorizontalButton widget.Clickable
+	flipVerticalButton   widget.Clickable
+
+	angleEditor  widget.Editor
+	filter       widget.Enum
+	rotateButton widget.Clickable
+
+	closeButton widget.Clickable
+
+	errorMessage string
+}
+
+func newRotateDialog() *RotateDialog {
+	d := &RotateDialog{}
+	d.target.Value = rotateWholeImage
+	d.filter.Value = Bicubic.Name
+
+	d.angleEditor.SingleLine = true
+	d.angleEditor.Filter = "-.0123456789"
+	d.angleEditor.SetText("15")
+
+	return d
+}
+
+func (d *RotateDialog) Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	lossless := map[*widget.Clickable]losslessTransform{
+		&d.rotate90Button:       rotate90Transform,
+		&d.rotate180Button:      rotate180Transform,
+		&d.rotate270Button:      rotate270Transform,
+		&d.flipHorizontalButton: flipHorizontalTransform,
+		&d.flipVerticalButton:   flipVerticalTransform,
+	}
+
+	for button, transform := range lossless {
+		if !button.Clicked(gtx) {
+			continue
+		}
+
+		if d.target.Value == rotateWholeImage {
+			transformImageLosslessly(state, transform)
+		} else {
+			transformActiveLayer(state, transform)
+		}
+		closeDialog(state)
+	}
+
+	if d.rotateButton.Clicked(gtx) {
+		degrees, err := editorFloat(&d.angleEditor)
+		if err != nil {
+			d.errorMessage = "the angle must be a number of degrees"
+		} else {
+			rotateByAngle(state, degrees, resampleFilterByName(d.filter.Value), d.target.Value == rotateWholeImage)
+			closeDialog(state)
+		}
+	}
+
+	if d.closeButton.Clicked(gtx) {
+		closeDialog(state)
+	}
+
+	return layoutDialog(gtx, state, theme, "Rotate & Flip", func(gtx layout.Context) layout.Dimensions {
+		filters := make([]layout.FlexChild, 0, len(resampleFilters))
+		for _, filter := range resampleFilters {
+			filters = append(filters, layout.Rigid(material.RadioButton(theme, &d.filter, filter.Name, filter.Name).Layout))
+		}
+
+		button := func(clickable *widget.Clickable, label string) material.ButtonStyle {
+			b := material.Button(theme, clickable, label)
+			b.Background = golangBlue
+			return b
+		}
+
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(material.RadioButton(theme, &d.target, rotateWholeImage, "Whole image").Layout),
+					layout.Rigid(material.RadioButton(theme, &d.target, rotateActiveLayer, "Active layer").Layout),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme,
+					button(&d.rotate90Button, "90° ⟳"),
+					button(&d.rotate270Button, "90° ⟲"),
+					button(&d.rotate180Button, "180°"),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme,
+					button(&d.flipHorizontalButton, "Flip Horizontal"),
+					button(&d.flipVerticalButton, "Flip Vertical"),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Angle (°)", &d.angleEditor)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, filters...)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogError(gtx, theme, d.errorMessage)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				closeButton := material.Button(theme, &d.closeButton, "Close")
+				closeButton.Background = darkGray
+				return layoutDialogButtons(gtx, theme, closeButton, button(&d.rotateButton, "Rotate"))
+			}),
+		)
+	})
+}
@@ -3,28 +3,18 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"image/png"
 	"syscall/js"
 )
 
-func saveOnPlatform(state *GemPaintState, fileName string) {
+func saveFileOnPlatform(state *GemPaintState, data []byte, fileName string) {
 	if debug {
-		fmt.Println("Saving image on wasm/js")
+		fmt.Println("Saving file on wasm/js")
 	}
 
-	// Convert the image.RGBA to a JavaScript Uint8Array
-	buf := bytes.Buffer{}
-	if err := png.Encode(&buf, state.canvas); err != nil {
-		if debug {
-			fmt.Println("Error: ", err)
-		}
-		return
-	}
-
-	jsData := js.Global().Get("Uint8Array").New(buf.Len())
-	js.CopyBytesToJS(jsData, buf.Bytes())
+	// Convert the data to a JavaScript Uint8Array
+	jsData := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(jsData, data)
 
 	// Create a Blob from the data
 	blob := js.Global().Get("Blob").New([]interface{}{jsData})
@@ -4,10 +4,9 @@ package main
 
 import (
 	"fmt"
-	"image/png"
 )
 
-func saveOnPlatform(state *GemPaintState, fileName string) {
+func saveFileOnPlatform(state *GemPaintState, data []byte, fileName string) {
 	file, err := state.expl.CreateFile(fileName)
 	if err != nil {
 		if debug {
@@ -15,8 +14,9 @@ func saveOnPlatform(state *GemPaintState, fileName string) {
 		}
 		return
 	}
+	defer file.Close()
 
-	if err := png.Encode(file, state.canvas); err != nil {
+	if _, err := file.Write(data); err != nil {
 		if debug {
 			fmt.Println("Error: ", err)
 		}
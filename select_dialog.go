@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// SelectDialog holds the commands that change the selection as a whole: select all or none, invert, the
+// Modify commands, and saving and loading selections to named channels.
+type SelectDialog struct {
+	allButton    widget.Clickable
+	noneButton   widget.Clickable
+	invertButton widget.Clickable
+
+	featherEditor widget.Editor
+	featherButton widget.Clickable
+
+	pixelsEditor widget.Editor
+	growButton   widget.Clickable
+	shrinkButton widget.Clickable
+	borderButton widget.Clickable
+
+	channelNameEditor widget.Editor
+	saveButton        widget.Clickable
+	loadButtons       []widget.Clickable
+
+	closeButton widget.Clickable
+
+	errorMessage string
+}
+
+func newSelectDialog() *SelectDialog {
+	d := &SelectDialog{}
+
+	d.featherEditor.SingleLine = true
+	d.featherEditor.Filter = ".0123456789"
+	d.featherEditor.SetText("5")
+
+	d.pixelsEditor.SingleLine = true
+	d.pixelsEditor.Filter = "0123456789"
+	d.pixelsEditor.SetText("5")
+
+	d.channelNameEditor.SingleLine = true
+	d.channelNameEditor.SetText("Selection")
+
+	return d
+}
+
+func (d *SelectDialog) Layout(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	bounds := state.document.Bounds()
+
+	if d.allButton.Clicked(gtx) {
+		state.selection = selectAll(bounds)
+		closeDialog(state)
+	}
+
+	if d.noneButton.Clicked(gtx) {
+		state.selection = nil
+		closeDialog(state)
+	}
+
+	if d.invertButton.Clicked(gtx) {
+		state.selection = nonEmptySelection(invertSelection(state.selection, bounds))
+		closeDialog(state)
+	}
+
+	if d.featherButton.Clicked(gtx) {
+		radius, err := editorFloat(&d.featherEditor)
+		switch {
+		case err != nil || radius <= 0:
+			d.errorMessage = "the feather radius must be a positive number"
+		case state.selection == nil:
+			d.errorMessage = "nothing is selected"
+		default:
+			state.selection = nonEmptySelection(featherSelection(state.selection, radius))
+			closeDialog(state)
+		}
+	}
+
+	modify := map[*widget.Clickable]func(mask *image.Alpha, pixels int) *image.Alpha{
+		&d.growButton:   growSelection,
+		&d.shrinkButton: shrinkSelection,
+		&d.borderButton: borderSelection,
+	}
+	for button, modifySelection := range modify {
+		if !button.Clicked(gtx) {
+			continue
+		}
+
+		pixels, err := editorInt(&d.pixelsEditor)
+		switch {
+		case err != nil || pixels <= 0:
+			d.errorMessage = "the number of pixels must be a positive whole number"
+		case state.selection == nil:
+			d.errorMessage = "nothing is selected"
+		default:
+			state.selection = nonEmptySelection(modifySelection(state.selection, pixels))
+			closeDialog(state)
+		}
+	}
+
+	if d.saveButton.Clicked(gtx) {
+		name := strings.TrimSpace(d.channelNameEditor.Text())
+		switch {
+		case name == "":
+			d.errorMessage = "the channel needs a name"
+		case state.selection == nil:
+			d.errorMessage = "nothing is selected"
+		default:
+			state.selectionChannels = saveSelectionChannel(state.selectionChannels, name, state.selection)
+			if debug {
+				fmt.Println("Selection saved to channel: ", name)
+			}
+		}
+	}
+
+	for len(d.loadButtons) < len(state.selectionChannels) {
+		d.loadButtons = append(d.loadButtons, widget.Clickable{})
+	}
+	for i, channel := range state.selectionChannels {
+		if !d.loadButtons[i].Clicked(gtx) {
+			continue
+		}
+
+		if channel.Mask.Rect != bounds {
+			d.errorMessage = fmt.Sprintf("%q was saved when the image had a different size", channel.Name)
+			continue
+		}
+		state.selection = channel.Mask
+		closeDialog(state)
+	}
+
+	if d.closeButton.Clicked(gtx) {
+		closeDialog(state)
+	}
+
+	return layoutDialog(gtx, state, theme, "Select", func(gtx layout.Context) layout.Dimensions {
+		button := func(clickable *widget.Clickable, label string) material.ButtonStyle {
+			b := material.Button(theme, clickable, label)
+			b.Background = golangBlue
+			return b
+		}
+
+		loadButtons := make([]material.ButtonStyle, len(state.selectionChannels))
+		for i, channel := range state.selectionChannels {
+			loadButtons[i] = button(&d.loadButtons[i], channel.Name)
+		}
+
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme,
+					button(&d.allButton, "All"),
+					button(&d.noneButton, "None"),
+					button(&d.invertButton, "Invert"),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Radius (px)", &d.featherEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, button(&d.featherButton, "Feather"))
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Pixels", &d.pixelsEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme,
+					button(&d.growButton, "Grow"),
+					button(&d.shrinkButton, "Shrink"),
+					button(&d.borderButton, "Border"),
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutLabeledEditor(gtx, theme, "Channel", &d.channelNameEditor)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, button(&d.saveButton, "Save Selection"))
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if len(loadButtons) == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: 8}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+						layout.Rigid(material.Body2(theme, "Load a saved selection:").Layout),
+						layout.Rigid(layout.Spacer{Height: unit.Dp(4)}.Layout),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return layoutDialogButtons(gtx, theme, loadButtons...)
+						}),
+					)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogError(gtx, theme, d.errorMessage)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				closeButton := material.Button(theme, &d.closeButton, "Close")
+				closeButton.Background = darkGray
+				return layoutDialogButtons(gtx, theme, closeButton)
+			}),
+		)
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// A selection is stored as an *image.Alpha mask over the whole document: 255 is fully selected, 0 is not
+// selected, and anything in between is partially selected (eg. on antialiased or feathered edges).
+// A nil selection means that nothing is selected, in which case the tools work on the whole layer.
+
+type SelectionMode int
+
+const (
+	SelectionReplace SelectionMode = iota
+	SelectionAdd
+	SelectionSubtract
+	SelectionIntersect
+)
+
+// selectionSupersampling is how many samples per axis are taken for each pixel on the edge of an ellipse.
+const selectionSupersampling = 4
+
+func rectangleMask(bounds, rect image.Rectangle) *image.Alpha {
+	mask := image.NewAlpha(bounds)
+	rect = rect.Intersect(bounds)
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := mask.Pix[mask.PixOffset(rect.Min.X, y):mask.PixOffset(rect.Max.X, y)]
+		for i := range row {
+			row[i] = 255
+		}
+	}
+
+	return mask
+}
+
+// ellipseMask selects the ellipse inscribed in rect, with antialiased edges.
+func ellipseMask(bounds, rect image.Rectangle) *image.Alpha {
+	mask := image.NewAlpha(bounds)
+	if rect.Empty() {
+		return mask
+	}
+
+	centerX := float64(rect.Min.X+rect.Max.X) / 2
+	centerY := float64(rect.Min.Y+rect.Max.Y) / 2
+	radiusX := float64(rect.Dx()) / 2
+	radiusY := float64(rect.Dy()) / 2
+
+	inside := func(x, y float64) bool {
+		dx, dy := (x-centerX)/radiusX, (y-centerY)/radiusY
+		return dx*dx+dy*dy <= 1
+	}
+
+	area := rect.Intersect(bounds)
+	parallelBands(area, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				samples := 0
+				for sy := 0; sy < selectionSupersampling; sy++ {
+					for sx := 0; sx < selectionSupersampling; sx++ {
+						sampleX := float64(x) + (float64(sx)+0.5)/selectionSupersampling
+						sampleY := float64(y) + (float64(sy)+0.5)/selectionSupersampling
+						if inside(sampleX, sampleY) {
+							samples++
+						}
+					}
+				}
+				mask.Pix[mask.PixOffset(x, y)] = uint8(samples * 255 / (selectionSupersampling * selectionSupersampling))
+			}
+		}
+	})
+
+	return mask
+}
+
+// polygonMask selects the inside of the closed polygon through points, with antialiased edges. The points are
+// pixel positions, the polygon goes through the centers of those pixels.
+func polygonMask(bounds image.Rectangle, points []image.Point) *image.Alpha {
+	if len(points) < 3 {
+		return image.NewAlpha(bounds)
+	}
+
+	polygon := make([]vec2, len(points))
+	for i, p := range points {
+		polygon[i] = vec2{X: float64(p.X) + 0.5, Y: float64(p.Y) + 0.5}
+	}
+	return fillPolygonsCoverage(bounds, [][]vec2{polygon}, EvenOdd)
+}
+
+// magicWandMask selects the pixels whose color is within tolerance of the color at start. When contiguous is
+// set only the pixels connected to start are selected, like a bucket fill, otherwise all of them are.
+func magicWandMask(ctx context.Context, img *image.RGBA, start image.Point, tolerance int, contiguous bool, reportProgress func(done float32)) (*image.Alpha, error) {
+	mask := image.NewAlpha(img.Rect)
+	if !start.In(img.Rect) {
+		return mask, nil
+	}
+
+	offset := img.PixOffset(start.X, start.Y)
+	target := [4]uint8(img.Pix[offset : offset+4])
+	matches := func(x, y int) bool {
+		offset := img.PixOffset(x, y)
+		return pixelDistance([4]uint8(img.Pix[offset:offset+4]), target) <= tolerance
+	}
+
+	if !contiguous {
+		parallelBandsWithProgress(img.Rect, func(band image.Rectangle) {
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				for x := band.Min.X; x < band.Max.X; x++ {
+					if matches(x, y) {
+						mask.Pix[mask.PixOffset(x, y)] = 255
+					}
+				}
+			}
+		}, reportProgress)
+		return mask, ctx.Err()
+	}
+
+	selectPixel := func(x, y int) { mask.Pix[mask.PixOffset(x, y)] = 255 }
+	err := floodRegion(ctx, img.Rect, start, matches, selectPixel, reportProgress)
+	return mask, err
+}
+
+// pixelDistance is the largest difference between the channels of two pixels.
+func pixelDistance(a, b [4]uint8) int {
+	distance := 0
+	for i := range a {
+		distance = max(distance, int(a[i])-int(b[i]), int(b[i])-int(a[i]))
+	}
+	return distance
+}
+
+// combineSelection merges a newly drawn shape into the existing selection. existing may be nil.
+func combineSelection(existing, shape *image.Alpha, mode SelectionMode) *image.Alpha {
+	var combined *image.Alpha
+	switch {
+	case existing == nil && (mode == SelectionSubtract || mode == SelectionIntersect):
+		return nil // Subtracting from or intersecting with nothing leaves nothing
+	case existing == nil || mode == SelectionReplace:
+		combined = shape
+	default:
+		combined = image.NewAlpha(existing.Rect)
+		for i, a := range existing.Pix {
+			b := shape.Pix[i]
+			switch mode {
+			case SelectionAdd:
+				combined.Pix[i] = max(a, b)
+			case SelectionSubtract:
+				combined.Pix[i] = min(a, 255-b)
+			case SelectionIntersect:
+				combined.Pix[i] = min(a, b)
+			}
+		}
+	}
+
+	return nonEmptySelection(combined)
+}
+
+// nonEmptySelection returns nil instead of a mask where nothing is selected.
+func nonEmptySelection(mask *image.Alpha) *image.Alpha {
+	if selectionBounds(mask).Empty() {
+		return nil
+	}
+	return mask
+}
+
+// selectionBounds returns the smallest rectangle containing every selected pixel.
+func selectionBounds(mask *image.Alpha) image.Rectangle {
+	if mask == nil {
+		return image.Rectangle{}
+	}
+
+	bounds := image.Rectangle{Min: mask.Rect.Max, Max: mask.Rect.Min}
+	for y := mask.Rect.Min.Y; y < mask.Rect.Max.Y; y++ {
+		row := mask.Pix[mask.PixOffset(mask.Rect.Min.X, y):mask.PixOffset(mask.Rect.Max.X, y)]
+		for i, a := range row {
+			if a == 0 {
+				continue
+			}
+			x := mask.Rect.Min.X + i
+			bounds.Min.X, bounds.Max.X = min(bounds.Min.X, x), max(bounds.Max.X, x+1)
+			bounds.Min.Y, bounds.Max.Y = min(bounds.Min.Y, y), max(bounds.Max.Y, y+1)
+		}
+	}
+
+	if bounds.Empty() {
+		return image.Rectangle{}
+	}
+	return bounds
+}
+
+// maskChanges limits a change made to an image to the selection: inside rect, every pixel of after is blended
+// back towards before according to how much it is selected.
+func maskChanges(after, before *image.RGBA, mask *image.Alpha, rect image.Rectangle) {
+	rect = rect.Intersect(after.Rect).Intersect(mask.Rect)
+
+	parallelBands(rect, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				selected := mask.Pix[mask.PixOffset(x, y)]
+				if selected == 255 {
+					continue
+				}
+
+				offset := after.PixOffset(x, y)
+				for channel := 0; channel < 4; channel++ {
+					a, b := float64(after.Pix[offset+channel]), float64(before.Pix[offset+channel])
+					after.Pix[offset+channel] = uint8(math.Round(b + (a-b)*float64(selected)/255))
+				}
+			}
+		}
+	})
+}
+
+// maskTileChanges is maskChanges for tiled canvases. Only the tiles that the change touched are blended.
+func maskTileChanges(after, before *TiledCanvas, mask *image.Alpha, rect image.Rectangle) {
+	for _, i := range after.tilesIn(rect.Intersect(mask.Rect)) {
+		unchanged := after.tiles[i] == before.tiles[i] && (after.tiles[i] != nil || after.Solid == before.Solid)
+		if unchanged {
+			continue
+		}
+		maskChanges(after.writableTile(i), before.tileImage(i), mask, rect)
+	}
+}
+
+// selectionEdges returns the boundary of the selection as unit length segments between pixel corners. Pixels
+// that are at least half selected count as inside.
+func selectionEdges(mask *image.Alpha) [][2]image.Point {
+	isSelected := func(x, y int) bool {
+		if !(image.Point{X: x, Y: y}.In(mask.Rect)) {
+			return false
+		}
+		return mask.Pix[mask.PixOffset(x, y)] >= 128
+	}
+
+	var edges [][2]image.Point
+	for y := mask.Rect.Min.Y; y <= mask.Rect.Max.Y; y++ {
+		for x := mask.Rect.Min.X; x <= mask.Rect.Max.X; x++ {
+			current := isSelected(x, y)
+			if current != isSelected(x-1, y) { // Vertical edge on the left of the pixel
+				edges = append(edges, [2]image.Point{{X: x, Y: y}, {X: x, Y: y + 1}})
+			}
+			if current != isSelected(x, y-1) { // Horizontal edge above the pixel
+				edges = append(edges, [2]image.Point{{X: x, Y: y}, {X: x + 1, Y: y}})
+			}
+		}
+	}
+
+	return edges
+}
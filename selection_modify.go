@@ -0,0 +1,245 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// The Select > Modify commands. They only work on selection masks, and return a new mask rather than
+// changing the one they are given, so that the old mask can still be used, eg. by a history entry.
+
+func selectAll(bounds image.Rectangle) *image.Alpha {
+	return rectangleMask(bounds, bounds)
+}
+
+func invertSelection(mask *image.Alpha, bounds image.Rectangle) *image.Alpha {
+	if mask == nil {
+		return selectAll(bounds)
+	}
+
+	inverted := image.NewAlpha(mask.Rect)
+	for i, a := range mask.Pix {
+		inverted.Pix[i] = 255 - a
+	}
+	return inverted
+}
+
+// featherSelection softens the edges of the selection with a Gaussian blur whose standard deviation is radius.
+// The blur is approximated with three box blurs in each direction, which takes the same time for any radius.
+func featherSelection(mask *image.Alpha, radius float64) *image.Alpha {
+	width, height := mask.Rect.Dx(), mask.Rect.Dy()
+	values := make([]float32, len(mask.Pix))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			values[y*width+x] = float32(mask.Pix[y*mask.Stride+x])
+		}
+	}
+
+	for _, size := range gaussianBoxSizes(radius, 3) {
+		boxBlurRows(values, width, height, size/2)
+		values = transpose(values, width, height)
+		boxBlurRows(values, height, width, size/2)
+		values = transpose(values, height, width)
+	}
+
+	feathered := image.NewAlpha(mask.Rect)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			feathered.Pix[y*feathered.Stride+x] = uint8(min(max(math.Round(float64(values[y*width+x])), 0), 255))
+		}
+	}
+	return feathered
+}
+
+// gaussianBoxSizes returns the (odd) sizes of the passes of box blur that together best approximate a Gaussian
+// blur with the standard deviation sigma.
+func gaussianBoxSizes(sigma float64, passes int) []int {
+	n := float64(passes)
+	ideal := math.Sqrt(12*sigma*sigma/n + 1)
+	lower := int(math.Floor(ideal))
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+
+	l := float64(lower)
+	lowerPasses := int(math.Round((12*sigma*sigma - n*l*l - 4*n*l - 3*n) / (-4*l - 4)))
+
+	sizes := make([]int, passes)
+	for i := range sizes {
+		sizes[i] = upper
+		if i < lowerPasses {
+			sizes[i] = lower
+		}
+	}
+	return sizes
+}
+
+// boxBlurRows replaces every value with the average of the values within radius on its row. The values at
+// the ends of a row are repeated past the ends.
+func boxBlurRows(values []float32, width, height, radius int) {
+	if radius < 1 {
+		return
+	}
+
+	parallelBands(image.Rect(0, 0, width, height), func(band image.Rectangle) {
+		blurred := make([]float32, width)
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			row := values[y*width : (y+1)*width]
+			at := func(x int) float32 { return row[min(max(x, 0), width-1)] }
+
+			var sum float32
+			for x := -radius; x <= radius; x++ {
+				sum += at(x)
+			}
+			for x := range row {
+				blurred[x] = sum / float32(2*radius+1)
+				sum += at(x+radius+1) - at(x-radius)
+			}
+			copy(row, blurred)
+		}
+	})
+}
+
+func transpose(values []float32, width, height int) []float32 {
+	transposed := make([]float32, len(values))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			transposed[x*height+y] = values[y*width+x]
+		}
+	}
+	return transposed
+}
+
+// growSelection selects every pixel within radius of a selected pixel. Pixels that are at least half selected
+// count as selected, the grown selection has hard edges.
+func growSelection(mask *image.Alpha, radius int) *image.Alpha {
+	distances := squaredDistancesTo(mask, func(a uint8) bool { return a >= 128 })
+
+	grown := image.NewAlpha(mask.Rect)
+	for i, d := range distances {
+		if d <= float64(radius*radius) {
+			grown.Pix[i] = 255
+		}
+	}
+	return grown
+}
+
+// shrinkSelection deselects every pixel within radius of an unselected pixel. The edges of the canvas don't
+// count as unselected, so shrinking the whole canvas selected leaves it selected.
+func shrinkSelection(mask *image.Alpha, radius int) *image.Alpha {
+	distances := squaredDistancesTo(mask, func(a uint8) bool { return a < 128 })
+
+	shrunk := image.NewAlpha(mask.Rect)
+	for i, d := range distances {
+		if d > float64(radius*radius) {
+			shrunk.Pix[i] = 255
+		}
+	}
+	return shrunk
+}
+
+// borderSelection selects a band width pixels wide centered on the edge of the selection.
+func borderSelection(mask *image.Alpha, width int) *image.Alpha {
+	outer := growSelection(mask, (width+1)/2)
+	inner := shrinkSelection(mask, width/2)
+	return combineSelection(outer, inner, SelectionSubtract)
+}
+
+// squaredDistancesTo returns, for each pixel of the mask, the squared euclidean distance to the nearest pixel
+// for which isTarget is true, using the two pass distance transform of Felzenszwalb and Huttenlocher.
+// The mask must have a stride equal to its width, like all the masks created by this package.
+func squaredDistancesTo(mask *image.Alpha, isTarget func(a uint8) bool) []float64 {
+	width, height := mask.Rect.Dx(), mask.Rect.Dy()
+	infinity := math.Inf(1)
+
+	distances := make([]float64, width*height)
+	for i, a := range mask.Pix {
+		if isTarget(a) {
+			distances[i] = 0
+		} else {
+			distances[i] = infinity
+		}
+	}
+
+	// Columns first, then rows. The columns are split into bands as if they were rows.
+	parallelBands(image.Rect(0, 0, 1, width), func(band image.Rectangle) {
+		column := make([]float64, height)
+		for x := band.Min.Y; x < band.Max.Y; x++ {
+			for y := range column {
+				column[y] = distances[y*width+x]
+			}
+			distanceTransform1D(column)
+			for y, d := range column {
+				distances[y*width+x] = d
+			}
+		}
+	})
+	parallelBands(image.Rect(0, 0, width, height), func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			distanceTransform1D(distances[y*width : (y+1)*width])
+		}
+	})
+
+	return distances
+}
+
+// distanceTransform1D replaces f[q] with the minimum over p of (q-p)² + f[p], by finding the lower envelope of
+// the parabolas rooted at each p.
+func distanceTransform1D(f []float64) {
+	n := len(f)
+	sampled := make([]float64, n)
+	copy(sampled, f)
+
+	roots := make([]int, 0, n)            // Where the parabolas of the envelope are rooted
+	boundaries := make([]float64, 0, n+1) // Where each parabola of the envelope starts
+	for q := 0; q < n; q++ {
+		if math.IsInf(sampled[q], 1) {
+			continue
+		}
+		for len(roots) > 0 {
+			p := roots[len(roots)-1]
+			s := ((sampled[q] + float64(q*q)) - (sampled[p] + float64(p*p))) / float64(2*q-2*p)
+			if s > boundaries[len(boundaries)-1] {
+				boundaries = append(boundaries, s)
+				break
+			}
+			roots = roots[:len(roots)-1]
+			boundaries = boundaries[:len(boundaries)-1]
+		}
+		if len(roots) == 0 {
+			boundaries = append(boundaries[:0], math.Inf(-1))
+		}
+		roots = append(roots, q)
+	}
+
+	if len(roots) == 0 { // There is no target on this line
+		return
+	}
+
+	k := 0
+	for q := 0; q < n; q++ {
+		for k+1 < len(roots) && boundaries[k+1] < float64(q) {
+			k++
+		}
+		p := roots[k]
+		f[q] = float64((q-p)*(q-p)) + sampled[p]
+	}
+}
+
+// SelectionChannel is a selection saved under a name, so that it can be loaded again later.
+type SelectionChannel struct {
+	Name string
+	Mask *image.Alpha
+}
+
+// saveSelectionChannel saves mask under name, replacing the channel already saved under that name, if any.
+func saveSelectionChannel(channels []SelectionChannel, name string, mask *image.Alpha) []SelectionChannel {
+	for i := range channels {
+		if channels[i].Name == name {
+			channels[i].Mask = mask
+			return channels
+		}
+	}
+	return append(channels, SelectionChannel{Name: name, Mask: mask})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"gioui.org/f32"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// SelectionTool is the state of the selection tools while a shape is being drawn. The shape is merged into
+// the selection when the pointer is released, or when the polygon is closed.
+type SelectionTool struct {
+	dragging   bool
+	start, end image.Point
+	mode       SelectionMode
+
+	lassoPoints   []image.Point // The outline drawn so far with the lasso or the polygonal lasso
+	lastPressTime time.Duration // To tell double-clicks apart
+
+	// The outline of the selection is only traced again when the mask changes.
+	edgesMask *image.Alpha
+	edges     [][2]image.Point
+}
+
+var marchingAntsDash = 4
+var marchingAntsInterval = 100 * time.Millisecond
+
+// polygonCloseDistance is how close to its first point a click must be to close the polygonal lasso.
+var polygonCloseDistance = 6
+var doubleClickDuration = 300 * time.Millisecond
+
+// selectionModeFor picks how a new shape is merged into the selection from the modifier keys held when the
+// drag starts: Shift adds, Alt subtracts, and both intersect.
+func selectionModeFor(modifiers key.Modifiers) SelectionMode {
+	shift, alt := modifiers.Contain(key.ModShift), modifiers.Contain(key.ModAlt)
+	switch {
+	case shift && alt:
+		return SelectionIntersect
+	case shift:
+		return SelectionAdd
+	case alt:
+		return SelectionSubtract
+	default:
+		return SelectionReplace
+	}
+}
+
+func handleSelectionPointer(state *GemPaintState, p pointer.Event) {
+	switch state.selectedTool {
+	case RectangleSelect, EllipseSelect:
+		handleShapeSelectionPointer(state, p)
+	case Lasso:
+		handleLassoPointer(state, p)
+	case PolygonLasso:
+		handlePolygonLassoPointer(state, p)
+	case MagicWand:
+		handleMagicWandPointer(state, p)
+	}
+}
+
+func handleShapeSelectionPointer(state *GemPaintState, p pointer.Event) {
+	tool := &state.selectionTool
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		tool.dragging = true
+		tool.start, tool.end = position, position
+		tool.mode = selectionModeFor(p.Modifiers)
+
+	case pointer.Drag:
+		tool.end = position
+
+	case pointer.Release:
+		if !tool.dragging {
+			return
+		}
+		tool.dragging = false
+
+		rect := image.Rectangle{Min: tool.start, Max: tool.end}.Canon()
+		if rect.Empty() { // A click without a drag deselects
+			if tool.mode == SelectionReplace {
+				state.selection = nil
+			}
+			return
+		}
+
+		var shape *image.Alpha
+		if state.selectedTool == EllipseSelect {
+			shape = ellipseMask(state.document.Bounds(), rect)
+		} else {
+			shape = rectangleMask(state.document.Bounds(), rect)
+		}
+		state.selection = combineSelection(state.selection, shape, tool.mode)
+	}
+}
+
+// handleLassoPointer follows the pointer while it is dragged and selects the inside of the outline on release.
+func handleLassoPointer(state *GemPaintState, p pointer.Event) {
+	tool := &state.selectionTool
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		tool.dragging = true
+		tool.mode = selectionModeFor(p.Modifiers)
+		tool.lassoPoints = []image.Point{position}
+
+	case pointer.Drag:
+		if tool.lassoPoints[len(tool.lassoPoints)-1] != position {
+			tool.lassoPoints = append(tool.lassoPoints, position)
+		}
+
+	case pointer.Release:
+		if !tool.dragging {
+			return
+		}
+		tool.dragging = false
+		closeLasso(state)
+	}
+}
+
+// handlePolygonLassoPointer adds a corner on every click. The polygon is closed by clicking its first corner,
+// by double-clicking, or with Enter.
+func handlePolygonLassoPointer(state *GemPaintState, p pointer.Event) {
+	if p.Kind != pointer.Press {
+		return
+	}
+
+	tool := &state.selectionTool
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+	isDoubleClick := p.Time-tool.lastPressTime < doubleClickDuration
+	tool.lastPressTime = p.Time
+
+	if len(tool.lassoPoints) == 0 {
+		tool.mode = selectionModeFor(p.Modifiers)
+		tool.lassoPoints = []image.Point{position}
+		return
+	}
+
+	first := tool.lassoPoints[0]
+	closeToFirst := position.In(image.Rectangle{Min: first, Max: first}.Inset(-polygonCloseDistance))
+	if isDoubleClick || (closeToFirst && len(tool.lassoPoints) >= 3) {
+		closeLasso(state)
+		return
+	}
+
+	tool.lassoPoints = append(tool.lassoPoints, position)
+}
+
+// closeLasso selects the inside of the lasso outline. An outline too small to have an inside deselects, like
+// a click with the rectangle select tool.
+func closeLasso(state *GemPaintState) {
+	tool := &state.selectionTool
+	points := tool.lassoPoints
+	tool.lassoPoints = nil
+
+	if len(points) < 3 {
+		if tool.mode == SelectionReplace {
+			state.selection = nil
+		}
+		return
+	}
+
+	state.selection = combineSelection(state.selection, polygonMask(state.document.Bounds(), points), tool.mode)
+}
+
+// handleMagicWandPointer selects the area of similar color under the pointer. Finding it can take a while
+// on big canvases, so it runs as a job.
+func handleMagicWandPointer(state *GemPaintState, p pointer.Event) {
+	if p.Kind != pointer.Press {
+		return
+	}
+
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+	mode := selectionModeFor(p.Modifiers)
+	tolerance := state.toolOptions.wandToleranceValue()
+	contiguous := state.toolOptions.wandContiguous.Value
+	img := activeLayer(state).Image.RGBA()
+
+	startJob(state, "Selecting", func(ctx context.Context, reportProgress func(done float32)) (func(state *GemPaintState), error) {
+		mask, err := magicWandMask(ctx, img, position, tolerance, contiguous, reportProgress)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(state *GemPaintState) {
+			if mask.Rect != state.document.Bounds() {
+				return
+			}
+			state.selection = combineSelection(state.selection, mask, mode)
+		}, nil
+	})
+}
+
+// keepSelectionInBounds drops the selection when the document has changed size under it, eg. after a resize or undo.
+func keepSelectionInBounds(state *GemPaintState) {
+	if state.selection != nil && state.selection.Rect != state.document.Bounds() {
+		state.selection = nil
+	}
+}
+
+// cropToSelection crops every layer to the bounding box of the selection.
+func cropToSelection(state *GemPaintState) {
+	rect := selectionBounds(state.selection)
+	if rect.Empty() {
+		return
+	}
+
+	croppedSelection := image.NewAlpha(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(croppedSelection, croppedSelection.Rect, state.selection, rect.Min, draw.Src)
+
+	transformImage(state, func(img *image.RGBA) *image.RGBA {
+		return cropRGBA(img, rect)
+	}, translationMatrix(-float64(rect.Min.X), -float64(rect.Min.Y)))
+	state.selection = croppedSelection
+}
+
+// drawSelectionOverlay draws the outline of the selection as marching ants, and the shape being dragged out.
+func drawSelectionOverlay(gtx layout.Context, state *GemPaintState) {
+	tool := &state.selectionTool
+
+	if tool.dragging {
+		rect := image.Rectangle{Min: tool.start, Max: tool.end}.Canon()
+		if state.selectedTool == EllipseSelect {
+			paint.FillShape(gtx.Ops, darkGray, clip.Stroke{Path: clip.Ellipse(rect).Path(gtx.Ops), Width: 1}.Op())
+		} else {
+			strokeRect(gtx, rect, darkGray)
+		}
+	}
+
+	isLasso := state.selectedTool == Lasso || state.selectedTool == PolygonLasso
+	if isLasso && len(tool.lassoPoints) > 0 {
+		var outline clip.Path
+		outline.Begin(gtx.Ops)
+		outline.MoveTo(f32.Pt(float32(tool.lassoPoints[0].X)+0.5, float32(tool.lassoPoints[0].Y)+0.5))
+		for _, point := range tool.lassoPoints[1:] {
+			outline.LineTo(f32.Pt(float32(point.X)+0.5, float32(point.Y)+0.5))
+		}
+		if state.selectedTool == PolygonLasso && state.mousePositionOnCanvas != mouseIsOutsideCanvas { // Rubber band to the pointer
+			outline.LineTo(state.view.toCanvas(state.mousePositionOnCanvas, state.document.Bounds().Size()))
+		}
+		paint.FillShape(gtx.Ops, darkGray, clip.Stroke{Path: outline.End(), Width: 1}.Op())
+	}
+
+	if state.selection == nil {
+		return
+	}
+
+	if tool.edgesMask != state.selection {
+		tool.edgesMask = state.selection
+		tool.edges = selectionEdges(state.selection)
+	}
+
+	// Alternate black and white dashes along the outline, shifting them a little every frame.
+	phase := int(gtx.Now.UnixMilli() / marchingAntsInterval.Milliseconds())
+	for dash, col := range []color.NRGBA{{A: 255}, defaultCanvasColor} {
+		var path clip.Path
+		path.Begin(gtx.Ops)
+		for _, edge := range tool.edges {
+			if ((edge[0].X+edge[0].Y+phase)/marchingAntsDash)%2 != dash {
+				continue
+			}
+			path.MoveTo(f32.Pt(float32(edge[0].X), float32(edge[0].Y)))
+			path.LineTo(f32.Pt(float32(edge[1].X), float32(edge[1].Y)))
+		}
+		paint.FillShape(gtx.Ops, col, clip.Stroke{Path: path.End(), Width: 1}.Op())
+	}
+
+	gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(marchingAntsInterval)})
+}
+
+// startStroke gives the active layer its own clone of its canvas for a brush or eraser stroke to paint on, since
+// the undo history shares it. The pixels as they were are remembered, so that the stroke can be kept inside
+// the selection.
+func startStroke(state *GemPaintState) {
+	layer := activeLayer(state)
+	state.strokeBase = layer.Image
+	layer.Image = layer.Image.Clone()
+}
+
+// limitStrokeToSelection undoes the part of the last dab of a stroke that fell outside the selection.
+func limitStrokeToSelection(state *GemPaintState, p pointer.Event) {
+	if state.selection == nil || state.strokeBase == nil {
+		return
+	}
+
+	// Each point is a one pixel rectangle, since Union ignores empty ones.
+	position := image.Point{X: int(p.Position.X), Y: int(p.Position.Y)}
+	painted := image.Rectangle{Min: position, Max: position.Add(image.Pt(1, 1))}
+	if (p.Kind == pointer.Drag || continuesLastStroke(state, p)) && state.previousPaintPosition != mouseIsOutsideCanvas {
+		previous := image.Point{X: int(state.previousPaintPosition.X), Y: int(state.previousPaintPosition.Y)}
+		painted = painted.Union(image.Rectangle{Min: previous, Max: previous.Add(image.Pt(1, 1))})
+	}
+
+	maskTileChanges(activeLayer(state).Image, state.strokeBase, state.selection, painted.Inset(-state.cursorRadius-1))
+}
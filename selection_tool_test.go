@@ -0,0 +1,51 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"gioui.org/f32"
+	"gioui.org/io/pointer"
+)
+
+// Drags the brush and the eraser from inside the selection to far outside it, and checks that nothing outside
+// the selection was painted.
+func TestStrokeStaysInsideSelection(t *testing.T) {
+	bounds := image.Rect(0, 0, 600, 200)
+	selected := image.Rect(50, 50, 200, 150)
+	background := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	for _, tool := range []SelectedTool{Brush, Eraser} {
+		state := &GemPaintState{
+			selectedTool:          tool,
+			paintColor:            red,
+			cursorRadius:          20,
+			layers:                []*Layer{newLayer("Background", newTiledCanvas(bounds, background))},
+			selection:             rectangleMask(bounds, selected),
+			previousPaintPosition: mouseIsOutsideCanvas,
+		}
+
+		handlePaint(state, pointer.Event{Kind: pointer.Press, Buttons: pointer.ButtonPrimary, Position: f32.Pt(100, 100)})
+		for x := float32(150); x <= 500; x += 50 {
+			handlePaint(state, pointer.Event{Kind: pointer.Drag, Buttons: pointer.ButtonPrimary, Position: f32.Pt(x, 100)})
+		}
+		handleRelease(state, pointer.Event{Kind: pointer.Release, Position: f32.Pt(500, 100)})
+
+		painted := activeLayer(state).Image
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if (image.Point{X: x, Y: y}).In(selected) {
+					continue
+				}
+				if c := painted.RGBAAt(x, y); c != background {
+					t.Fatalf("%s painted %v at (%d, %d), outside the selection", tool, c, x, y)
+				}
+			}
+		}
+
+		if c := painted.RGBAAt(150, 100); c == background {
+			t.Errorf("%s didn't paint inside the selection", tool)
+		}
+	}
+}
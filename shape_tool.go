@@ -0,0 +1,316 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+
+	"gioui.org/f32"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// ShapeTool is the state of the shape tools while a shape is being drawn. Lines, rectangles and ellipses are
+// dragged out and painted on release, polygons are clicked corner by corner and painted when closed.
+type ShapeTool struct {
+	dragging   bool
+	start, end vec2
+
+	points        []vec2 // The corners of the polygon placed so far
+	lastPressTime time.Duration
+}
+
+type ShapeMode string
+
+const (
+	ShapeStroke        ShapeMode = "Stroke"
+	ShapeFill          ShapeMode = "Fill"
+	ShapeStrokeAndFill ShapeMode = "Stroke & Fill"
+)
+
+var shapeModes = []ShapeMode{ShapeStroke, ShapeFill, ShapeStrokeAndFill}
+
+// shapeWidth is how wide the outline of a shape is, the same as a brush stroke.
+func shapeWidth(state *GemPaintState) float64 {
+	return float64(2 * state.cursorRadius)
+}
+
+func handleShapePointer(state *GemPaintState, p pointer.Event) {
+	if state.selectedTool == PolygonShape {
+		handlePolygonShapePointer(state, p)
+		return
+	}
+
+	tool := &state.shapeTool
+	position := vec2{X: float64(p.Position.X), Y: float64(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		tool.dragging = true
+		tool.start, tool.end = position, position
+
+	case pointer.Drag:
+		if tool.dragging {
+			tool.end = constrainShape(state.selectedTool, tool.start, position, p.Modifiers)
+		}
+
+	case pointer.Release:
+		if !tool.dragging {
+			return
+		}
+		tool.dragging = false
+		tool.end = constrainShape(state.selectedTool, tool.start, position, p.Modifiers)
+
+		if tool.start == tool.end { // A click without a drag draws nothing
+			return
+		}
+		if activeLayer(state).Kind != RasterLayer {
+			addShapeToActiveLayer(state, vectorShapeFor(state, draggedShapePath(state.selectedTool, tool.start, tool.end)))
+			rememberColor(state, strokeColor(state))
+			return
+		}
+		commitShape(state, shapeCoverage(state, activeLayer(state).Image.Rect, tool.start, tool.end), strokeColor(state))
+	}
+}
+
+// handlePolygonShapePointer adds a corner on every click. Like the polygonal lasso, the polygon is closed by
+// clicking its first corner, by double-clicking, or with Enter.
+func handlePolygonShapePointer(state *GemPaintState, p pointer.Event) {
+	if p.Kind != pointer.Press {
+		return
+	}
+
+	tool := &state.shapeTool
+	position := vec2{X: float64(p.Position.X), Y: float64(p.Position.Y)}
+	isDoubleClick := p.Time-tool.lastPressTime < doubleClickDuration
+	tool.lastPressTime = p.Time
+
+	if len(tool.points) == 0 {
+		tool.points = []vec2{position}
+		return
+	}
+
+	position = constrainShape(Line, tool.points[len(tool.points)-1], position, p.Modifiers)
+
+	first := tool.points[0]
+	closeToFirst := math.Hypot(position.X-first.X, position.Y-first.Y) <= float64(polygonCloseDistance)
+	if isDoubleClick || (closeToFirst && len(tool.points) >= 3) {
+		closePolygonShape(state)
+		return
+	}
+
+	tool.points = append(tool.points, position)
+}
+
+// closePolygonShape paints the polygon. A polygon with less than three corners has no inside, so it is dropped.
+func closePolygonShape(state *GemPaintState) {
+	points := state.shapeTool.points
+	state.shapeTool.points = nil
+
+	if len(points) < 3 {
+		return
+	}
+	if activeLayer(state).Kind != RasterLayer {
+		path := VectorPath{Closed: true}
+		for _, point := range points {
+			path.Anchors = append(path.Anchors, cornerAnchor(point))
+		}
+		addShapeToActiveLayer(state, vectorShapeFor(state, path))
+		rememberColor(state, strokeColor(state))
+		return
+	}
+	commitShape(state, polygonShapeCoverage(activeLayer(state).Image.Rect, points, true, shapeModeValue(state), shapeWidth(state)), strokeColor(state))
+}
+
+// constrainShape keeps lines at multiples of 45°, and rectangles and ellipses square, while Shift is held.
+func constrainShape(tool SelectedTool, start, end vec2, modifiers key.Modifiers) vec2 {
+	if !modifiers.Contain(key.ModShift) {
+		return end
+	}
+
+	switch tool {
+	case Line:
+		return snapTo45Degrees(start, end)
+
+	case RectangleShape, EllipseShape:
+		dx, dy := end.X-start.X, end.Y-start.Y
+		side := max(math.Abs(dx), math.Abs(dy))
+		return vec2{X: start.X + math.Copysign(side, dx), Y: start.Y + math.Copysign(side, dy)}
+	}
+	return end
+}
+
+func shapeModeValue(state *GemPaintState) ShapeMode {
+	return ShapeMode(state.toolOptions.shapeMode.Value)
+}
+
+// shapeCoverage rasterizes the line, rectangle or ellipse dragged from start to end.
+func shapeCoverage(state *GemPaintState, bounds image.Rectangle, start, end vec2) *image.Alpha {
+	width := shapeWidth(state)
+	mode := shapeModeValue(state)
+
+	if state.selectedTool == Line {
+		coverage := image.NewAlpha(bounds)
+		strokePolylineCoverage(coverage, []vec2{start, end}, false, width)
+		return coverage
+	}
+
+	min := vec2{X: math.Min(start.X, end.X), Y: math.Min(start.Y, end.Y)}
+	max := vec2{X: math.Max(start.X, end.X), Y: math.Max(start.Y, end.Y)}
+	center := vec2{X: (min.X + max.X) / 2, Y: (min.Y + max.Y) / 2}
+	radiusX, radiusY := (max.X-min.X)/2, (max.Y-min.Y)/2
+
+	var fill []vec2
+	var outline [][]vec2
+	if state.selectedTool == EllipseShape {
+		fill = ellipsePolygon(center, radiusX, radiusY)
+		outline = ellipseOutline(center, radiusX, radiusY, width)
+	} else {
+		fill = rectanglePolygon(min, max)
+		outline = rectangleOutline(min, max, width)
+	}
+
+	switch mode {
+	case ShapeFill:
+		return fillPolygonsCoverage(bounds, [][]vec2{fill}, EvenOdd)
+	case ShapeStrokeAndFill:
+		coverage := fillPolygonsCoverage(bounds, [][]vec2{fill}, EvenOdd)
+		mergeCoverage(coverage, fillPolygonsCoverage(bounds, outline, EvenOdd))
+		return coverage
+	default:
+		return fillPolygonsCoverage(bounds, outline, EvenOdd)
+	}
+}
+
+// vectorShapeFor paints path with the current color, width and mode. Lines can only be stroked.
+func vectorShapeFor(state *GemPaintState, path VectorPath) VectorShape {
+	mode := shapeModeValue(state)
+	if state.selectedTool == Line {
+		mode = ShapeStroke
+	}
+	return VectorShape{Path: path, Mode: mode, Color: strokeColor(state), Width: shapeWidth(state)}
+}
+
+// draggedShapePath returns the line, rectangle or ellipse dragged from start to end as a path.
+func draggedShapePath(tool SelectedTool, start, end vec2) VectorPath {
+	switch tool {
+	case Line:
+		return VectorPath{Anchors: []PathAnchor{cornerAnchor(start), cornerAnchor(end)}}
+	case EllipseShape:
+		center := vec2{X: (start.X + end.X) / 2, Y: (start.Y + end.Y) / 2}
+		return ellipsePath(center, math.Abs(end.X-start.X)/2, math.Abs(end.Y-start.Y)/2)
+	}
+
+	path := VectorPath{Closed: true}
+	for _, corner := range rectanglePolygon(vec2{X: math.Min(start.X, end.X), Y: math.Min(start.Y, end.Y)}, vec2{X: math.Max(start.X, end.X), Y: math.Max(start.Y, end.Y)}) {
+		path.Anchors = append(path.Anchors, cornerAnchor(corner))
+	}
+	return path
+}
+
+// ellipseKappa places the handles of the four anchors of an ellipse so that the curves between them are as
+// close to quarters of the ellipse as cubic curves can be.
+const ellipseKappa = 0.5522847498
+
+func ellipsePath(center vec2, radiusX, radiusY float64) VectorPath {
+	kx, ky := radiusX*ellipseKappa, radiusY*ellipseKappa
+	cx, cy := center.X, center.Y
+	return VectorPath{Closed: true, Anchors: []PathAnchor{
+		{Point: vec2{X: cx + radiusX, Y: cy}, In: vec2{X: cx + radiusX, Y: cy - ky}, Out: vec2{X: cx + radiusX, Y: cy + ky}},
+		{Point: vec2{X: cx, Y: cy + radiusY}, In: vec2{X: cx + kx, Y: cy + radiusY}, Out: vec2{X: cx - kx, Y: cy + radiusY}},
+		{Point: vec2{X: cx - radiusX, Y: cy}, In: vec2{X: cx - radiusX, Y: cy + ky}, Out: vec2{X: cx - radiusX, Y: cy - ky}},
+		{Point: vec2{X: cx, Y: cy - radiusY}, In: vec2{X: cx - kx, Y: cy - radiusY}, Out: vec2{X: cx + kx, Y: cy - radiusY}},
+	}}
+}
+
+// polygonShapeCoverage rasterizes the polygon or polyline through points. An open polyline is filled as if it
+// were closed, like Gio and SVG do.
+func polygonShapeCoverage(bounds image.Rectangle, points []vec2, closed bool, mode ShapeMode, width float64) *image.Alpha {
+	coverage := image.NewAlpha(bounds)
+	if (mode == ShapeFill || mode == ShapeStrokeAndFill) && len(points) >= 3 {
+		coverage = fillPolygonsCoverage(bounds, [][]vec2{points}, NonZero)
+	}
+	if (mode == ShapeStroke || mode == ShapeStrokeAndFill) && len(points) > 0 {
+		strokePolylineCoverage(coverage, points, closed, width)
+	}
+	return coverage
+}
+
+// commitShape paints col through coverage onto the active layer as one undo step, keeping it inside the
+// selection if there is one.
+func commitShape(state *GemPaintState, coverage *image.Alpha, col color.NRGBA) {
+	original := activeLayer(state).Image
+	painted := original.Clone()
+	painted.DrawMask(painted.Rect, image.NewUniform(col), image.Point{}, coverage, coverage.Rect.Min, draw.Over)
+
+	if state.selection != nil {
+		maskTileChanges(painted, original, state.selection, painted.Rect)
+	}
+	commitLayerChange(state, painted)
+	rememberColor(state, col)
+}
+
+// drawShapeOverlay draws the shape being drawn in the current color, with the pointer as its next corner
+// when it is a polygon.
+func drawShapeOverlay(gtx layout.Context, state *GemPaintState) {
+	tool := &state.shapeTool
+	col := strokeColor(state)
+	width := shapeWidth(state)
+
+	switch state.selectedTool {
+	case Line, RectangleShape, EllipseShape:
+		if !tool.dragging || tool.start == tool.end {
+			return
+		}
+		if state.selectedTool == Line {
+			drawShapePath(gtx, []vec2{tool.start, tool.end}, false, ShapeStroke, width, col)
+			return
+		}
+
+		min := vec2{X: math.Min(tool.start.X, tool.end.X), Y: math.Min(tool.start.Y, tool.end.Y)}
+		max := vec2{X: math.Max(tool.start.X, tool.end.X), Y: math.Max(tool.start.Y, tool.end.Y)}
+		shape := rectanglePolygon(min, max)
+		if state.selectedTool == EllipseShape {
+			shape = ellipsePolygon(vec2{X: (min.X + max.X) / 2, Y: (min.Y + max.Y) / 2}, (max.X-min.X)/2, (max.Y-min.Y)/2)
+		}
+		drawShapePath(gtx, shape, true, shapeModeValue(state), width, col)
+
+	case PolygonShape:
+		if len(tool.points) == 0 {
+			return
+		}
+		points := tool.points
+		if state.mousePositionOnCanvas != mouseIsOutsideCanvas { // Rubber band to the pointer
+			cursor := state.view.toCanvas(state.mousePositionOnCanvas, state.document.Bounds().Size())
+			points = append(points[:len(points):len(points)], vec2{X: float64(cursor.X), Y: float64(cursor.Y)})
+		}
+		drawShapePath(gtx, points, len(points) >= 3, shapeModeValue(state), width, col)
+	}
+}
+
+func drawShapePath(gtx layout.Context, points []vec2, closed bool, mode ShapeMode, width float64, col color.NRGBA) {
+	path := func() clip.PathSpec {
+		var path clip.Path
+		path.Begin(gtx.Ops)
+		path.MoveTo(f32.Pt(float32(points[0].X), float32(points[0].Y)))
+		for _, p := range points[1:] {
+			path.LineTo(f32.Pt(float32(p.X), float32(p.Y)))
+		}
+		if closed {
+			path.Close()
+		}
+		return path.End()
+	}
+
+	if closed && (mode == ShapeFill || mode == ShapeStrokeAndFill) {
+		paint.FillShape(gtx.Ops, col, clip.Outline{Path: path()}.Op())
+	}
+	if mode == ShapeStroke || mode == ShapeStrokeAndFill || !closed { // An open polygon has nothing to fill yet
+		paint.FillShape(gtx.Ops, col, clip.Stroke{Path: path(), Width: float32(width)}.Op())
+	}
+}
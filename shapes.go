@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cmp"
+	"image"
+	"math"
+	"slices"
+)
+
+// Antialiased rasterization of shapes into coverage masks: 255 where a pixel is completely covered by the
+// shape, 0 where it isn't touched, and in between on the edges. Coordinates are continuous, pixel (x, y)
+// covers the square from (x, y) to (x+1, y+1).
+
+type vec2 struct {
+	X, Y float64
+}
+
+// shapeSupersampling is how many samples per axis are taken for each pixel on the edge of a stroke.
+const shapeSupersampling = 4
+
+// FillRule decides which parts of overlapping or self-intersecting polygons are inside.
+type FillRule int
+
+const (
+	// EvenOdd makes a polygon inside another one cut a hole in it. That is how outlines, like the stroke of a
+	// rectangle, are drawn.
+	EvenOdd FillRule = iota
+	// NonZero fills everything that the polygons go around, like Gio and SVG do by default.
+	NonZero
+)
+
+// fillPolygonsCoverage fills the polygons, using rule where they overlap.
+func fillPolygonsCoverage(bounds image.Rectangle, polygons [][]vec2, rule FillRule) *image.Alpha {
+	coverage := image.NewAlpha(bounds)
+
+	area := image.Rectangle{}
+	for _, polygon := range polygons {
+		for _, p := range polygon {
+			pixel := image.Point{X: int(math.Floor(p.X)), Y: int(math.Floor(p.Y))}
+			area = area.Union(image.Rectangle{Min: pixel, Max: pixel.Add(image.Point{X: 1, Y: 1})})
+		}
+	}
+	area = area.Intersect(bounds)
+
+	parallelBands(area, func(band image.Rectangle) {
+		rowCoverage := make([]float64, band.Dx())
+		var crossings []edgeCrossing
+
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			clear(rowCoverage)
+
+			// Each row of pixels is sampled on a few horizontal lines. On each line the polygons cover the spans
+			// between some of the edge crossings, which are added to the pixels they overlap.
+			for sample := 0; sample < shapeSupersampling; sample++ {
+				sampleY := float64(y) + (float64(sample)+0.5)/shapeSupersampling
+
+				crossings = crossings[:0]
+				for _, polygon := range polygons {
+					for i := range polygon {
+						a, b := polygon[i], polygon[(i+1)%len(polygon)]
+						if (a.Y <= sampleY) == (b.Y <= sampleY) {
+							continue
+						}
+						direction := 1
+						if b.Y < a.Y {
+							direction = -1
+						}
+						crossings = append(crossings, edgeCrossing{x: a.X + (sampleY-a.Y)/(b.Y-a.Y)*(b.X-a.X), direction: direction})
+					}
+				}
+				slices.SortFunc(crossings, func(a, b edgeCrossing) int { return cmp.Compare(a.x, b.x) })
+
+				winding, spanStart := 0, 0.0
+				for _, crossing := range crossings {
+					wasInside := rule.isInside(winding)
+					winding += crossing.direction
+					isInside := rule.isInside(winding)
+
+					switch {
+					case isInside && !wasInside:
+						spanStart = crossing.x
+					case wasInside && !isInside:
+						left := max(spanStart, float64(band.Min.X))
+						right := min(crossing.x, float64(band.Max.X))
+						for x := int(math.Floor(left)); float64(x) < right; x++ {
+							rowCoverage[x-band.Min.X] += min(right, float64(x+1)) - max(left, float64(x))
+						}
+					}
+				}
+			}
+
+			row := coverage.Pix[coverage.PixOffset(band.Min.X, y):coverage.PixOffset(band.Max.X, y)]
+			for i, c := range rowCoverage {
+				row[i] = uint8(math.Round(min(c/shapeSupersampling, 1) * 255))
+			}
+		}
+	})
+
+	return coverage
+}
+
+type edgeCrossing struct {
+	x         float64
+	direction int // 1 where the edge goes down, -1 where it goes up
+}
+
+func (rule FillRule) isInside(winding int) bool {
+	if rule == NonZero {
+		return winding != 0
+	}
+	return winding%2 != 0
+}
+
+// strokePolylineCoverage draws the lines between points, width wide with round ends and joints, onto coverage.
+// Where lines overlap the most covered value is kept, so joints aren't darker than the lines.
+func strokePolylineCoverage(coverage *image.Alpha, points []vec2, closed bool, width float64) {
+	radius := width / 2
+	segments := len(points) - 1
+	if closed {
+		segments = len(points)
+	}
+	if len(points) == 1 { // A dot
+		segments = 1
+	}
+
+	for i := 0; i < segments; i++ {
+		a, b := points[i], points[(i+1)%len(points)]
+		area := image.Rect(
+			int(math.Floor(min(a.X, b.X)-radius)), int(math.Floor(min(a.Y, b.Y)-radius)),
+			int(math.Ceil(max(a.X, b.X)+radius)), int(math.Ceil(max(a.Y, b.Y)+radius)),
+		).Intersect(coverage.Rect)
+
+		parallelBands(area, func(band image.Rectangle) {
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				for x := band.Min.X; x < band.Max.X; x++ {
+					covered := capsuleCoverage(float64(x), float64(y), a, b, radius)
+					offset := coverage.PixOffset(x, y)
+					coverage.Pix[offset] = max(coverage.Pix[offset], covered)
+				}
+			}
+		})
+	}
+}
+
+// capsuleCoverage is how much of the pixel at (x, y) is within radius of the segment from a to b. Only pixels on
+// the edge of the capsule are supersampled.
+func capsuleCoverage(x, y float64, a, b vec2, radius float64) uint8 {
+	distance := segmentDistance(vec2{X: x + 0.5, Y: y + 0.5}, a, b) - radius
+	switch {
+	case distance <= -0.75: // Half the diagonal of a pixel, so the whole pixel is inside
+		return 255
+	case distance >= 0.75:
+		return 0
+	}
+
+	samples := 0
+	for sy := 0; sy < shapeSupersampling; sy++ {
+		for sx := 0; sx < shapeSupersampling; sx++ {
+			sample := vec2{X: x + (float64(sx)+0.5)/shapeSupersampling, Y: y + (float64(sy)+0.5)/shapeSupersampling}
+			if segmentDistance(sample, a, b) <= radius {
+				samples++
+			}
+		}
+	}
+	return uint8(samples * 255 / (shapeSupersampling * shapeSupersampling))
+}
+
+// segmentDistance returns the distance from p to the closest point of the segment from a to b.
+func segmentDistance(p, a, b vec2) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := 0.0
+	if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+		t = min(max(((p.X-a.X)*dx+(p.Y-a.Y)*dy)/lengthSquared, 0), 1)
+	}
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
+
+// snapTo45Degrees moves end onto the nearest line from start that is horizontal, vertical or diagonal.
+func snapTo45Degrees(start, end vec2) vec2 {
+	dx, dy := end.X-start.X, end.Y-start.Y
+	angle := math.Round(math.Atan2(dy, dx)/(math.Pi/4)) * (math.Pi / 4)
+	length := dx*math.Cos(angle) + dy*math.Sin(angle) // The distance along the snapped direction
+	return vec2{X: start.X + length*math.Cos(angle), Y: start.Y + length*math.Sin(angle)}
+}
+
+func rectanglePolygon(min, max vec2) []vec2 {
+	return []vec2{min, {X: max.X, Y: min.Y}, max, {X: min.X, Y: max.Y}}
+}
+
+// ellipsePolygon approximates an ellipse with enough sides that they can't be told apart from the curve.
+func ellipsePolygon(center vec2, radiusX, radiusY float64) []vec2 {
+	sides := int(min(max(math.Ceil(math.Pi*(radiusX+radiusY)/2), 16), 4096))
+
+	polygon := make([]vec2, sides)
+	for i := range polygon {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(sides))
+		polygon[i] = vec2{X: center.X + radiusX*cos, Y: center.Y + radiusY*sin}
+	}
+	return polygon
+}
+
+// rectangleOutline returns the polygons that make up the stroke of the rectangle from min to max, width wide and
+// centered on its edges.
+func rectangleOutline(min, max vec2, width float64) [][]vec2 {
+	half := width / 2
+	outer := rectanglePolygon(vec2{X: min.X - half, Y: min.Y - half}, vec2{X: max.X + half, Y: max.Y + half})
+	if max.X-min.X <= width || max.Y-min.Y <= width { // Too small to have a hole
+		return [][]vec2{outer}
+	}
+	inner := rectanglePolygon(vec2{X: min.X + half, Y: min.Y + half}, vec2{X: max.X - half, Y: max.Y - half})
+	return [][]vec2{outer, inner}
+}
+
+// ellipseOutline returns the polygons that make up the stroke of an ellipse, width wide and centered on its edge.
+func ellipseOutline(center vec2, radiusX, radiusY, width float64) [][]vec2 {
+	half := width / 2
+	outer := ellipsePolygon(center, radiusX+half, radiusY+half)
+	if radiusX <= half || radiusY <= half {
+		return [][]vec2{outer}
+	}
+	return [][]vec2{outer, ellipsePolygon(center, radiusX-half, radiusY-half)}
+}
+
+// mergeCoverage adds the coverage of from onto into, keeping the most covered value of each pixel.
+func mergeCoverage(into, from *image.Alpha) {
+	for i, a := range from.Pix {
+		into.Pix[i] = max(into.Pix[i], a)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	giofont "gioui.org/font"
+	"gioui.org/font/gofont"
+	"gioui.org/font/opentype"
+	"github.com/go-text/typesetting/di"
+	"github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/language"
+	"github.com/go-text/typesetting/opentype/api"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/image/math/fixed"
+)
+
+// Text is set with the same shaping stack that Gio uses for the ui, and its glyph outlines are rasterized like
+// the shapes are, so the text looks the same on the canvas as it does when exported.
+
+// TextObject is the text of a text layer, with how it is set.
+type TextObject struct {
+	Content     string
+	Font        string  // The name of a TextFont
+	Size        float64 // In pixels per em
+	Color       color.NRGBA
+	Alignment   TextAlignment
+	LineSpacing float64 // A multiple of the font's line height
+	Position    vec2    // The top left corner of the text
+}
+
+// TextAlignment lines up the lines of a text with each other.
+type TextAlignment string
+
+const (
+	AlignLeft   TextAlignment = "Left"
+	AlignCenter TextAlignment = "Center"
+	AlignRight  TextAlignment = "Right"
+)
+
+var textAlignments = []TextAlignment{AlignLeft, AlignCenter, AlignRight}
+
+var defaultTextFont = "Go"
+var defaultTextSize = 48.0
+var defaultTextLineSpacing = 1.0
+
+// TextFont is a font face that text can be set in.
+type TextFont struct {
+	Name string
+	Face font.Face // Not safe for concurrent use, so text is only set on the ui goroutine
+}
+
+// builtInFonts returns the Go fonts that come with Gio.
+func builtInFonts() []TextFont {
+	var fonts []TextFont
+	for _, face := range gofont.Collection() {
+		fonts = append(fonts, TextFont{Name: fontName(face.Font), Face: face.Face.Face()})
+	}
+	return fonts
+}
+
+// parseFonts reads the faces of a TTF or OTF file, or of a collection of them.
+func parseFonts(data []byte) ([]TextFont, error) {
+	faces, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the font: %w", err)
+	}
+
+	fonts := make([]TextFont, len(faces))
+	for i, face := range faces {
+		fonts[i] = TextFont{Name: fontName(face.Font), Face: face.Face.Face()}
+	}
+	return fonts, nil
+}
+
+// fontName names a face by its family, with its weight and style unless they are the usual ones or already in
+// the family's name, eg. "Go SemiBold Italic" but "Go Medium".
+func fontName(f giofont.Font) string {
+	name := string(f.Typeface)
+	if weight := f.Weight.String(); f.Weight != giofont.Normal && !strings.Contains(name, weight) {
+		name += " " + weight
+	}
+	if f.Style == giofont.Italic {
+		name += " Italic"
+	}
+	return name
+}
+
+// addFonts adds fonts to the list, replacing the ones with the same name.
+func addFonts(fonts []TextFont, added []TextFont) []TextFont {
+	for _, f := range added {
+		if i := fontIndex(fonts, f.Name); i >= 0 {
+			fonts[i] = f
+			continue
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts
+}
+
+// fontIndex returns the index of the font called name, or -1 if there is none.
+func fontIndex(fonts []TextFont, name string) int {
+	for i, f := range fonts {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// findFont returns the font called name. A font that isn't loaded anymore falls back to the first one.
+func findFont(fonts []TextFont, name string) TextFont {
+	if i := fontIndex(fonts, name); i >= 0 {
+		return fonts[i]
+	}
+	return fonts[0]
+}
+
+// textLine is a line of text set in a font, with its glyph outlines relative to the start of its baseline.
+type textLine struct {
+	outlines [][]vec2
+	width    float64
+}
+
+// setText shapes the lines of the text, and returns the outlines of their glyphs in canvas coordinates, one
+// list per line, and the box that the text takes up.
+func setText(t TextObject, face font.Face) (lines [][][]vec2, box image.Rectangle) {
+	extents, _ := face.FontHExtents()
+	scale := t.Size / float64(face.Upem())
+	ascent := float64(extents.Ascender) * scale
+	lineHeight := float64(extents.Ascender-extents.Descender+extents.LineGap) * scale * t.LineSpacing
+
+	var shaper shaping.HarfbuzzShaper
+	var set []textLine
+	boxWidth := 0.0
+	for _, line := range strings.Split(t.Content, "\n") {
+		l := shapeLine(&shaper, face, []rune(line), t.Size)
+		set = append(set, l)
+		boxWidth = max(boxWidth, l.width)
+	}
+
+	for i, l := range set {
+		x := t.Position.X
+		switch t.Alignment {
+		case AlignCenter:
+			x += (boxWidth - l.width) / 2
+		case AlignRight:
+			x += boxWidth - l.width
+		}
+		baseline := t.Position.Y + ascent + float64(i)*lineHeight
+
+		for _, outline := range l.outlines {
+			for j := range outline {
+				outline[j].X += x
+				outline[j].Y += baseline
+			}
+		}
+		lines = append(lines, l.outlines)
+	}
+
+	// The box is at least a little wide, so that empty text can still be seen and clicked.
+	height := lineHeight*float64(len(set)-1) + float64(extents.Ascender-extents.Descender)*scale
+	box = image.Rect(
+		int(math.Floor(t.Position.X)), int(math.Floor(t.Position.Y)),
+		int(math.Ceil(t.Position.X+max(boxWidth, t.Size/2))), int(math.Ceil(t.Position.Y+height)),
+	)
+	return lines, box
+}
+
+// shapeLine turns a line of text into glyphs, and the glyphs into their outlines. The outlines of TrueType and
+// OpenType fonts are curves, which are flattened into polygons.
+func shapeLine(shaper *shaping.HarfbuzzShaper, face font.Face, runes []rune, size float64) textLine {
+	if len(runes) == 0 {
+		return textLine{}
+	}
+
+	output := shaper.Shape(shaping.Input{
+		Text:      runes,
+		RunStart:  0,
+		RunEnd:    len(runes),
+		Direction: di.DirectionLTR,
+		Face:      face,
+		Size:      fixed.Int26_6(math.Round(size * 64)),
+		Script:    lineScript(runes),
+		Language:  language.DefaultLanguage(),
+	})
+
+	scale := size / float64(face.Upem())
+	fromFixed := func(v fixed.Int26_6) float64 { return float64(v) / 64 }
+
+	var line textLine
+	dot := 0.0
+	for _, glyph := range output.Glyphs {
+		outline, ok := face.GlyphData(glyph.GlyphID).(api.GlyphOutline)
+		if ok {
+			originX, originY := dot+fromFixed(glyph.XOffset), -fromFixed(glyph.YOffset)
+			toCanvas := func(p api.SegmentPoint) vec2 { // Font units have y going up
+				return vec2{X: originX + float64(p.X)*scale, Y: originY - float64(p.Y)*scale}
+			}
+			line.outlines = append(line.outlines, flattenOutline(outline, toCanvas)...)
+		}
+		dot += fromFixed(glyph.XAdvance)
+	}
+	line.width = dot
+	return line
+}
+
+// lineScript guesses the writing system of a line from its first letter that belongs to one.
+func lineScript(runes []rune) language.Script {
+	for _, r := range runes {
+		if script := language.LookupScript(r); script != language.Common && script != language.Inherited && script != language.Unknown {
+			return script
+		}
+	}
+	return language.Latin
+}
+
+// flattenOutline turns the contours of a glyph into polygons.
+func flattenOutline(outline api.GlyphOutline, toCanvas func(p api.SegmentPoint) vec2) [][]vec2 {
+	var polygons [][]vec2
+	var contour []vec2
+	for _, segment := range outline.Segments {
+		switch segment.Op {
+		case api.SegmentOpMoveTo:
+			if len(contour) >= 3 {
+				polygons = append(polygons, contour)
+			}
+			contour = []vec2{toCanvas(segment.Args[0])}
+
+		case api.SegmentOpLineTo:
+			contour = append(contour, toCanvas(segment.Args[0]))
+
+		case api.SegmentOpQuadTo:
+			start, control, end := contour[len(contour)-1], toCanvas(segment.Args[0]), toCanvas(segment.Args[1])
+			// The same curve as a cubic one, with its control points two thirds of the way to the quadratic one.
+			cubic := [4]vec2{
+				start,
+				{X: start.X + 2*(control.X-start.X)/3, Y: start.Y + 2*(control.Y-start.Y)/3},
+				{X: end.X + 2*(control.X-end.X)/3, Y: end.Y + 2*(control.Y-end.Y)/3},
+				end,
+			}
+			contour = appendCurve(contour, cubic)
+
+		case api.SegmentOpCubeTo:
+			cubic := [4]vec2{contour[len(contour)-1], toCanvas(segment.Args[0]), toCanvas(segment.Args[1]), toCanvas(segment.Args[2])}
+			contour = appendCurve(contour, cubic)
+		}
+	}
+	if len(contour) >= 3 {
+		polygons = append(polygons, contour)
+	}
+	return polygons
+}
+
+// appendCurve adds points along the curve to polygon, without its start, which is already in it.
+func appendCurve(polygon []vec2, s [4]vec2) []vec2 {
+	length := distance(s[0], s[1]) + distance(s[1], s[2]) + distance(s[2], s[3])
+	steps := int(min(max(math.Ceil(length), 1), 64))
+	for i := 1; i <= steps; i++ {
+		polygon = append(polygon, cubicBezierPoint(s, float64(i)/float64(steps)))
+	}
+	return polygon
+}
+
+// renderText draws the text onto a transparent canvas with bounds. Each line is rasterized on its own, since the
+// fill goes over every edge of the outlines for every row.
+func renderText(t TextObject, face font.Face, bounds image.Rectangle) *TiledCanvas {
+	img := newTiledCanvas(bounds, transparent)
+	lines, _ := setText(t, face)
+	for _, outlines := range lines {
+		area := image.Rectangle{}
+		for _, outline := range outlines {
+			for _, p := range outline {
+				pixel := image.Point{X: int(math.Floor(p.X)), Y: int(math.Floor(p.Y))}
+				area = area.Union(image.Rectangle{Min: pixel, Max: pixel.Add(image.Point{X: 1, Y: 1})})
+			}
+		}
+		area = area.Intersect(bounds)
+		if area.Empty() {
+			continue
+		}
+
+		// Glyphs are drawn with the non-zero rule, so overlapping contours don't cut holes in each other.
+		coverage := fillPolygonsCoverage(area, outlines, NonZero)
+		img.DrawMask(area, image.NewUniform(t.Color), image.Point{}, coverage, area.Min, draw.Over)
+	}
+	return img
+}
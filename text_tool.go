@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+	"strings"
+
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+)
+
+// TextTool is the state of the text tool while a text layer is edited. The text is typed in the tool options,
+// and the layer is set again whenever the text or how it is set changes.
+type TextTool struct {
+	layer *Layer // The text layer being edited, nil when none is
+
+	position      vec2
+	color         color.NRGBA
+	lastSeenColor color.NRGBA // The current color when editing started, so that picking another one recolors the text
+	focusEditor   bool        // Set when editing starts, so that typing goes to the text
+
+	moving              bool
+	moveStart           vec2
+	positionAtMoveStart vec2
+}
+
+// handleTextPointer starts editing the text layer that was clicked, or a new one where the click was. Dragging
+// the text being edited moves it.
+func handleTextPointer(state *GemPaintState, p pointer.Event) {
+	tool := &state.textTool
+	position := vec2{X: float64(p.Position.X), Y: float64(p.Position.Y)}
+
+	switch p.Kind {
+	case pointer.Press:
+		if tool.layer == nil || !pointInRect(position, textBox(state, tool.layer.Text)) {
+			finishText(state)
+			if index := textLayerAt(state, position); index >= 0 {
+				editTextLayer(state, index)
+			} else {
+				newText(state, position)
+				return
+			}
+		}
+		tool.moving = true
+		tool.moveStart = position
+		tool.positionAtMoveStart = tool.position
+
+	case pointer.Drag:
+		if tool.moving {
+			tool.position = vec2{X: tool.positionAtMoveStart.X + position.X - tool.moveStart.X, Y: tool.positionAtMoveStart.Y + position.Y - tool.moveStart.Y}
+		}
+
+	case pointer.Release:
+		tool.moving = false
+	}
+}
+
+// textLayerAt returns the index of the topmost visible text layer whose text is under position, or -1.
+func textLayerAt(state *GemPaintState, position vec2) int {
+	for i := len(state.layers) - 1; i >= 0; i-- {
+		layer := state.layers[i]
+		if layer.Visible && layer.Kind == TextLayer && pointInRect(position, textBox(state, layer.Text)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func pointInRect(p vec2, r image.Rectangle) bool {
+	return p.X >= float64(r.Min.X) && p.X < float64(r.Max.X) && p.Y >= float64(r.Min.Y) && p.Y < float64(r.Max.Y)
+}
+
+// textBox returns the area that the text takes up on the canvas.
+func textBox(state *GemPaintState, t TextObject) image.Rectangle {
+	_, box := setText(t, findFont(state.fonts, t.Font).Face)
+	return box
+}
+
+// newText adds an empty text layer with its top left corner at position, and starts editing it.
+func newText(state *GemPaintState, position vec2) {
+	options := &state.toolOptions
+	text := TextObject{
+		Font:        options.textFont,
+		Size:        options.textSize,
+		Color:       state.paintColor,
+		Alignment:   TextAlignment(options.textAlignment.Value),
+		LineSpacing: options.textLineSpacing,
+		Position:    position,
+	}
+	insertLayer(state, newTextLayer(fmt.Sprintf("Text %d", len(state.layers)+1), state.document.Bounds(), text))
+	rememberColor(state, text.Color)
+	startEditingText(state, activeLayer(state))
+}
+
+// editTextLayer starts editing the text layer at index as one undo step.
+func editTextLayer(state *GemPaintState, index int) {
+	state.history.Record(state)
+	state.activeLayerIndex = index
+	startEditingText(state, activeLayer(state))
+}
+
+func startEditingText(state *GemPaintState, layer *Layer) {
+	state.textTool = TextTool{
+		layer:         layer,
+		position:      layer.Text.Position,
+		color:         layer.Text.Color,
+		lastSeenColor: state.paintColor,
+		focusEditor:   true,
+	}
+
+	options := &state.toolOptions
+	options.textEditor.SetText(layer.Text.Content)
+	options.textFont = layer.Text.Font
+	options.textSize = layer.Text.Size
+	options.textLineSpacing = layer.Text.LineSpacing
+	options.textAlignment.Value = string(layer.Text.Alignment)
+}
+
+// editedText returns the text as it is typed and set in the tool options.
+func editedText(state *GemPaintState) TextObject {
+	options := &state.toolOptions
+	return TextObject{
+		Content:     options.textEditor.Text(),
+		Font:        options.textFont,
+		Size:        options.textSize,
+		Color:       state.textTool.color,
+		Alignment:   TextAlignment(options.textAlignment.Value),
+		LineSpacing: options.textLineSpacing,
+		Position:    state.textTool.position,
+	}
+}
+
+// updateEditedText sets the text of the layer being edited again if it changed, and reports whether it did.
+// Picking another color while editing recolors the text.
+func updateEditedText(state *GemPaintState) bool {
+	tool := &state.textTool
+	if tool.layer == nil || state.job != nil {
+		return false
+	}
+
+	if current := state.paintColor; current != tool.lastSeenColor {
+		tool.color = current
+		tool.lastSeenColor = current
+	}
+
+	text := editedText(state)
+	if text == tool.layer.Text {
+		return false
+	}
+	tool.layer.Text = text
+	tool.layer.Image = renderText(text, findFont(state.fonts, text.Font).Face, state.document.Bounds())
+	return true
+}
+
+// finishText stops editing the text layer. A text that was left empty is removed along with its layer.
+func finishText(state *GemPaintState) {
+	layer := state.textTool.layer
+	state.textTool = TextTool{}
+	if layer == nil || strings.TrimSpace(layer.Text.Content) != "" {
+		return
+	}
+
+	index := slices.Index(state.layers, layer)
+	if index < 0 || len(state.layers) == 1 {
+		return
+	}
+	state.layers = slices.Delete(slices.Clone(state.layers), index, index+1)
+	if state.activeLayerIndex >= index {
+		state.activeLayerIndex = max(state.activeLayerIndex-1, 0)
+	}
+}
+
+// cancelText stops editing the text layer without touching it, eg. when the layers were undone.
+func cancelText(state *GemPaintState) {
+	state.textTool = TextTool{}
+}
+
+// rasterizeEditedText finishes editing the text and turns its layer into pixels that can be painted on.
+func rasterizeEditedText(state *GemPaintState) {
+	layer := state.textTool.layer
+	finishText(state)
+	if layer != nil && activeLayer(state) == layer {
+		rasterizeActiveLayer(state)
+	}
+}
+
+// loadFont lets the user pick a TTF or OTF file. Its fonts arrive in state.loadedFonts, since the file picker
+// blocks until a file is picked.
+func loadFont(state *GemPaintState) {
+	go func() {
+		file, err := state.expl.ChooseFile(".ttf", ".otf", ".ttc", ".otc")
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+		fonts, err := parseFonts(data)
+		if err != nil {
+			if debug {
+				fmt.Println("Error: ", err)
+			}
+			return
+		}
+
+		state.loadedFonts <- fonts
+		state.window.Invalidate()
+	}()
+}
+
+// receiveLoadedFonts adds the fonts that were loaded to the ones text can be set in, and picks the first of them.
+func receiveLoadedFonts(state *GemPaintState) {
+	select {
+	case fonts := <-state.loadedFonts:
+		state.fonts = addFonts(state.fonts, fonts)
+		state.toolOptions.textFont = fonts[0].Name
+		if debug {
+			fmt.Println("Loaded font: ", fonts[0].Name)
+		}
+	default:
+	}
+}
+
+// drawTextOverlay outlines the text being edited.
+func drawTextOverlay(gtx layout.Context, state *GemPaintState) {
+	if state.selectedTool != Text || state.textTool.layer == nil {
+		return
+	}
+
+	strokeRect(gtx, textBox(state, editedText(state)).Inset(-2), darkGray)
+}
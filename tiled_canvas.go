@@ -0,0 +1,265 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// tileSize is the width and height of the tiles of a TiledCanvas, in pixels.
+const tileSize = 256
+
+// TiledCanvas is an image split into square tiles, which are only allocated once they are painted on. The tiles
+// that were never painted share one solid color, so a blank print sized layer takes a few kilobytes instead of
+// hundreds of megabytes. It implements draw.Image, so routines like paintCircle and floodFill work on it as is.
+type TiledCanvas struct {
+	Rect  image.Rectangle
+	Solid color.RGBA // The color of every tile that isn't allocated
+
+	columns  int
+	tiles    []*image.RGBA    // Row by row, nil until the tile is painted on
+	shared   []bool           // Tiles that a clone uses too, which are copied before they are painted on
+	imageOps []*paint.ImageOp // The tiles as last drawn by drawTiles, dropped when a tile changes
+}
+
+func newTiledCanvas(bounds image.Rectangle, solid color.Color) *TiledCanvas {
+	columns := (bounds.Dx() + tileSize - 1) / tileSize
+	rows := (bounds.Dy() + tileSize - 1) / tileSize
+	return &TiledCanvas{
+		Rect:     bounds,
+		Solid:    color.RGBAModel.Convert(solid).(color.RGBA),
+		columns:  columns,
+		tiles:    make([]*image.RGBA, columns*rows),
+		shared:   make([]bool, columns*rows),
+		imageOps: make([]*paint.ImageOp, columns*rows),
+	}
+}
+
+// tiledCanvasFromRGBA copies img into a tiled canvas. The color of its top left pixel becomes the solid color,
+// and only the tiles with other colors in them are allocated.
+func tiledCanvasFromRGBA(img *image.RGBA) *TiledCanvas {
+	var solid color.RGBA
+	if !img.Rect.Empty() {
+		solid = img.RGBAAt(img.Rect.Min.X, img.Rect.Min.Y)
+	}
+	canvas := newTiledCanvas(img.Rect, solid)
+
+	pixel := rgbaPixel(solid)
+	for i := range canvas.tiles {
+		r := canvas.tileRect(i)
+		if rectIsSolid(img, r, pixel) {
+			continue
+		}
+		tile := image.NewRGBA(r)
+		draw.Draw(tile, r, img, r.Min, draw.Src)
+		canvas.tiles[i] = tile
+	}
+	return canvas
+}
+
+// rectIsSolid reports whether every pixel of img inside r is pixel.
+func rectIsSolid(img *image.RGBA, r image.Rectangle, pixel [4]uint8) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y) : img.PixOffset(r.Max.X-1, y)+4]
+		for i := 0; i < len(row); i += 4 {
+			if [4]uint8(row[i:i+4]) != pixel {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *TiledCanvas) ColorModel() color.Model { return color.RGBAModel }
+
+func (c *TiledCanvas) Bounds() image.Rectangle { return c.Rect }
+
+func (c *TiledCanvas) At(x, y int) color.Color { return c.RGBAAt(x, y) }
+
+func (c *TiledCanvas) RGBAAt(x, y int) color.RGBA {
+	if !(image.Point{X: x, Y: y}.In(c.Rect)) {
+		return color.RGBA{}
+	}
+	tile := c.tiles[c.tileIndex(x, y)]
+	if tile == nil {
+		return c.Solid
+	}
+	return tile.RGBAAt(x, y)
+}
+
+func (c *TiledCanvas) Set(x, y int, col color.Color) {
+	c.SetRGBA(x, y, color.RGBAModel.Convert(col).(color.RGBA))
+}
+
+func (c *TiledCanvas) SetRGBA(x, y int, col color.RGBA) {
+	if !(image.Point{X: x, Y: y}.In(c.Rect)) {
+		return
+	}
+	i := c.tileIndex(x, y)
+	if c.tiles[i] == nil && col == c.Solid { // Already that color
+		return
+	}
+	c.writableTile(i).SetRGBA(x, y, col)
+}
+
+// allocatedTiles returns how many of the tiles have their own pixels.
+func (c *TiledCanvas) allocatedTiles() int {
+	count := 0
+	for _, tile := range c.tiles {
+		if tile != nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *TiledCanvas) tileIndex(x, y int) int {
+	return (y-c.Rect.Min.Y)/tileSize*c.columns + (x-c.Rect.Min.X)/tileSize
+}
+
+// tileRect returns the part of the canvas covered by tile i. The tiles on the right and bottom edges can be smaller.
+func (c *TiledCanvas) tileRect(i int) image.Rectangle {
+	origin := c.Rect.Min.Add(image.Point{X: i % c.columns * tileSize, Y: i / c.columns * tileSize})
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Point{X: tileSize, Y: tileSize})}.Intersect(c.Rect)
+}
+
+// tilesIn returns the indices of the tiles that overlap r.
+func (c *TiledCanvas) tilesIn(r image.Rectangle) []int {
+	r = r.Intersect(c.Rect)
+	if r.Empty() {
+		return nil
+	}
+
+	var indices []int
+	first, last := c.tileIndex(r.Min.X, r.Min.Y), c.tileIndex(r.Max.X-1, r.Max.Y-1)
+	for row := first / c.columns; row <= last/c.columns; row++ {
+		for column := first % c.columns; column <= last%c.columns; column++ {
+			indices = append(indices, row*c.columns+column)
+		}
+	}
+	return indices
+}
+
+// writableTile returns tile i to be painted on. A tile that isn't allocated yet is filled with the solid color,
+// and one that is shared with a clone is copied first.
+func (c *TiledCanvas) writableTile(i int) *image.RGBA {
+	tile := c.tiles[i]
+	switch {
+	case tile == nil:
+		tile = image.NewRGBA(c.tileRect(i))
+		if c.Solid != (color.RGBA{}) { // A new image.RGBA is already transparent
+			fillSpan(tile.Pix, rgbaPixel(c.Solid))
+		}
+	case c.shared[i]:
+		tile = cloneRGBA(tile)
+	}
+
+	c.tiles[i] = tile
+	c.shared[i] = false
+	c.imageOps[i] = nil
+	return tile
+}
+
+// tileImage returns tile i to be read, or an image of the solid color in its place when it isn't allocated.
+func (c *TiledCanvas) tileImage(i int) *image.RGBA {
+	if tile := c.tiles[i]; tile != nil {
+		return tile
+	}
+	tile := image.NewRGBA(c.tileRect(i))
+	fillSpan(tile.Pix, rgbaPixel(c.Solid))
+	return tile
+}
+
+// Clone returns a copy of the canvas. The tiles are shared until either canvas paints on them, so cloning
+// doesn't copy any pixels.
+func (c *TiledCanvas) Clone() *TiledCanvas {
+	clone := newTiledCanvas(c.Rect, c.Solid)
+	copy(clone.tiles, c.tiles)
+	for i, tile := range c.tiles {
+		if tile != nil {
+			c.shared[i] = true
+			clone.shared[i] = true
+		}
+	}
+	return clone
+}
+
+// RGBA copies the canvas into a dense image, for the operations that work on all of it at once.
+func (c *TiledCanvas) RGBA() *image.RGBA {
+	img := image.NewRGBA(c.Rect)
+	c.drawInto(img, draw.Src)
+	return img
+}
+
+// drawInto draws the canvas onto dst with op, where they overlap.
+func (c *TiledCanvas) drawInto(dst draw.Image, op draw.Op) {
+	for _, i := range c.tilesIn(dst.Bounds()) {
+		r := c.tileRect(i).Intersect(dst.Bounds())
+		if tile := c.tiles[i]; tile != nil {
+			draw.Draw(dst, r, tile, r.Min, op)
+		} else if op == draw.Src || c.Solid.A != 0 {
+			draw.Draw(dst, r, image.NewUniform(c.Solid), image.Point{}, op)
+		}
+	}
+}
+
+// Draw is draw.Draw onto the canvas, done tile by tile.
+func (c *TiledCanvas) Draw(r image.Rectangle, src image.Image, sp image.Point, op draw.Op) {
+	c.DrawMask(r, src, sp, nil, image.Point{}, op)
+}
+
+// DrawMask is draw.DrawMask onto the canvas, done tile by tile. When drawing over, the tiles where an *image.Alpha
+// mask is empty are left alone, so that drawing through a mask as big as the canvas doesn't allocate every tile.
+func (c *TiledCanvas) DrawMask(r image.Rectangle, src image.Image, sp image.Point, mask image.Image, mp image.Point, op draw.Op) {
+	for _, i := range c.tilesIn(r) {
+		part := c.tileRect(i).Intersect(r)
+		offset := part.Min.Sub(r.Min)
+		if alpha, ok := mask.(*image.Alpha); ok && op == draw.Over && alphaIsEmpty(alpha, part.Add(mp.Sub(r.Min))) {
+			continue
+		}
+		draw.DrawMask(c.writableTile(i), part, src, sp.Add(offset), mask, mp.Add(offset), op)
+	}
+}
+
+// alphaIsEmpty reports whether mask is zero everywhere inside r.
+func alphaIsEmpty(mask *image.Alpha, r image.Rectangle) bool {
+	r = r.Intersect(mask.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for _, a := range mask.Pix[mask.PixOffset(r.Min.X, y):mask.PixOffset(r.Max.X, y)] {
+			if a != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// drawTiles draws the canvas with Gio. The allocated tiles are uploaded once and kept until they change, and
+// the others are filled with the solid color.
+func drawTiles(gtx layout.Context, c *TiledCanvas) {
+	for i, tile := range c.tiles {
+		r := c.tileRect(i)
+		if tile == nil {
+			if c.Solid.A != 0 {
+				paint.FillShape(gtx.Ops, color.NRGBAModel.Convert(c.Solid).(color.NRGBA), clip.Rect(r).Op())
+			}
+			continue
+		}
+
+		if c.imageOps[i] == nil {
+			imageOp := paint.NewImageOp(tile)
+			c.imageOps[i] = &imageOp
+		}
+		offset := op.Offset(r.Min).Push(gtx.Ops)
+		area := clip.Rect{Max: r.Size()}.Push(gtx.Ops)
+		c.imageOps[i].Add(gtx.Ops)
+		paint.PaintOp{}.Add(gtx.Ops)
+		area.Pop()
+		offset.Pop()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"gioui.org/f32"
+)
+
+var (
+	opaqueWhite = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	opaqueRed   = color.RGBA{R: 255, A: 255}
+	opaqueBlue  = color.RGBA{B: 255, A: 255}
+)
+
+// A print sized canvas starts without any tiles, and painting on it only allocates the tiles under the paint.
+func TestTiledCanvasAllocatesTilesLazily(t *testing.T) {
+	canvas := newTiledCanvas(image.Rect(0, 0, 12000, 8000), opaqueWhite)
+	if n := canvas.allocatedTiles(); n != 0 {
+		t.Fatalf("a new canvas has %d tiles, expected none", n)
+	}
+	if c := canvas.RGBAAt(11999, 7999); c != opaqueWhite {
+		t.Errorf("an untouched pixel is %v, expected %v", c, opaqueWhite)
+	}
+
+	tests := []struct {
+		name     string
+		paint    func()
+		expected int
+	}{
+		{"the solid color", func() { paintCircle(canvas, image.Pt(6000, 4000), 50, opaqueWhite) }, 0},
+		{"inside one tile", func() { paintCircle(canvas, image.Pt(300, 300), 10, red) }, 1},
+		{"across a tile corner", func() { paintCircle(canvas, image.Pt(tileSize*3, tileSize*3), 20, red) }, 5},
+		{"a single pixel", func() { canvas.Set(11999, 7999, red) }, 6},
+		{"the solid color on an allocated tile", func() { canvas.Set(11998, 7999, opaqueWhite) }, 6},
+	}
+	for _, test := range tests {
+		test.paint()
+		if n := canvas.allocatedTiles(); n != test.expected {
+			t.Errorf("after painting %s the canvas has %d tiles, expected %d", test.name, n, test.expected)
+		}
+	}
+}
+
+// Painting on a tiled canvas must give the same pixels as painting on a dense image.
+func TestTiledCanvasPaintsLikeRGBA(t *testing.T) {
+	bounds := image.Rect(0, 0, 700, 300) // Not a multiple of the tile size, so the edge tiles are smaller
+	dense := image.NewRGBA(bounds)
+	fillImageWithColor(dense, opaqueWhite)
+	tiled := newTiledCanvas(bounds, opaqueWhite)
+
+	for _, canvas := range []draw.Image{dense, tiled} {
+		paintCircle(canvas, image.Pt(250, 250), 40, red)
+		paintCircle(canvas, image.Pt(690, 10), 30, transparent)
+		interpolatePaintBetweenPoints(f32.Pt(10, 10), f32.Pt(600, 290), canvas, 5, blue)
+		canvas.Set(699, 299, red)
+	}
+
+	if err := floodFill(context.Background(), dense, image.Pt(100, 250), blue, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := floodFill(context.Background(), tiled, image.Pt(100, 250), blue, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	painted := tiled.RGBA()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a, b := painted.RGBAAt(x, y), dense.RGBAAt(x, y); a != b {
+				t.Fatalf("the tiled canvas is %v at (%d, %d), expected %v", a, x, y, b)
+			}
+		}
+	}
+}
+
+// Converting an image only allocates the tiles that aren't its solid color, and a clone shares them until it is
+// painted on.
+func TestTiledCanvasSharesSolidTiles(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 600))
+	fillImageWithColor(img, opaqueWhite)
+	paintCircle(img, image.Pt(100, 100), 10, red)
+
+	canvas := tiledCanvasFromRGBA(img)
+	if canvas.Solid != opaqueWhite || canvas.allocatedTiles() != 1 {
+		t.Fatalf("the canvas is solid %v with %d tiles, expected solid %v with 1 tile", canvas.Solid, canvas.allocatedTiles(), opaqueWhite)
+	}
+
+	clone := canvas.Clone()
+	if clone.tiles[0] != canvas.tiles[0] {
+		t.Error("the clone copied a tile before it was painted on")
+	}
+	paintCircle(clone, image.Pt(100, 100), 10, blue)
+	if clone.tiles[0] == canvas.tiles[0] {
+		t.Error("the clone painted on the tile it shares")
+	}
+	if c := canvas.RGBAAt(100, 100); c != opaqueRed {
+		t.Errorf("painting on the clone changed the canvas to %v", c)
+	}
+
+	// Replacing the solid color everywhere changes the color of the untouched tiles without allocating them.
+	if err := replaceColor(context.Background(), clone, image.Pt(900, 500), red, nil); err != nil {
+		t.Fatal(err)
+	}
+	if clone.Solid != opaqueRed || clone.allocatedTiles() != 1 {
+		t.Errorf("the clone is solid %v with %d tiles, expected solid %v with 1 tile", clone.Solid, clone.allocatedTiles(), opaqueRed)
+	}
+	if c := clone.RGBAAt(100, 100); c != opaqueBlue {
+		t.Errorf("replacing the solid color changed the painted pixel to %v", c)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// ToolOptions holds the settings of the tools that have any, shown in a panel above the canvas while the tool is selected.
+type ToolOptions struct {
+	wandTolerance  widget.Float // 0 to 1, scaled to a channel difference of 0 to 255
+	wandContiguous widget.Bool
+
+	eyedropperSize   widget.Enum // The name of one of eyedropperSizes
+	eyedropperSource widget.Enum // SampleCurrentLayer or SampleMerged
+
+	shapeMode widget.Enum // A ShapeMode
+
+	penPaintButton  widget.Clickable
+	penKeepButton   widget.Clickable
+	penCancelButton widget.Clickable
+
+	textEditor            widget.Editor // What the text being edited says
+	textFont              string        // The name of a TextFont
+	textSize              float64
+	textLineSpacing       float64
+	textAlignment         widget.Enum // A TextAlignment
+	textSizeEditor        widget.Editor
+	textLineSpacingEditor widget.Editor
+	previousFontButton    widget.Clickable
+	nextFontButton        widget.Clickable
+	loadFontButton        widget.Clickable
+	textDoneButton        widget.Clickable
+	textRasterizeButton   widget.Clickable
+
+	transformEditors [7]widget.Editor // X, Y, width %, height %, angle, horizontal skew, vertical skew
+	transformFilter  widget.Enum
+	applyButton      widget.Clickable
+	cancelButton     widget.Clickable
+}
+
+var defaultWandTolerance float32 = 32
+
+func newToolOptions() ToolOptions {
+	options := ToolOptions{}
+	options.wandTolerance.Value = defaultWandTolerance / 255
+	options.wandContiguous.Value = true
+	options.transformFilter.Value = Bicubic.Name
+	options.eyedropperSize.Value = eyedropperSizes[0].Name
+	options.eyedropperSource.Value = SampleCurrentLayer
+	options.shapeMode.Value = string(ShapeStroke)
+	options.textFont = defaultTextFont
+	options.textSize = defaultTextSize
+	options.textLineSpacing = defaultTextLineSpacing
+	options.textAlignment.Value = string(AlignLeft)
+	for _, editor := range []*widget.Editor{&options.textSizeEditor, &options.textLineSpacingEditor} {
+		editor.SingleLine = true
+		editor.Filter = ".0123456789"
+	}
+	for i := range options.transformEditors {
+		options.transformEditors[i].SingleLine = true
+		options.transformEditors[i].Filter = "-.0123456789"
+	}
+	return options
+}
+
+func (o *ToolOptions) wandToleranceValue() int {
+	return int(o.wandTolerance.Value*255 + 0.5)
+}
+
+func layoutToolOptions(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	options := &state.toolOptions
+
+	var content layout.Widget
+	switch state.selectedTool {
+	case MagicWand:
+		content = func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(material.Body1(theme, fmt.Sprintf("Tolerance: %d", options.wandToleranceValue())).Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(unit.Dp(200))
+					gtx.Constraints.Max.X = gtx.Constraints.Min.X
+					return material.Slider(theme, &options.wandTolerance).Layout(gtx)
+				}),
+				layout.Rigid(material.CheckBox(theme, &options.wandContiguous, "Contiguous").Layout),
+			)
+		}
+	case Eyedropper, Brush:
+		content = func(gtx layout.Context) layout.Dimensions {
+			var children []layout.FlexChild
+			if state.selectedTool == Brush { // The brush picks colors with Alt-click
+				children = append(children, layout.Rigid(material.Body1(theme, "Alt-click picks: ").Layout))
+			}
+			for _, size := range eyedropperSizes {
+				children = append(children, layout.Rigid(material.RadioButton(theme, &options.eyedropperSize, size.Name, size.Name).Layout))
+			}
+			children = append(children, layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout))
+			for _, source := range eyedropperSources {
+				children = append(children, layout.Rigid(material.RadioButton(theme, &options.eyedropperSource, source, source).Layout))
+			}
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+		}
+	case Line, RectangleShape, EllipseShape, PolygonShape:
+		content = func(gtx layout.Context) layout.Dimensions {
+			children := []layout.FlexChild{
+				layout.Rigid(material.Body1(theme, fmt.Sprintf("Width: %.0f px", shapeWidth(state))).Layout),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+			}
+			if state.selectedTool != Line { // A line can only be stroked
+				for _, mode := range shapeModes {
+					children = append(children, layout.Rigid(material.RadioButton(theme, &options.shapeMode, string(mode), string(mode)).Layout))
+				}
+			}
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+		}
+	case Pen:
+		content = func(gtx layout.Context) layout.Dimensions {
+			return layoutPenOptions(gtx, state, theme)
+		}
+	case Text:
+		content = func(gtx layout.Context) layout.Dimensions {
+			return layoutTextOptions(gtx, state, theme)
+		}
+	case Transform:
+		if state.freeTransform == nil {
+			content = material.Body1(theme, "Click the canvas to transform the selection or the active layer").Layout
+			break
+		}
+		content = func(gtx layout.Context) layout.Dimensions {
+			return layoutTransformOptions(gtx, state, theme)
+		}
+	default:
+		return layout.Dimensions{}
+	}
+
+	return layoutPanel(gtx, options, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(8).Layout(gtx, content)
+	})
+}
+
+// layoutPenOptions shows how the path will be painted, and what can be done with it once it is drawn.
+func layoutPenOptions(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	options := &state.toolOptions
+
+	if options.penPaintButton.Clicked(gtx) && state.job == nil {
+		paintPenPath(state)
+	}
+	if options.penKeepButton.Clicked(gtx) {
+		keepPenPath(state)
+	}
+	if options.penCancelButton.Clicked(gtx) {
+		cancelPen(state)
+	}
+
+	children := []layout.FlexChild{
+		layout.Rigid(material.Body1(theme, fmt.Sprintf("Width: %.0f px", shapeWidth(state))).Layout),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+	}
+	for _, mode := range shapeModes {
+		children = append(children, layout.Rigid(material.RadioButton(theme, &options.shapeMode, string(mode), string(mode)).Layout))
+	}
+
+	if len(state.penTool.path.Anchors) > 0 {
+		paintButton := material.Button(theme, &options.penPaintButton, "Paint")
+		paintButton.Background = golangBlue
+		keepButton := material.Button(theme, &options.penKeepButton, "Keep as Vector")
+		keepButton.Background = golangBlue
+		cancelButton := material.Button(theme, &options.penCancelButton, "Cancel")
+		cancelButton.Background = darkGray
+		children = append(children,
+			layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, cancelButton, keepButton, paintButton)
+			}),
+		)
+	}
+
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+}
+
+// layoutTextOptions shows the text being edited, where it is typed, and how it is set. Changes are applied to
+// the text layer as they are made.
+func layoutTextOptions(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	options := &state.toolOptions
+	tool := &state.textTool
+
+	if options.previousFontButton.Clicked(gtx) {
+		index := max(fontIndex(state.fonts, options.textFont), 0)
+		options.textFont = state.fonts[(index+len(state.fonts)-1)%len(state.fonts)].Name
+	}
+	if options.nextFontButton.Clicked(gtx) {
+		index := max(fontIndex(state.fonts, options.textFont), 0)
+		options.textFont = state.fonts[(index+1)%len(state.fonts)].Name
+	}
+	if options.loadFontButton.Clicked(gtx) {
+		loadFont(state)
+	}
+	if options.textDoneButton.Clicked(gtx) {
+		finishText(state)
+	}
+	if options.textRasterizeButton.Clicked(gtx) && state.job == nil {
+		rasterizeEditedText(state)
+	}
+
+	if tool.focusEditor {
+		gtx.Execute(key.FocusCmd{Tag: &options.textEditor})
+		tool.focusEditor = false
+	}
+	if tool.layer == nil && gtx.Focused(&options.textEditor) { // Typing shouldn't go to a text that is finished
+		gtx.Execute(key.FocusCmd{})
+	}
+
+	syncNumberEditor(gtx, &options.textSizeEditor, options.textSize, func(typed float64) {
+		options.textSize = min(max(typed, 1), 1000)
+	})
+	syncNumberEditor(gtx, &options.textLineSpacingEditor, options.textLineSpacing, func(typed float64) {
+		options.textLineSpacing = min(max(typed, 0.5), 5)
+	})
+
+	fontButton := func(clickable *widget.Clickable, label string) layout.Widget {
+		button := material.Button(theme, clickable, label)
+		button.Background = lightGray
+		button.Color = darkGray
+		return button.Layout
+	}
+	numberEditor := func(label string, editor *widget.Editor) []layout.FlexChild {
+		return []layout.FlexChild{
+			layout.Rigid(material.Body2(theme, label+" ").Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(unit.Dp(48))
+				gtx.Constraints.Max.X = gtx.Constraints.Min.X
+				return layout.Inset{Right: 8}.Layout(gtx, material.Editor(theme, editor, "").Layout)
+			}),
+		}
+	}
+
+	var children []layout.FlexChild
+	if tool.layer != nil {
+		children = append(children,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(unit.Dp(240))
+				gtx.Constraints.Max.X = gtx.Constraints.Min.X
+				return material.Editor(theme, &options.textEditor, "Type the text").Layout(gtx)
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+		)
+	}
+
+	children = append(children,
+		layout.Rigid(fontButton(&options.previousFontButton, "<")),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(unit.Dp(160))
+			gtx.Constraints.Max.X = gtx.Constraints.Min.X
+			return layout.Center.Layout(gtx, material.Body1(theme, findFont(state.fonts, options.textFont).Name).Layout)
+		}),
+		layout.Rigid(fontButton(&options.nextFontButton, ">")),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+		layout.Rigid(fontButton(&options.loadFontButton, "Load Font")),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+	)
+	children = append(children, numberEditor("Size", &options.textSizeEditor)...)
+	children = append(children, numberEditor("Spacing", &options.textLineSpacingEditor)...)
+	for _, alignment := range textAlignments {
+		children = append(children, layout.Rigid(material.RadioButton(theme, &options.textAlignment, string(alignment), string(alignment)).Layout))
+	}
+
+	if tool.layer != nil {
+		doneButton := material.Button(theme, &options.textDoneButton, "Done")
+		doneButton.Background = golangBlue
+		rasterizeButton := material.Button(theme, &options.textRasterizeButton, "Rasterize")
+		rasterizeButton.Background = golangBlue
+		children = append(children,
+			layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutDialogButtons(gtx, theme, rasterizeButton, doneButton)
+			}),
+		)
+	}
+
+	dimensions := layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+
+	// The text was typed in while the options were laid out, after the canvas was drawn, so it is drawn again.
+	if updateEditedText(state) {
+		gtx.Execute(op.InvalidateCmd{})
+	}
+	return dimensions
+}
+
+// layoutTransformOptions shows the numbers of the free transform, which can also be typed in.
+func layoutTransformOptions(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	options := &state.toolOptions
+	t := state.freeTransform
+	size := t.source.Rect.Size()
+
+	if options.applyButton.Clicked(gtx) && state.job == nil {
+		applyFreeTransform(state, resampleFilterByName(options.transformFilter.Value))
+	}
+	if options.cancelButton.Clicked(gtx) && state.job == nil {
+		cancelFreeTransform(state)
+		return layout.Dimensions{}
+	}
+
+	fields := []struct {
+		label string
+		value *float64
+		scale float64 // What the value is multiplied by to show it, eg. 100 for percentages
+	}{
+		{"X", &t.params.centerX, 1},
+		{"Y", &t.params.centerY, 1},
+		{"W %", &t.params.scaleX, 100},
+		{"H %", &t.params.scaleY, 100},
+		{"Angle", &t.params.rotation, 1},
+		{"Skew H", &t.params.skewX, 1},
+		{"Skew V", &t.params.skewY, 1},
+	}
+
+	children := make([]layout.FlexChild, 0, len(fields)*2+len(resampleFilters)+2)
+	for i, field := range fields {
+		editor := &options.transformEditors[i]
+		syncNumberEditor(gtx, editor, *field.value*field.scale, func(typed float64) {
+			*field.value = typed / field.scale
+		})
+
+		children = append(children,
+			layout.Rigid(material.Body2(theme, field.label+" ").Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(unit.Dp(56))
+				gtx.Constraints.Max.X = gtx.Constraints.Min.X
+				return layout.Inset{Right: 8}.Layout(gtx, material.Editor(theme, editor, "").Layout)
+			}),
+		)
+	}
+
+	// A side of zero would squash the content to nothing.
+	t.params.scaleX = keepAwayFromZero(t.params.scaleX*float64(size.X), t.params.scaleX) / float64(size.X)
+	t.params.scaleY = keepAwayFromZero(t.params.scaleY*float64(size.Y), t.params.scaleY) / float64(size.Y)
+
+	for _, filter := range resampleFilters {
+		children = append(children, layout.Rigid(material.RadioButton(theme, &options.transformFilter, filter.Name, filter.Name).Layout))
+	}
+
+	applyButton := material.Button(theme, &options.applyButton, "Apply")
+	applyButton.Background = golangBlue
+	cancelButton := material.Button(theme, &options.cancelButton, "Cancel")
+	cancelButton.Background = darkGray
+	children = append(children,
+		layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layoutDialogButtons(gtx, theme, cancelButton, applyButton)
+		}),
+	)
+
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+}
+
+// syncNumberEditor shows value in editor while it isn't being typed in, and calls set with what is typed.
+func syncNumberEditor(gtx layout.Context, editor *widget.Editor, value float64, set func(typed float64)) {
+	for {
+		ev, ok := editor.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.ChangeEvent); !ok || !gtx.Focused(editor) {
+			continue
+		}
+
+		typed, err := strconv.ParseFloat(strings.TrimSpace(editor.Text()), 64)
+		if err == nil && !math.IsNaN(typed) && !math.IsInf(typed, 0) {
+			set(typed)
+		}
+	}
+
+	if gtx.Focused(editor) {
+		return
+	}
+	text := strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+	if editor.Text() != text {
+		editor.SetText(text)
+	}
+}
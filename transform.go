@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// remapRGBA builds a width x height image where every pixel is copied from the source pixel returned by source.
+// It is used for the lossless transforms: flips and rotations by multiples of 90 degrees.
+func remapRGBA(img *image.RGBA, width, height int, source func(x, y int) (int, int)) *image.RGBA {
+	remapped := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	parallelBands(remapped.Rect, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				sourceX, sourceY := source(x, y)
+				from := img.PixOffset(img.Rect.Min.X+sourceX, img.Rect.Min.Y+sourceY)
+				to := remapped.PixOffset(x, y)
+				copy(remapped.Pix[to:to+4], img.Pix[from:from+4])
+			}
+		}
+	})
+
+	return remapped
+}
+
+// losslessTransform is a flip or a rotation by a multiple of 90 degrees.
+type losslessTransform struct {
+	pixels func(img *image.RGBA) *image.RGBA
+	matrix func(w, h float64) affineMatrix // Maps a w x h image onto the transformed one, to move shapes with the pixels
+}
+
+var (
+	flipHorizontalTransform = losslessTransform{flipHorizontal, func(w, h float64) affineMatrix { return affineMatrix{-1, 0, w, 0, 1, 0} }}
+	flipVerticalTransform   = losslessTransform{flipVertical, func(w, h float64) affineMatrix { return affineMatrix{1, 0, 0, 0, -1, h} }}
+	rotate90Transform       = losslessTransform{rotate90, func(w, h float64) affineMatrix { return affineMatrix{0, -1, h, 1, 0, 0} }}
+	rotate180Transform      = losslessTransform{rotate180, func(w, h float64) affineMatrix { return affineMatrix{-1, 0, w, 0, -1, h} }}
+	rotate270Transform      = losslessTransform{rotate270, func(w, h float64) affineMatrix { return affineMatrix{0, 1, 0, -1, 0, w} }}
+)
+
+func flipHorizontal(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	return remapRGBA(img, w, h, func(x, y int) (int, int) { return w - 1 - x, y })
+}
+
+func flipVertical(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	return remapRGBA(img, w, h, func(x, y int) (int, int) { return x, h - 1 - y })
+}
+
+// rotate90 rotates img by 90 degrees clockwise.
+func rotate90(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	return remapRGBA(img, h, w, func(x, y int) (int, int) { return y, h - 1 - x })
+}
+
+func rotate180(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	return remapRGBA(img, w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y })
+}
+
+// rotate270 rotates img by 90 degrees counterclockwise.
+func rotate270(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	return remapRGBA(img, h, w, func(x, y int) (int, int) { return w - 1 - y, x })
+}
+
+// rotateRGBA rotates img clockwise by any angle around its center. When expand is true the result is made big
+// enough to hold the whole rotated image, otherwise it keeps the size of img and the corners are cut off.
+// Areas not covered by img are transparent.
+func rotateRGBA(ctx context.Context, img *image.RGBA, degrees float64, filter ResampleFilter, expand bool, reportProgress func(done float32)) (*image.RGBA, error) {
+	theta := degrees * math.Pi / 180
+	sin, cos := math.Sin(theta), math.Cos(theta)
+
+	w, h := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if expand {
+		const epsilon = 1e-6 // So that eg. a 90 degree rotation doesn't gain a pixel from rounding errors
+		width = int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin) - epsilon))
+		height = int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos) - epsilon))
+	}
+
+	rotated := image.NewRGBA(image.Rect(0, 0, width, height))
+	centerX, centerY := float64(width)/2, float64(height)/2
+
+	parallelBandsWithProgress(rotated.Rect, func(band image.Rectangle) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				// Rotate the center of the destination pixel backwards to find where it comes from.
+				rx, ry := float64(x)+0.5-centerX, float64(y)+0.5-centerY
+				sourceX := cos*rx + sin*ry + w/2
+				sourceY := -sin*rx + cos*ry + h/2
+
+				storeResampledPixel(rotated, x, y, sampleRGBA(img, sourceX, sourceY, filter))
+			}
+		}
+	}, reportProgress)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return rotated, nil
+}
+
+// sampleRGBA returns the filtered, premultiplied color of img at (x, y), where pixel centers are at +0.5.
+// Everything outside of img counts as transparent, which gives transformed images smooth edges.
+func sampleRGBA(img *image.RGBA, x, y float64, filter ResampleFilter) [4]float64 {
+	var pixel [4]float64
+
+	if filter.Support == 0 {
+		sourceX, sourceY := int(math.Floor(x)), int(math.Floor(y))
+		if sourceX < 0 || sourceY < 0 || sourceX >= img.Rect.Dx() || sourceY >= img.Rect.Dy() {
+			return pixel
+		}
+
+		offset := img.PixOffset(img.Rect.Min.X+sourceX, img.Rect.Min.Y+sourceY)
+		for channel := range pixel {
+			pixel[channel] = float64(img.Pix[offset+channel])
+		}
+		return pixel
+	}
+
+	startX, endX := int(math.Floor(x-filter.Support)), int(math.Ceil(x+filter.Support))
+	startY, endY := int(math.Floor(y-filter.Support)), int(math.Ceil(y+filter.Support))
+
+	total := 0.0
+	for sourceY := startY; sourceY <= endY; sourceY++ {
+		weightY := filter.Kernel(float64(sourceY) + 0.5 - y)
+		if weightY == 0 {
+			continue
+		}
+
+		for sourceX := startX; sourceX <= endX; sourceX++ {
+			weight := weightY * filter.Kernel(float64(sourceX)+0.5-x)
+			if weight == 0 {
+				continue
+			}
+			total += weight
+
+			if sourceX < 0 || sourceY < 0 || sourceX >= img.Rect.Dx() || sourceY >= img.Rect.Dy() {
+				continue
+			}
+
+			offset := img.PixOffset(img.Rect.Min.X+sourceX, img.Rect.Min.Y+sourceY)
+			for channel := range pixel {
+				pixel[channel] += float64(img.Pix[offset+channel]) * weight
+			}
+		}
+	}
+
+	if total != 0 {
+		for channel := range pixel {
+			pixel[channel] /= total
+		}
+	}
+
+	return pixel
+}
+
+// transformImageLosslessly applies a lossless transform to every layer as one undo step.
+func transformImageLosslessly(state *GemPaintState, transform losslessTransform) {
+	transformImage(state, transform.pixels, transform.matrix(float64(state.document.Width), float64(state.document.Height)))
+}
+
+// transformActiveLayer applies a lossless transform to the active layer only. If the transform changes the
+// layer's dimensions (eg. rotating a landscape layer by 90 degrees), the result is centered in the document.
+func transformActiveLayer(state *GemPaintState, transform losslessTransform) {
+	transformed := transform.pixels(activeLayer(state).Image.RGBA())
+	m := transform.matrix(float64(state.document.Width), float64(state.document.Height))
+
+	if transformed.Rect.Size() != state.document.Bounds().Size() {
+		center := image.Point{X: 1, Y: 1}
+		offset := canvasOffset(transformed.Rect.Size(), state.document.Width, state.document.Height, center)
+		transformed = resizeCanvasRGBA(transformed, state.document.Width, state.document.Height, center, transparent)
+		m = m.then(translationMatrix(float64(offset.X), float64(offset.Y)))
+	}
+
+	commitLayerTransform(state, transformed, m)
+}
+
+// rotateByAngle rotates the whole image (growing the canvas to fit) or only the active layer in the background.
+func rotateByAngle(state *GemPaintState, degrees float64, filter ResampleFilter, wholeImage bool) {
+	sources := []*image.RGBA{activeLayer(state).Image.RGBA()}
+	if wholeImage {
+		sources = layerImages(state.layers)
+	}
+
+	startJob(state, "Rotating", func(ctx context.Context, reportProgress func(done float32)) (func(state *GemPaintState), error) {
+		rotated := make([]*image.RGBA, len(sources))
+		for i, source := range sources {
+			layerProgress := func(done float32) {
+				reportProgress((float32(i) + done) / float32(len(sources)))
+			}
+
+			var err error
+			rotated[i], err = rotateRGBA(ctx, source, degrees, filter, wholeImage, layerProgress)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The pixels were rotated around the center of the source, which lands on the center of the result.
+		from, to := sources[0].Rect.Size(), rotated[0].Rect.Size()
+		m := translationMatrix(-float64(from.X)/2, -float64(from.Y)/2).
+			then(rotationMatrix(degrees)).
+			then(translationMatrix(float64(to.X)/2, float64(to.Y)/2))
+
+		return func(state *GemPaintState) {
+			if wholeImage {
+				commitImageChange(state, rotated, m)
+			} else {
+				commitLayerTransform(state, rotated[0], m)
+			}
+		}, nil
+	})
+}
+
+// affineMatrix maps (x, y) to (m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]).
+type affineMatrix [6]float64
+
+var identityMatrix = affineMatrix{1, 0, 0, 0, 1, 0}
+
+func translationMatrix(x, y float64) affineMatrix {
+	return affineMatrix{1, 0, x, 0, 1, y}
+}
+
+func scaleMatrix(x, y float64) affineMatrix {
+	return affineMatrix{x, 0, 0, 0, y, 0}
+}
+
+// rotationMatrix rotates clockwise on the screen, where y points down.
+func rotationMatrix(degrees float64) affineMatrix {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return affineMatrix{cos, -sin, 0, sin, cos, 0}
+}
+
+// skewMatrix slants x by y and y by x, by the tangents of the given angles.
+func skewMatrix(degreesX, degreesY float64) affineMatrix {
+	return affineMatrix{1, math.Tan(degreesX * math.Pi / 180), 0, math.Tan(degreesY * math.Pi / 180), 1, 0}
+}
+
+// then returns the matrix that applies m, then n.
+func (m affineMatrix) then(n affineMatrix) affineMatrix {
+	return affineMatrix{
+		n[0]*m[0] + n[1]*m[3], n[0]*m[1] + n[1]*m[4], n[0]*m[2] + n[1]*m[5] + n[2],
+		n[3]*m[0] + n[4]*m[3], n[3]*m[1] + n[4]*m[4], n[3]*m[2] + n[4]*m[5] + n[5],
+	}
+}
+
+func (m affineMatrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+func (m affineMatrix) applyVec(p vec2) vec2 {
+	x, y := m.apply(p.X, p.Y)
+	return vec2{X: x, Y: y}
+}
+
+// invert returns the matrix that undoes m. m must not squash the plane onto a line.
+func (m affineMatrix) invert() affineMatrix {
+	determinant := m[0]*m[4] - m[1]*m[3]
+	a, b, d, e := m[4]/determinant, -m[1]/determinant, -m[3]/determinant, m[0]/determinant
+	return affineMatrix{a, b, -(a*m[2] + b*m[5]), d, e, -(d*m[2] + e*m[5])}
+}
+
+// transformedBounds returns the smallest rectangle of whole pixels holding rect once mapped through m.
+func (m affineMatrix) transformedBounds(rect image.Rectangle) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range []image.Point{rect.Min, {X: rect.Max.X, Y: rect.Min.Y}, rect.Max, {X: rect.Min.X, Y: rect.Max.Y}} {
+		x, y := m.apply(float64(corner.X), float64(corner.Y))
+		minX, minY = min(minX, x), min(minY, y)
+		maxX, maxY = max(maxX, x), max(maxY, y)
+	}
+
+	const epsilon = 1e-6
+	return image.Rect(int(math.Floor(minX+epsilon)), int(math.Floor(minY+epsilon)), int(math.Ceil(maxX-epsilon)), int(math.Ceil(maxY-epsilon)))
+}
+
+// transformRGBA maps img through m onto a transparent image with the given bounds. m maps from the
+// coordinates of img to the coordinates of the result.
+func transformRGBA(ctx context.Context, img *image.RGBA, m affineMatrix, bounds image.Rectangle, filter ResampleFilter, reportProgress func(done float32)) (*image.RGBA, error) {
+	transformed := image.NewRGBA(bounds)
+	inverse := m.invert()
+	area := m.transformedBounds(img.Rect).Inset(-1).Intersect(bounds)
+
+	parallelBandsWithProgress(area, func(band image.Rectangle) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				// Map the center of the destination pixel backwards to find where it comes from.
+				sourceX, sourceY := inverse.apply(float64(x)+0.5, float64(y)+0.5)
+				sourceX -= float64(img.Rect.Min.X)
+				sourceY -= float64(img.Rect.Min.Y)
+
+				storeResampledPixel(transformed, x, y, sampleRGBA(img, sourceX, sourceY, filter))
+			}
+		}
+	}, reportProgress)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return transformed, nil
+}
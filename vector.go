@@ -0,0 +1,163 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// Vector shapes are kept on a layer as data, above its pixels, so that they can still be edited after they are
+// drawn. They are drawn with clip paths on screen, so they stay sharp at any zoom, and rasterized when the
+// layers are flattened, eg. for export.
+
+// PathAnchor is a point that a path goes through, with the control points of the curves on either side of it.
+// The control points are at the anchor itself for a sharp corner.
+type PathAnchor struct {
+	Point vec2
+	In    vec2 // Controls the curve arriving at the anchor
+	Out   vec2 // Controls the curve leaving the anchor
+}
+
+func cornerAnchor(point vec2) PathAnchor {
+	return PathAnchor{Point: point, In: point, Out: point}
+}
+
+// VectorPath is a chain of cubic Bézier curves going through its anchors.
+type VectorPath struct {
+	Anchors []PathAnchor
+	Closed  bool
+}
+
+// VectorShape is a path with the way it is painted.
+type VectorShape struct {
+	Path  VectorPath
+	Mode  ShapeMode
+	Color color.NRGBA
+	Width float64 // Of the stroke
+}
+
+// segments returns the start, the two control points and the end of every curve of the path.
+func (p VectorPath) segments() [][4]vec2 {
+	anchors := p.Anchors
+	var segments [][4]vec2
+	for i := 0; i+1 < len(anchors); i++ {
+		segments = append(segments, [4]vec2{anchors[i].Point, anchors[i].Out, anchors[i+1].In, anchors[i+1].Point})
+	}
+	if p.Closed && len(anchors) >= 2 {
+		first, last := anchors[0], anchors[len(anchors)-1]
+		segments = append(segments, [4]vec2{last.Point, last.Out, first.In, first.Point})
+	}
+	return segments
+}
+
+// polyline approximates the path with lines short enough that the curves look smooth.
+func (p VectorPath) polyline() []vec2 {
+	if len(p.Anchors) == 0 {
+		return nil
+	}
+
+	points := []vec2{p.Anchors[0].Point}
+	for _, s := range p.segments() {
+		length := distance(s[0], s[1]) + distance(s[1], s[2]) + distance(s[2], s[3]) // Never shorter than the curve
+		steps := int(min(max(math.Ceil(length/2), 1), 256))
+		for i := 1; i <= steps; i++ {
+			points = append(points, cubicBezierPoint(s, float64(i)/float64(steps)))
+		}
+	}
+
+	if p.Closed && len(points) > 1 { // The last curve ends where the path started
+		points = points[:len(points)-1]
+	}
+	return points
+}
+
+func cubicBezierPoint(s [4]vec2, t float64) vec2 {
+	u := 1 - t
+	a, b, c, d := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+	return vec2{
+		X: a*s[0].X + b*s[1].X + c*s[2].X + d*s[3].X,
+		Y: a*s[0].Y + b*s[1].Y + c*s[2].Y + d*s[3].Y,
+	}
+}
+
+func distance(a, b vec2) float64 {
+	return math.Hypot(b.X-a.X, b.Y-a.Y)
+}
+
+// coverage rasterizes the shape over bounds.
+func (s VectorShape) coverage(bounds image.Rectangle) *image.Alpha {
+	return polygonShapeCoverage(bounds, s.Path.polyline(), s.Path.Closed, s.Mode, s.Width)
+}
+
+// rasterizeShapes paints the shapes onto img.
+func rasterizeShapes(img *image.RGBA, shapes []VectorShape) {
+	for _, shape := range shapes {
+		draw.DrawMask(img, img.Rect, image.NewUniform(shape.Color), image.Point{}, shape.coverage(img.Rect), img.Rect.Min, draw.Over)
+	}
+}
+
+// layerPixels copies the image of the layer into a dense image, with its vector shapes painted in.
+func layerPixels(layer *Layer) *image.RGBA {
+	img := layer.Image.RGBA()
+	rasterizeShapes(img, layer.Shapes)
+	return img
+}
+
+// transformShapes returns shapes mapped through m, eg. to follow the pixels of their layer when the image is
+// cropped or rotated. Stroke widths are scaled by the average of how much m stretches each direction.
+func transformShapes(shapes []VectorShape, m affineMatrix) []VectorShape {
+	if len(shapes) == 0 {
+		return shapes
+	}
+
+	scale := math.Sqrt(math.Abs(m[0]*m[4] - m[1]*m[3]))
+	transformed := make([]VectorShape, len(shapes))
+	for i, shape := range shapes {
+		anchors := make([]PathAnchor, len(shape.Path.Anchors))
+		for j, anchor := range shape.Path.Anchors {
+			anchors[j] = PathAnchor{Point: m.applyVec(anchor.Point), In: m.applyVec(anchor.In), Out: m.applyVec(anchor.Out)}
+		}
+		shape.Path.Anchors = anchors
+		shape.Width *= scale
+		transformed[i] = shape
+	}
+	return transformed
+}
+
+// drawVectorShape draws the shape with Gio, which must be done under the view transform.
+func drawVectorShape(gtx layout.Context, shape VectorShape) {
+	if len(shape.Path.Anchors) == 0 {
+		return
+	}
+
+	// Fills are always closed, strokes only when the path is.
+	if (shape.Mode == ShapeFill || shape.Mode == ShapeStrokeAndFill) && len(shape.Path.Anchors) >= 2 {
+		closed := shape.Path
+		closed.Closed = true
+		paint.FillShape(gtx.Ops, shape.Color, clip.Outline{Path: vectorPathSpec(gtx, closed)}.Op())
+	}
+	if shape.Mode == ShapeStroke || shape.Mode == ShapeStrokeAndFill {
+		paint.FillShape(gtx.Ops, shape.Color, clip.Stroke{Path: vectorPathSpec(gtx, shape.Path), Width: float32(shape.Width)}.Op())
+	}
+}
+
+func vectorPathSpec(gtx layout.Context, p VectorPath) clip.PathSpec {
+	toF32 := func(v vec2) f32.Point { return f32.Pt(float32(v.X), float32(v.Y)) }
+
+	var path clip.Path
+	path.Begin(gtx.Ops)
+	path.MoveTo(toF32(p.Anchors[0].Point))
+	for _, s := range p.segments() {
+		path.CubeTo(toF32(s[1]), toF32(s[2]), toF32(s[3]))
+	}
+	if p.Closed {
+		path.Close()
+	}
+	return path.End()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// ViewTransform mirrors and rotates how the canvas is shown, without touching any pixels. It only changes
+// the view: pointer positions are mapped back through the inverse transform before painting.
+type ViewTransform struct {
+	mirrored bool
+
+	// The slider goes from -180° (0) to 180° (1).
+	rotationSlider widget.Float
+
+	mirrorButton      widget.Clickable
+	rotateLeftButton  widget.Clickable
+	rotateRightButton widget.Clickable
+	resetButton       widget.Clickable
+}
+
+var viewRotationStep float32 = 15
+
+func newViewTransform() ViewTransform {
+	return ViewTransform{rotationSlider: widget.Float{Value: 0.5}}
+}
+
+func (v *ViewTransform) rotationDegrees() float32 {
+	return v.rotationSlider.Value*360 - 180
+}
+
+func (v *ViewTransform) setRotationDegrees(degrees float32) {
+	// Wrap around, eg. 195° is shown as -165°
+	degrees = float32(math.Mod(float64(degrees)+540, 360)) - 180
+	v.rotationSlider.Value = (degrees + 180) / 360
+}
+
+func (v *ViewTransform) isIdentity() bool {
+	return !v.mirrored && v.rotationDegrees() == 0
+}
+
+// affine returns the transform from canvas pixels to screen pixels. The canvas is mirrored and rotated around its center.
+func (v *ViewTransform) affine(canvasSize image.Point) f32.Affine2D {
+	center := f32.Point{X: float32(canvasSize.X) / 2, Y: float32(canvasSize.Y) / 2}
+
+	transform := f32.Affine2D{}
+	if v.mirrored {
+		transform = transform.Scale(center, f32.Point{X: -1, Y: 1})
+	}
+	return transform.Rotate(center, v.rotationDegrees()*math.Pi/180)
+}
+
+// toCanvas maps a position on the screen back to the canvas pixel under it.
+func (v *ViewTransform) toCanvas(position f32.Point, canvasSize image.Point) f32.Point {
+	if v.isIdentity() {
+		return position
+	}
+	return v.affine(canvasSize).Invert().Transform(position)
+}
+
+func layoutViewControls(gtx layout.Context, state *GemPaintState, theme *material.Theme) layout.Dimensions {
+	view := &state.view
+
+	if view.mirrorButton.Clicked(gtx) {
+		view.mirrored = !view.mirrored
+	}
+	if view.rotateLeftButton.Clicked(gtx) {
+		view.setRotationDegrees(view.rotationDegrees() - viewRotationStep)
+	}
+	if view.rotateRightButton.Clicked(gtx) {
+		view.setRotationDegrees(view.rotationDegrees() + viewRotationStep)
+	}
+	if view.resetButton.Clicked(gtx) {
+		view.mirrored = false
+		view.setRotationDegrees(0)
+	}
+
+	return layoutPanel(gtx, view, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(8).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(ToolButton(theme, &view.mirrorButton, MirrorViewIcon, view.mirrored, golangBlue, lightGray, "Mirror view").Layout),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+				layout.Rigid(ToolButton(theme, &view.rotateLeftButton, RotateViewLeftIcon, false, golangBlue, lightGray, "Rotate view left").Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(unit.Dp(200))
+					gtx.Constraints.Max.X = gtx.Constraints.Min.X
+					return material.Slider(theme, &view.rotationSlider).Layout(gtx)
+				}),
+				layout.Rigid(ToolButton(theme, &view.rotateRightButton, RotateViewRightIcon, false, golangBlue, lightGray, "Rotate view right").Layout),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(unit.Dp(48))
+					return material.Body1(theme, fmt.Sprintf("%.0f°", view.rotationDegrees())).Layout(gtx)
+				}),
+				layout.Rigid(ToolButton(theme, &view.resetButton, ResetViewIcon, false, golangBlue, lightGray, "Reset view").Layout),
+			)
+		})
+	})
+}